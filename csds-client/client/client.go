@@ -9,16 +9,37 @@ import (
 //  implemented in version packages
 type ClientOptions struct {
 	Uri             string
+	UriFile         string
+	Parallelism     int
 	Platform        string
 	AuthnMode       string
 	RequestFile     string
 	RequestYaml     string
+	RequestName     string
 	Jwt             string
 	ConfigFile      string
 	MonitorInterval time.Duration
 	Visualization   bool
 	FilterMode      string
 	FilterPattern   string
+	Filter          string
+	Query           string
+	NoRedact        bool
+	RedactFields    []string
+	Summary         bool
+	GroupByMetadata []string
+	Source          string
+	AdminUri        string
+	ServePath       string
+	ListenAddress   string
+	NodeId          string
+	NodeIdPrefix    string
+	Metadata        []string
+	GcpProject      string
+	GcpNetwork      string
+	GcpMesh         string
+	HistoryDir      string
+	HooksFile       string
 }
 
 // Client implements CSDS Client of a particular version. Upon creation of the new client it is
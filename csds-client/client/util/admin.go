@@ -0,0 +1,57 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	_ "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// AdminTypeResolver resolves google.protobuf.Any types with the TypeResolver, and falls back to the
+// types linked into the binary, since the config dump of an Envoy admin has the types of all the
+// extensions that the proxy uses. Types that are not linked into the binary are not found.
+type AdminTypeResolver struct {
+	TypeResolver
+}
+
+func (r *AdminTypeResolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	if mt, err := r.TypeResolver.FindMessageByURL(url); err == nil && mt.Descriptor().FullName() != "google.protobuf.Any" {
+		return mt, nil
+	}
+	return protoregistry.GlobalTypes.FindMessageByURL(url)
+}
+
+func (r *AdminTypeResolver) FindMessageByName(message protoreflect.FullName) (protoreflect.MessageType, error) {
+	return protoregistry.GlobalTypes.FindMessageByName(message)
+}
+
+// AdminUrl returns the url of the path on the Envoy admin address, which is either host:port or a url
+func AdminUrl(adminUri string, path string) string {
+	if !strings.HasPrefix(adminUri, "http://") && !strings.HasPrefix(adminUri, "https://") {
+		adminUri = "http://" + adminUri
+	}
+	return strings.TrimSuffix(adminUri, "/") + path
+}
+
+// FetchConfigDump fetches /config_dump, including the EDS config, from the Envoy admin address
+func FetchConfigDump(adminUri string) ([]byte, error) {
+	httpClient := http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Get(AdminUrl(adminUri, "/config_dump?include_eds"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch config dump from %v: %v %v", adminUri, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
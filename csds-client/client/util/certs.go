@@ -0,0 +1,257 @@
+package util
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	envoy_extensions_transport_sockets_tls_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// TlsContextSummary is a TLS context of a cluster or of a filter chain of a listener of a client
+type TlsContextSummary struct {
+	Id string
+	// Resource is the cluster, the transport socket match of a cluster, or the filter chain of a
+	// listener of the context
+	Resource string
+	// Direction is upstream for an UpstreamTlsContext and downstream for a DownstreamTlsContext
+	Direction   string
+	Sni         string
+	SanMatchers []string
+	// SdsSecrets are the names of the SDS secrets of the certificates and of the validation context
+	SdsSecrets   []string
+	Certificates []CertificateSummary
+}
+
+// CertificateSummary is a certificate of a TLS context, inline or in a file
+type CertificateSummary struct {
+	// Source is certificate_chain for the certificates of the context, trusted_ca for the ones that
+	// validate the peer
+	Source string
+	// File is the path of the certificate if it is not inline, in which case it is not parsed
+	File     string
+	Subject  string
+	Issuer   string
+	NotAfter time.Time
+	// Expiring is set if the certificate expires within the window, or is expired
+	Expiring bool
+	// Error is set if the inline certificate cannot be parsed
+	Error string
+}
+
+// TlsContexts returns the TLS contexts of the clusters and listeners of the client by resource, with
+// their inline certificates parsed and flagged if they expire within the window from now
+func TlsContexts(c ClientConfig, now time.Time, window time.Duration) []TlsContextSummary {
+	var contexts []TlsContextSummary
+	add := func(resource string, socket *envoy_config_core_v3.TransportSocket) {
+		if context, ok := summarizeTlsContext(socket, now, window); ok {
+			context.Id = c.Id
+			context.Resource = resource
+			contexts = append(contexts, context)
+		}
+	}
+	for _, r := range c.Resources {
+		if r.Config == nil {
+			continue
+		}
+		m, err := DecodeResource(r.Config)
+		if err != nil {
+			continue
+		}
+		switch m := m.(type) {
+		case *envoy_config_cluster_v3.Cluster:
+			add("cluster "+m.GetName(), m.GetTransportSocket())
+			for _, match := range m.GetTransportSocketMatches() {
+				add(fmt.Sprintf("cluster %v match %v", m.GetName(), match.GetName()), match.GetTransportSocket())
+			}
+		case *envoy_config_listener_v3.Listener:
+			for i, chain := range m.GetFilterChains() {
+				name := chain.GetName()
+				if name == "" {
+					name = fmt.Sprintf("#%d", i)
+				}
+				add(fmt.Sprintf("listener %v chain %v", m.GetName(), name), chain.GetTransportSocket())
+			}
+			if chain := m.GetDefaultFilterChain(); chain != nil {
+				add(fmt.Sprintf("listener %v chain default", m.GetName()), chain.GetTransportSocket())
+			}
+		}
+	}
+	sort.SliceStable(contexts, func(i, j int) bool {
+		return contexts[i].Resource < contexts[j].Resource
+	})
+	return contexts
+}
+
+// summarizeTlsContext summarizes the UpstreamTlsContext or DownstreamTlsContext of the transport
+// socket, if it has one
+func summarizeTlsContext(socket *envoy_config_core_v3.TransportSocket, now time.Time, window time.Duration) (TlsContextSummary, bool) {
+	var s TlsContextSummary
+	var common *envoy_extensions_transport_sockets_tls_v3.CommonTlsContext
+	config := socket.GetTypedConfig()
+	switch {
+	case strings.HasSuffix(config.GetTypeUrl(), ".UpstreamTlsContext"):
+		tls := &envoy_extensions_transport_sockets_tls_v3.UpstreamTlsContext{}
+		if err := proto.Unmarshal(config.GetValue(), tls); err != nil {
+			return s, false
+		}
+		s.Direction = "upstream"
+		s.Sni = tls.GetSni()
+		common = tls.GetCommonTlsContext()
+	case strings.HasSuffix(config.GetTypeUrl(), ".DownstreamTlsContext"):
+		tls := &envoy_extensions_transport_sockets_tls_v3.DownstreamTlsContext{}
+		if err := proto.Unmarshal(config.GetValue(), tls); err != nil {
+			return s, false
+		}
+		s.Direction = "downstream"
+		common = tls.GetCommonTlsContext()
+	default:
+		return s, false
+	}
+
+	for _, certificate := range common.GetTlsCertificates() {
+		s.Certificates = append(s.Certificates, parseCertificates("certificate_chain", certificate.GetCertificateChain(), now, window)...)
+	}
+	for _, secret := range common.GetTlsCertificateSdsSecretConfigs() {
+		s.SdsSecrets = append(s.SdsSecrets, secret.GetName())
+	}
+	validation := common.GetValidationContext()
+	if secret := common.GetValidationContextSdsSecretConfig(); secret != nil {
+		s.SdsSecrets = append(s.SdsSecrets, secret.GetName())
+	}
+	if combined := common.GetCombinedValidationContext(); combined != nil {
+		validation = combined.GetDefaultValidationContext()
+		if secret := combined.GetValidationContextSdsSecretConfig(); secret != nil {
+			s.SdsSecrets = append(s.SdsSecrets, secret.GetName())
+		}
+	}
+	s.Certificates = append(s.Certificates, parseCertificates("trusted_ca", validation.GetTrustedCa(), now, window)...)
+	for _, matcher := range validation.GetMatchTypedSubjectAltNames() {
+		s.SanMatchers = append(s.SanMatchers, strings.ToLower(matcher.GetSanType().String())+" "+formatStringMatcher(matcher.GetMatcher()))
+	}
+	for _, matcher := range validation.GetMatchSubjectAltNames() {
+		s.SanMatchers = append(s.SanMatchers, formatStringMatcher(matcher))
+	}
+	return s, true
+}
+
+// parseCertificates parses the PEM certificates of an inline data source. The certificates in files
+// are not read, as they are on the host of the client.
+func parseCertificates(source string, data *envoy_config_core_v3.DataSource, now time.Time, window time.Duration) []CertificateSummary {
+	var pemData []byte
+	switch {
+	case data == nil:
+		return nil
+	case data.GetFilename() != "":
+		return []CertificateSummary{{Source: source, File: data.GetFilename()}}
+	case data.GetInlineBytes() != nil:
+		pemData = data.GetInlineBytes()
+	default:
+		pemData = []byte(data.GetInlineString())
+	}
+	var certificates []CertificateSummary
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c := CertificateSummary{Source: source}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			c.Error = err.Error()
+		} else {
+			c.Subject = cert.Subject.String()
+			c.Issuer = cert.Issuer.String()
+			c.NotAfter = cert.NotAfter
+			c.Expiring = !cert.NotAfter.After(now.Add(window))
+		}
+		certificates = append(certificates, c)
+	}
+	if len(certificates) == 0 {
+		certificates = append(certificates, CertificateSummary{Source: source, Error: "no PEM certificate found"})
+	}
+	return certificates
+}
+
+// formatStringMatcher formats a string matcher as kind=pattern, e.g. suffix=.example.com
+func formatStringMatcher(matcher *envoy_type_matcher_v3.StringMatcher) string {
+	var s string
+	switch {
+	case matcher.GetPrefix() != "":
+		s = "prefix=" + matcher.GetPrefix()
+	case matcher.GetSuffix() != "":
+		s = "suffix=" + matcher.GetSuffix()
+	case matcher.GetContains() != "":
+		s = "contains=" + matcher.GetContains()
+	case matcher.GetSafeRegex() != nil:
+		s = "regex=" + matcher.GetSafeRegex().GetRegex()
+	default:
+		s = "exact=" + matcher.GetExact()
+	}
+	if matcher.GetIgnoreCase() {
+		s += " (ignore case)"
+	}
+	return s
+}
+
+// PrintTlsContexts prints out the TLS contexts, each of them followed by its certificates. The
+// certificates that expire within the window are flagged.
+func PrintTlsContexts(contexts []TlsContextSummary, now time.Time, window time.Duration) {
+	if len(contexts) == 0 {
+		fmt.Println("No TLS contexts are reported")
+		return
+	}
+	row := func(columns ...interface{}) {
+		fmt.Println(strings.TrimRight(fmt.Sprintf("%-50s %-40s %-10s %-25s %-30s %v", columns...), " "))
+	}
+	join := func(values []string) string {
+		if len(values) == 0 {
+			return "-"
+		}
+		return strings.Join(values, ", ")
+	}
+	row("Client ID", "Resource", "TLS", "SNI", "SAN Matchers", "SDS Secrets")
+	expiring := 0
+	for _, c := range contexts {
+		sni := c.Sni
+		if sni == "" {
+			sni = "-"
+		}
+		row(c.Id, c.Resource, c.Direction, sni, join(c.SanMatchers), join(c.SdsSecrets))
+		for _, cert := range c.Certificates {
+			var detail string
+			switch {
+			case cert.File != "":
+				detail = "file " + cert.File
+			case cert.Error != "":
+				detail = "invalid certificate: " + cert.Error
+			default:
+				detail = fmt.Sprintf("subject=%q issuer=%q expires=%v", cert.Subject, cert.Issuer, cert.NotAfter.UTC().Format(time.RFC3339))
+				if !cert.NotAfter.After(now) {
+					detail += " EXPIRED"
+				} else if cert.Expiring {
+					detail += fmt.Sprintf(" EXPIRING in %dd", int(cert.NotAfter.Sub(now).Hours()/24))
+				}
+			}
+			if cert.Expiring {
+				expiring++
+			}
+			fmt.Printf("%-50s %-40s %v: %v\n", "", "", cert.Source, detail)
+		}
+	}
+	if expiring > 0 {
+		fmt.Printf("%d certificates are expired or expire within %v\n", expiring, window)
+	}
+}
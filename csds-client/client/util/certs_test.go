@@ -0,0 +1,57 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestTlsContexts tests the TLS contexts of the clusters and the filter chains, with their inline
+// certificates parsed and flagged when they expire within the window
+func TestTlsContexts(t *testing.T) {
+	clients := ReadClientConfigs(t, "./response_for_certs.json")
+	// the certificate of web expires on 2030-06-01, within the 30 days window, and the CA on 2035-01-01
+	now := time.Date(2030, 5, 20, 0, 0, 0, 0, time.UTC)
+	window := 30 * 24 * time.Hour
+	ca := CertificateSummary{
+		Source:   "trusted_ca",
+		Subject:  "CN=Example CA,O=Example",
+		Issuer:   "CN=Example CA,O=Example",
+		NotAfter: time.Date(2035, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	want := []TlsContextSummary{
+		{
+			Id:          "test_node_1",
+			Resource:    "cluster api match mtls",
+			Direction:   "upstream",
+			SanMatchers: []string{"prefix=spiffe://cluster.local/ns/api/"},
+			SdsSecrets:  []string{"default", "ROOTCA"},
+		},
+		{
+			Id:          "test_node_1",
+			Resource:    "cluster web",
+			Direction:   "upstream",
+			Sni:         "web.example.com",
+			SanMatchers: []string{"dns exact=web.example.com"},
+			Certificates: []CertificateSummary{
+				{
+					Source:   "certificate_chain",
+					Subject:  "CN=web.example.com",
+					Issuer:   "CN=Example CA,O=Example",
+					NotAfter: time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC),
+					Expiring: true,
+				},
+				ca,
+			},
+		},
+		{
+			Id:           "test_node_1",
+			Resource:     "listener ingress chain web",
+			Direction:    "downstream",
+			Certificates: []CertificateSummary{{Source: "certificate_chain", File: "/etc/certs/web.pem"}},
+		},
+	}
+	if got := TlsContexts(clients[0], now, window); !reflect.DeepEqual(got, want) {
+		t.Errorf("TlsContexts() = %+v, want %+v", got, want)
+	}
+}
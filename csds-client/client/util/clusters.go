@@ -0,0 +1,225 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_load_balancing_policies_least_request_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/load_balancing_policies/least_request/v3"
+	envoy_extensions_load_balancing_policies_ring_hash_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/load_balancing_policies/ring_hash/v3"
+	envoy_extensions_load_balancing_policies_wrr_locality_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/load_balancing_policies/wrr_locality/v3"
+	envoy_extensions_transport_sockets_tls_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// ClusterSummary is the compact view of the resilience settings of a cluster of a client
+type ClusterSummary struct {
+	Id   string
+	Name string
+	// Type is the discovery type, or the name of the custom cluster type
+	Type     string
+	LbPolicy string
+	// ConnectTimeout is 5s by default
+	ConnectTimeout string
+	// CircuitBreakers are the thresholds of the default priority as max connections, pending
+	// requests, requests and retries, with the defaults of Envoy for the ones that are not set
+	CircuitBreakers  string
+	OutlierDetection string
+	Tls              string
+	HealthChecks     string
+}
+
+// Clusters returns the summaries of the clusters of the client by name, v2 clusters are upgraded
+func Clusters(c ClientConfig) []ClusterSummary {
+	var summaries []ClusterSummary
+	for _, r := range c.Resources {
+		if r.Config == nil {
+			continue
+		}
+		m, err := DecodeResource(r.Config)
+		if err != nil {
+			continue
+		}
+		if cluster, ok := m.(*envoy_config_cluster_v3.Cluster); ok {
+			summaries = append(summaries, summarizeCluster(c.Id, cluster))
+		}
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
+
+// summarizeCluster summarizes the settings of the cluster
+func summarizeCluster(id string, cluster *envoy_config_cluster_v3.Cluster) ClusterSummary {
+	s := ClusterSummary{
+		Id:               id,
+		Name:             cluster.GetName(),
+		Type:             cluster.GetType().String(),
+		LbPolicy:         lbPolicy(cluster),
+		ConnectTimeout:   "5s",
+		CircuitBreakers:  circuitBreakers(cluster.GetCircuitBreakers()),
+		OutlierDetection: outlierDetection(cluster.GetOutlierDetection()),
+		Tls:              upstreamTls(cluster),
+		HealthChecks:     healthChecks(cluster.GetHealthChecks()),
+	}
+	if clusterType := cluster.GetClusterType(); clusterType != nil {
+		s.Type = clusterType.GetName()
+	}
+	if timeout := cluster.GetConnectTimeout(); timeout != nil {
+		s.ConnectTimeout = formatDuration(timeout)
+	}
+	return s
+}
+
+// lbPolicy returns the load balancing policy, the typed load_balancing_policy takes precedence over
+// lb_policy. The other policies of load_balancing_policy are the fallbacks if Envoy does not support
+// the first one.
+func lbPolicy(cluster *envoy_config_cluster_v3.Cluster) string {
+	if policies := cluster.GetLoadBalancingPolicy().GetPolicies(); len(policies) > 0 {
+		policy := typedLbPolicy(policies[0].GetTypedExtensionConfig().GetTypedConfig())
+		if len(policies) > 1 {
+			policy += fmt.Sprintf(" (+%d fallbacks)", len(policies)-1)
+		}
+		return policy
+	}
+	policy := strings.ToLower(cluster.GetLbPolicy().String())
+	switch cluster.GetLbPolicy() {
+	case envoy_config_cluster_v3.Cluster_LEAST_REQUEST:
+		if n := cluster.GetLeastRequestLbConfig().GetChoiceCount(); n != nil {
+			policy += fmt.Sprintf(" choices=%d", n.GetValue())
+		}
+	case envoy_config_cluster_v3.Cluster_RING_HASH:
+		if config := cluster.GetRingHashLbConfig(); config != nil {
+			policy += " " + strings.ToLower(config.GetHashFunction().String())
+		}
+	}
+	return policy
+}
+
+// typedLbPolicy returns the load balancing policy of a typed load_balancing_policy extension
+func typedLbPolicy(config *anypb.Any) string {
+	typeUrl := config.GetTypeUrl()
+	switch {
+	case strings.HasSuffix(typeUrl, ".RoundRobin"):
+		return "round_robin"
+	case strings.HasSuffix(typeUrl, ".LeastRequest"):
+		m := &envoy_extensions_load_balancing_policies_least_request_v3.LeastRequest{}
+		if err := proto.Unmarshal(config.GetValue(), m); err == nil && m.GetChoiceCount() != nil {
+			return fmt.Sprintf("least_request choices=%d", m.GetChoiceCount().GetValue())
+		}
+		return "least_request"
+	case strings.HasSuffix(typeUrl, ".RingHash"):
+		m := &envoy_extensions_load_balancing_policies_ring_hash_v3.RingHash{}
+		if err := proto.Unmarshal(config.GetValue(), m); err == nil {
+			return "ring_hash " + strings.ToLower(m.GetHashFunction().String())
+		}
+		return "ring_hash"
+	case strings.HasSuffix(typeUrl, ".WrrLocality"):
+		m := &envoy_extensions_load_balancing_policies_wrr_locality_v3.WrrLocality{}
+		if err := proto.Unmarshal(config.GetValue(), m); err == nil {
+			if policies := m.GetEndpointPickingPolicy().GetPolicies(); len(policies) > 0 {
+				return "wrr_locality(" + typedLbPolicy(policies[0].GetTypedExtensionConfig().GetTypedConfig()) + ")"
+			}
+		}
+		return "wrr_locality"
+	}
+	// the name of the message of an unknown policy
+	return typeUrl[strings.LastIndex(typeUrl, ".")+1:]
+}
+
+// circuitBreakers returns the thresholds of the default priority as max connections, pending
+// requests, requests and retries
+func circuitBreakers(breakers *envoy_config_cluster_v3.CircuitBreakers) string {
+	for _, thresholds := range breakers.GetThresholds() {
+		if thresholds.GetPriority() != envoy_config_core_v3.RoutingPriority_DEFAULT {
+			continue
+		}
+		return fmt.Sprintf("%d/%d/%d/%d", uint32Or(thresholds.GetMaxConnections(), 1024), uint32Or(thresholds.GetMaxPendingRequests(), 1024),
+			uint32Or(thresholds.GetMaxRequests(), 1024), uint32Or(thresholds.GetMaxRetries(), 3))
+	}
+	return "default"
+}
+
+// uint32Or returns the value, or the default value if it is not set
+func uint32Or(v *wrapperspb.UInt32Value, defaultValue uint32) uint32 {
+	if v == nil {
+		return defaultValue
+	}
+	return v.GetValue()
+}
+
+// outlierDetection returns the consecutive 5xx, base ejection time and max ejection percent of the
+// outlier detection, with the defaults of Envoy for the ones that are not set
+func outlierDetection(od *envoy_config_cluster_v3.OutlierDetection) string {
+	if od == nil {
+		return "off"
+	}
+	ejectionTime := "30s"
+	if od.GetBaseEjectionTime() != nil {
+		ejectionTime = formatDuration(od.GetBaseEjectionTime())
+	}
+	return fmt.Sprintf("5xx=%d eject=%v max=%d%%", uint32Or(od.GetConsecutive_5Xx(), 5), ejectionTime, uint32Or(od.GetMaxEjectionPercent(), 10))
+}
+
+// upstreamTls returns if the cluster connects with TLS, with the SNI if it is set
+func upstreamTls(cluster *envoy_config_cluster_v3.Cluster) string {
+	config := cluster.GetTransportSocket().GetTypedConfig()
+	if strings.HasSuffix(config.GetTypeUrl(), ".UpstreamTlsContext") {
+		tls := &envoy_extensions_transport_sockets_tls_v3.UpstreamTlsContext{}
+		if err := proto.Unmarshal(config.GetValue(), tls); err == nil && tls.GetSni() != "" {
+			return "on sni=" + tls.GetSni()
+		}
+		return "on"
+	}
+	if len(cluster.GetTransportSocketMatches()) > 0 {
+		return "by match"
+	}
+	return "off"
+}
+
+// healthChecks returns the kind of each health check with its interval
+func healthChecks(checks []*envoy_config_core_v3.HealthCheck) string {
+	if len(checks) == 0 {
+		return "-"
+	}
+	var kinds []string
+	for _, check := range checks {
+		kind := "custom"
+		switch {
+		case check.GetHttpHealthCheck() != nil:
+			kind = "http " + check.GetHttpHealthCheck().GetPath()
+		case check.GetGrpcHealthCheck() != nil:
+			kind = "grpc"
+		case check.GetTcpHealthCheck() != nil:
+			kind = "tcp"
+		}
+		if check.GetInterval() != nil {
+			kind += " every " + formatDuration(check.GetInterval())
+		}
+		kinds = append(kinds, kind)
+	}
+	return strings.Join(kinds, ", ")
+}
+
+// formatDuration formats a duration of the xDS api as a Go duration, e.g. 1.5s
+func formatDuration(d *durationpb.Duration) string {
+	return d.AsDuration().String()
+}
+
+// PrintClusters prints out the summaries of the clusters
+func PrintClusters(clusters []ClusterSummary) {
+	if len(clusters) == 0 {
+		fmt.Println("No clusters are reported")
+		return
+	}
+	fmt.Printf("%-50s %-40s %-14s %-30s %-8s %-20s %-28s %-20s %v\n", "Client ID", "Cluster", "Type", "LB Policy", "Timeout", "Circuit Breakers", "Outlier Detection", "TLS", "Health Checks")
+	for _, c := range clusters {
+		fmt.Printf("%-50s %-40s %-14s %-30s %-8s %-20s %-28s %-20s %v\n", c.Id, c.Name, c.Type, c.LbPolicy, c.ConnectTimeout, c.CircuitBreakers, c.OutlierDetection, c.Tls, c.HealthChecks)
+	}
+}
@@ -0,0 +1,22 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestClusters tests the summaries of the discovery, load balancing and resilience settings of the
+// clusters, sorted by name
+func TestClusters(t *testing.T) {
+	clients := ReadClientConfigs(t, "./response_for_clusters.json")
+	want := []ClusterSummary{
+		{Id: "test_node_1", Name: "cache", Type: "STRICT_DNS", LbPolicy: "ring_hash murmur_hash_2", ConnectTimeout: "5s", CircuitBreakers: "default", OutlierDetection: "off", Tls: "off", HealthChecks: "tcp every 5s"},
+		// the type of a cluster without type is STATIC
+		{Id: "test_node_1", Name: "legacy", Type: "STATIC", LbPolicy: "least_request choices=4", ConnectTimeout: "5s", CircuitBreakers: "default", OutlierDetection: "off", Tls: "off", HealthChecks: "-"},
+		// the thresholds of the default priority, with the defaults of the ones that are not set
+		{Id: "test_node_1", Name: "web", Type: "EDS", LbPolicy: "wrr_locality(least_request choices=3) (+1 fallbacks)", ConnectTimeout: "250ms", CircuitBreakers: "100/1024/200/3", OutlierDetection: "5xx=3 eject=1m0s max=10%", Tls: "on sni=web.example.com", HealthChecks: "http /healthz every 10s"},
+	}
+	if got := Clusters(clients[0]); !reflect.DeepEqual(got, want) {
+		t.Errorf("Clusters() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+package util
+
+import (
+	"fmt"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// XdsStatus is the config status of one xDS type reported for a client
+type XdsStatus struct {
+	Xds    string
+	Status string
+}
+
+// Resource is a single xDS resource reported for a client
+type Resource struct {
+	Xds          string
+	Name         string
+	Version      string
+	Status       string
+	ClientStatus string
+	Config       *anypb.Any
+	// Error is the details of the last update of the resource that the client rejected, if any
+	Error string
+}
+
+// ClientConfig is the api version independent view of a client in a CSDS response, so that the
+// views shared by v2 and v3 only need to be implemented once
+type ClientConfig struct {
+	Id               string
+	StreamType       string
+	Metadata         map[string]interface{}
+	Region           string
+	Zone             string
+	SubZone          string
+	UserAgentName    string
+	UserAgentVersion string
+	BuildVersion     string
+	Statuses         []XdsStatus
+	Resources        []Resource
+}
+
+// NewClientConfig creates a ClientConfig from the node of a client
+func NewClientConfig(node *envoy_config_core_v3.Node) ClientConfig {
+	c := ClientConfig{
+		Id:               node.GetId(),
+		Metadata:         node.GetMetadata().AsMap(),
+		Region:           node.GetLocality().GetRegion(),
+		Zone:             node.GetLocality().GetZone(),
+		SubZone:          node.GetLocality().GetSubZone(),
+		UserAgentName:    node.GetUserAgentName(),
+		UserAgentVersion: node.GetUserAgentVersion(),
+	}
+	// control plane is expected to use "XDS_STREAM_TYPE" to communicate
+	// the stream type of the connected client in the response.
+	if streamType, ok := c.Metadata["XDS_STREAM_TYPE"].(string); ok {
+		c.StreamType = streamType
+	}
+	if v := node.GetUserAgentBuildVersion().GetVersion(); v != nil {
+		c.BuildVersion = fmt.Sprintf("%d.%d.%d", v.GetMajorNumber(), v.GetMinorNumber(), v.GetPatch())
+	}
+	return c
+}
+
+// AddStatus records the config status of an xDS type, statuses of the same type are recorded once
+func (c *ClientConfig) AddStatus(xds string, status string) {
+	for _, s := range c.Statuses {
+		if s.Xds == xds && s.Status == status {
+			return
+		}
+	}
+	c.Statuses = append(c.Statuses, XdsStatus{Xds: xds, Status: status})
+}
+
+// Upgrade converts a v2 message to its v3 equivalent. The v3 xDS api is wire compatible with v2
+// for all the fields that are not deprecated, so the message is converted by its binary encoding.
+func Upgrade(src proto.Message, dst proto.Message) error {
+	b, err := proto.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(b, dst)
+}
+
+// XdsName returns the short name (e.g. LDS, CDS, ...) of the xDS type with the type url
+func XdsName(typeUrl string) string {
+	switch typeUrl {
+	case "type.googleapis.com/envoy.api.v2.Listener", "type.googleapis.com/envoy.config.listener.v3.Listener":
+		return "LDS"
+	case "type.googleapis.com/envoy.api.v2.RouteConfiguration", "type.googleapis.com/envoy.config.route.v3.RouteConfiguration":
+		return "RDS"
+	case "type.googleapis.com/envoy.api.v2.ScopedRouteConfiguration", "type.googleapis.com/envoy.config.route.v3.ScopedRouteConfiguration":
+		return "SRDS"
+	case "type.googleapis.com/envoy.api.v2.Cluster", "type.googleapis.com/envoy.config.cluster.v3.Cluster":
+		return "CDS"
+	case "type.googleapis.com/envoy.api.v2.ClusterLoadAssignment", "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment":
+		return "EDS"
+	default:
+		return ""
+	}
+}
+
+// DecodeResource decodes an xDS resource to its v3 message, v2 resources are upgraded to v3
+func DecodeResource(config *anypb.Any) (proto.Message, error) {
+	var m proto.Message
+	switch XdsName(config.GetTypeUrl()) {
+	case "LDS":
+		m = &envoy_config_listener_v3.Listener{}
+	case "RDS":
+		m = &envoy_config_route_v3.RouteConfiguration{}
+	case "SRDS":
+		m = &envoy_config_route_v3.ScopedRouteConfiguration{}
+	case "CDS":
+		m = &envoy_config_cluster_v3.Cluster{}
+	case "EDS":
+		m = &envoy_config_endpoint_v3.ClusterLoadAssignment{}
+	default:
+		return nil, fmt.Errorf("unsupported xDS resource type %v", config.GetTypeUrl())
+	}
+	if err := proto.Unmarshal(config.GetValue(), m); err != nil {
+		return nil, err
+	}
+	var err error
+	switch config.GetTypeUrl() {
+	case v2ClusterTypeUrl:
+		err = upgradeV2Cluster(config.GetValue(), m.(*envoy_config_cluster_v3.Cluster))
+	case v2ListenerTypeUrl:
+		err = upgradeV2Listener(config.GetValue(), m.(*envoy_config_listener_v3.Listener))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ResourceName returns the name of an xDS resource
+func ResourceName(config *anypb.Any) string {
+	m, err := DecodeResource(config)
+	if err != nil {
+		return ""
+	}
+	fields := m.ProtoReflect().Descriptor().Fields()
+	for _, name := range []protoreflect.Name{"name", "cluster_name"} {
+		if fd := fields.ByName(name); fd != nil && fd.Kind() == protoreflect.StringKind {
+			return m.ProtoReflect().Get(fd).String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,252 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Context is a named set of flag values in the contexts file, like a context in a kubeconfig
+type Context struct {
+	Name           string `json:"name"`
+	ServiceUri     string `json:"service_uri,omitempty"`
+	ServiceUriFile string `json:"service_uri_file,omitempty"`
+	Platform       string `json:"platform,omitempty"`
+	AuthnMode      string `json:"authn_mode,omitempty"`
+	JwtFile        string `json:"jwt_file,omitempty"`
+	ApiVersion     string `json:"api_version,omitempty"`
+	RequestFile    string `json:"request_file,omitempty"`
+	RequestYaml    string `json:"request_yaml,omitempty"`
+	RequestName    string `json:"request_name,omitempty"`
+}
+
+// ContextsFile is the file of the contexts, e.g.
+//
+//	current_context: prod
+//	contexts:
+//	- name: prod
+//	  service_uri: trafficdirector.googleapis.com:443
+//	  api_version: v3
+//	  request_file: prod_request.yaml
+type ContextsFile struct {
+	CurrentContext string    `json:"current_context,omitempty"`
+	Contexts       []Context `json:"contexts"`
+
+	// path is where the file is loaded from, the relative paths in the contexts are relative to it
+	path string
+}
+
+// DefaultContextsPath returns the default path of the contexts file, which is
+// $XDG_CONFIG_HOME/csds-client/config.yaml, or ~/.config/csds-client/config.yaml if it is not set
+func DefaultContextsPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "csds-client", "config.yaml")
+}
+
+// LoadContexts loads the contexts file at path, a file that does not exist has no context
+func LoadContexts(path string) (*ContextsFile, error) {
+	f := &ContextsFile{path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("invalid contexts file %v: %v", path, err)
+	}
+	return f, nil
+}
+
+// Context returns the context with the name, or the current context if the name is empty. It
+// returns nil if the name is empty and there is no current context.
+func (f *ContextsFile) Context(name string) (*Context, error) {
+	if name == "" {
+		name = f.CurrentContext
+	}
+	if name == "" {
+		return nil, nil
+	}
+	var names []string
+	for i := range f.Contexts {
+		if f.Contexts[i].Name == name {
+			return &f.Contexts[i], nil
+		}
+		names = append(names, f.Contexts[i].Name)
+	}
+	return nil, fmt.Errorf("context %v not found in %v, list of contexts: %v", name, f.path, strings.Join(names, ", "))
+}
+
+// FlagValues returns the values of the flags that the context sets, keyed by the flag names. The
+// relative paths are resolved against the directory of the contexts file.
+func (f *ContextsFile) FlagValues(c *Context) map[string]string {
+	path := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(filepath.Dir(f.path), p)
+	}
+	values := make(map[string]string)
+	for name, value := range map[string]string{
+		"service_uri":      c.ServiceUri,
+		"service_uri_file": path(c.ServiceUriFile),
+		"platform":         c.Platform,
+		"authn_mode":       c.AuthnMode,
+		"jwt_file":         path(c.JwtFile),
+		"api_version":      c.ApiVersion,
+		"request_file":     path(c.RequestFile),
+		"request_yaml":     c.RequestYaml,
+		"request_name":     c.RequestName,
+	} {
+		if value != "" {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// UseContext sets the current context in the contexts file at path. Only current_context is
+// changed in the file, so that its comments and layout are kept.
+func UseContext(path string, name string) error {
+	f, err := LoadContexts(path)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("missing context name")
+	}
+	if _, err := f.Context(name); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if data, err = setCurrentContext(data, name); err != nil {
+		return fmt.Errorf("invalid contexts file %v: %v", path, err)
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// setCurrentContext replaces the value of current_context in the yaml of the contexts file, or adds
+// current_context at the top of the mapping, and leaves the rest of the yaml as it is
+func setCurrentContext(data []byte, name string) ([]byte, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yamlv3.MappingNode {
+		return nil, errors.New("expected a mapping with the contexts")
+	}
+	root := doc.Content[0]
+	encoded, err := yamlv3.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	value := strings.TrimSuffix(string(encoded), "\n")
+	lines := strings.SplitAfter(string(data), "\n")
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, n := root.Content[i], root.Content[i+1]
+		if key.Value != "current_context" {
+			continue
+		}
+		if n.Kind != yamlv3.ScalarNode {
+			return nil, fmt.Errorf("line %d: current_context is not a string", n.Line)
+		}
+		if n.Value == "" && n.ShortTag() == "!!null" {
+			// an empty current_context has no text to replace, the value goes after the key
+			line := []rune(lines[key.Line-1])
+			colon := key.Column - 1 + len([]rune(key.Value))
+			for colon < len(line) && line[colon] != ':' {
+				colon++
+			}
+			if colon == len(line) {
+				return nil, fmt.Errorf("line %d: current_context has no value", key.Line)
+			}
+			lines[key.Line-1] = string(line[:colon+1]) + " " + value + string(line[colon+1:])
+			return []byte(strings.Join(lines, "")), nil
+		}
+		line := []rune(lines[n.Line-1])
+		start := n.Column - 1
+		end, err := scalarEnd(line, start, n)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: current_context: %v", n.Line, err)
+		}
+		lines[n.Line-1] = string(line[:start]) + value + string(line[end:])
+		return []byte(strings.Join(lines, "")), nil
+	}
+
+	line := []rune(lines[root.Line-1])
+	start := root.Column - 1
+	if root.Style&yamlv3.FlowStyle != 0 {
+		// {contexts: [...]}
+		lines[root.Line-1] = string(line[:start+1]) + "current_context: " + value + ", " + string(line[start+1:])
+	} else {
+		lines[root.Line-1] = string(line[:start]) + "current_context: " + value + "\n" + strings.Repeat(" ", start) + string(line[start:])
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// scalarEnd returns the end of the text of the scalar that starts at start in the line, the scalar
+// must be on one line
+func scalarEnd(line []rune, start int, n *yamlv3.Node) (int, error) {
+	switch n.Style {
+	case 0, yamlv3.TaggedStyle:
+		end := start + len([]rune(n.Value))
+		if end > len(line) || string(line[start:end]) != n.Value {
+			return 0, errors.New("the value must be on one line")
+		}
+		return end, nil
+	case yamlv3.DoubleQuotedStyle:
+		for i := start + 1; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1, nil
+			}
+		}
+	case yamlv3.SingleQuotedStyle:
+		for i := start + 1; i < len(line); i++ {
+			if line[i] != '\'' {
+				continue
+			}
+			if i+1 < len(line) && line[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	}
+	return 0, errors.New("the value must be on one line")
+}
+
+// PrintContexts prints out the names of the contexts, the current context is marked with *
+func PrintContexts(f *ContextsFile) {
+	if len(f.Contexts) == 0 {
+		fmt.Printf("No contexts in %v\n", f.path)
+		return
+	}
+	for _, c := range f.Contexts {
+		if c.Name == f.CurrentContext {
+			fmt.Printf("* %v\n", c.Name)
+		} else {
+			fmt.Printf("  %v\n", c.Name)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestContexts tests loading the contexts file and switching the current context
+func TestContexts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds-contexts")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(`# the contexts of the team
+current_context: "dev" # switched by use-context
+contexts:
+- name: dev
+  service_uri: localhost:18000
+  authn_mode: none
+  api_version: v3
+  request_file: dev_request.yaml
+- name: prod
+  service_uri: trafficdirector.googleapis.com:443
+  jwt_file: /etc/csds/jwt.json
+`), 0600); err != nil {
+		t.Fatalf("Write file error: %v", err)
+	}
+
+	contexts, err := LoadContexts(path)
+	if err != nil {
+		t.Fatalf("Load contexts error: %v", err)
+	}
+	ctx, err := contexts.Context("")
+	if err != nil || ctx.Name != "dev" {
+		t.Fatalf("want the current context dev, got %v, %v", ctx, err)
+	}
+	values := contexts.FlagValues(ctx)
+	want := map[string]string{
+		"service_uri":  "localhost:18000",
+		"authn_mode":   "none",
+		"api_version":  "v3",
+		"request_file": filepath.Join(dir, "dev_request.yaml"),
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("want flag values %v, got %v", want, values)
+	}
+	if _, err := contexts.Context("staging"); err == nil {
+		t.Errorf("want an error on an unknown context")
+	}
+
+	if err := UseContext(path, "prod"); err != nil {
+		t.Fatalf("Use context error: %v", err)
+	}
+	if contexts, err = LoadContexts(path); err != nil {
+		t.Fatalf("Load contexts error: %v", err)
+	}
+	if ctx, err := contexts.Context(""); err != nil || ctx.Name != "prod" || ctx.JwtFile != "/etc/csds/jwt.json" || len(contexts.Contexts) != 2 {
+		t.Errorf("want the current context prod with the contexts kept, got %v, %v", contexts, err)
+	}
+	// only current_context is changed in the file
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Read file error: %v", err)
+	}
+	wantFile := `# the contexts of the team
+current_context: prod # switched by use-context
+contexts:
+- name: dev
+  service_uri: localhost:18000
+  authn_mode: none
+  api_version: v3
+  request_file: dev_request.yaml
+- name: prod
+  service_uri: trafficdirector.googleapis.com:443
+  jwt_file: /etc/csds/jwt.json
+`
+	if string(data) != wantFile {
+		t.Errorf("Contexts file = \n%v\n, want: \n%v\n", string(data), wantFile)
+	}
+	// current_context is added to a file without one
+	if err := ioutil.WriteFile(path, []byte(wantFile[strings.Index(wantFile, "contexts:"):]), 0600); err != nil {
+		t.Fatalf("Write file error: %v", err)
+	}
+	if err := UseContext(path, "dev"); err != nil {
+		t.Fatalf("Use context error: %v", err)
+	}
+	if data, err = ioutil.ReadFile(path); err != nil || !strings.HasPrefix(string(data), "current_context: dev\ncontexts:\n- name: dev\n") {
+		t.Errorf("Contexts file = \n%v\n, want current_context dev at the top", string(data))
+	}
+
+	// a contexts file that does not exist has no context
+	if contexts, err = LoadContexts(filepath.Join(dir, "missing.yaml")); err != nil {
+		t.Fatalf("Load contexts error: %v", err)
+	}
+	if ctx, err := contexts.Context(""); ctx != nil || err != nil {
+		t.Errorf("want no context, got %v, %v", ctx, err)
+	}
+}
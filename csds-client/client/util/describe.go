@@ -0,0 +1,76 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PrintDescribe prints out the node of a client, its config statuses and its resources
+func PrintDescribe(c ClientConfig) {
+	fmt.Printf("%-18s %v\n", "Client ID:", c.Id)
+	if c.StreamType != "" {
+		fmt.Printf("%-18s %v\n", "xDS stream type:", c.StreamType)
+	}
+	var locality []string
+	for _, l := range []string{c.Region, c.Zone, c.SubZone} {
+		if l != "" {
+			locality = append(locality, l)
+		}
+	}
+	if len(locality) > 0 {
+		fmt.Printf("%-18s %v\n", "Locality:", strings.Join(locality, "/"))
+	}
+	if c.UserAgentName != "" {
+		fmt.Printf("%-18s %v\n", "User agent:", strings.TrimSpace(c.UserAgentName+" "+c.UserAgentVersion))
+	}
+	if c.BuildVersion != "" {
+		fmt.Printf("%-18s %v\n", "Build version:", c.BuildVersion)
+	}
+
+	if len(c.Metadata) > 0 {
+		fmt.Println("Metadata:")
+		keys := make([]string, 0, len(c.Metadata))
+		for key := range c.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value, ok := c.Metadata[key].(string)
+			if !ok {
+				js, _ := json.Marshal(c.Metadata[key])
+				value = string(js)
+			}
+			fmt.Printf("  %v: %v\n", key, value)
+		}
+	}
+
+	fmt.Println("Config Status:")
+	if len(c.Statuses) == 0 {
+		fmt.Println("  No config status is reported")
+	}
+	for _, status := range c.Statuses {
+		fmt.Printf("  %-8s %v\n", status.Xds, status.Status)
+	}
+
+	fmt.Println("Resources:")
+	if len(c.Resources) == 0 {
+		fmt.Println("  No resource is reported")
+		return
+	}
+	resources := append([]Resource{}, c.Resources...)
+	sort.SliceStable(resources, func(i, j int) bool {
+		if resources[i].Xds != resources[j].Xds {
+			return resources[i].Xds < resources[j].Xds
+		}
+		return resources[i].Name < resources[j].Name
+	})
+	fmt.Printf("  %-8s %-50s %-30s %-12s %v\n", "xDS", "Name", "Version", "Status", "Client Status")
+	for _, r := range resources {
+		fmt.Printf("  %-8s %-50s %-30s %-12s %v\n", r.Xds, r.Name, r.Version, r.Status, r.ClientStatus)
+		if r.Error != "" {
+			fmt.Printf("  %-8s rejected: %v\n", "", r.Error)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+)
+
+// the defaults of Envoy for the panic threshold and the overprovisioning factor, in percent
+const (
+	defaultPanicThreshold         = 50
+	defaultOverprovisioningFactor = 140
+)
+
+// EndpointCounts are the numbers of endpoints by health status. The endpoints of unknown health are
+// healthy, and the ones that timed out are unhealthy, as in Envoy.
+type EndpointCounts struct {
+	Healthy   int
+	Degraded  int
+	Unhealthy int
+	Draining  int
+}
+
+// Total returns the number of endpoints
+func (c EndpointCounts) Total() int {
+	return c.Healthy + c.Degraded + c.Unhealthy + c.Draining
+}
+
+func (c *EndpointCounts) add(other EndpointCounts) {
+	c.Healthy += other.Healthy
+	c.Degraded += other.Degraded
+	c.Unhealthy += other.Unhealthy
+	c.Draining += other.Draining
+}
+
+// LocalityEndpoints are the endpoints of a locality of a cluster
+type LocalityEndpoints struct {
+	// Locality is region/zone/sub_zone
+	Locality string
+	Priority uint32
+	// Weight is the load balancing weight of the locality, 0 if it is not set
+	Weight uint32
+	EndpointCounts
+}
+
+// PriorityEndpoints are the endpoints of a priority of a cluster
+type PriorityEndpoints struct {
+	Priority uint32
+	EndpointCounts
+	// Health is the percentage of healthy and degraded endpoints, from 0 to 100
+	Health float64
+	// Load is the percentage of the traffic that Envoy sends to the priority. The healthy endpoints
+	// scaled up by the overprovisioning factor take the load of a priority, and the rest spills over
+	// to the next priorities.
+	Load float64
+	// Panic is set if the health is below the panic threshold of the cluster, in which case Envoy
+	// balances the load of the priority on all of its endpoints regardless of their health
+	Panic bool
+}
+
+// ClusterEndpoints are the endpoints of a cluster of a client, from its ClusterLoadAssignment
+type ClusterEndpoints struct {
+	Id string
+	// Cluster is the name of the cluster, or the service name of the ClusterLoadAssignment if no
+	// cluster of the client refers to it
+	Cluster    string
+	Priorities []PriorityEndpoints
+	Localities []LocalityEndpoints
+	EndpointCounts
+	// PanicThreshold is the healthy panic threshold of the cluster in percent, 50 by default. The
+	// panic mode is disabled if it is 0.
+	PanicThreshold float64
+}
+
+// PanicPriorities returns the priorities of the cluster that are in panic
+func (e ClusterEndpoints) PanicPriorities() []uint32 {
+	var priorities []uint32
+	for _, p := range e.Priorities {
+		if p.Panic {
+			priorities = append(priorities, p.Priority)
+		}
+	}
+	return priorities
+}
+
+// Endpoints returns the endpoints of the clusters of the client by cluster name. A cluster load
+// assignment is matched to the EDS clusters with it as service name, or to the cluster of the same
+// name.
+func Endpoints(c ClientConfig) []ClusterEndpoints {
+	clusters := make(map[string][]*envoy_config_cluster_v3.Cluster)
+	var assignments []*envoy_config_endpoint_v3.ClusterLoadAssignment
+	for _, r := range c.Resources {
+		if r.Config == nil {
+			continue
+		}
+		m, err := DecodeResource(r.Config)
+		if err != nil {
+			continue
+		}
+		switch m := m.(type) {
+		case *envoy_config_cluster_v3.Cluster:
+			serviceName := m.GetEdsClusterConfig().GetServiceName()
+			if serviceName == "" {
+				serviceName = m.GetName()
+			}
+			clusters[serviceName] = append(clusters[serviceName], m)
+		case *envoy_config_endpoint_v3.ClusterLoadAssignment:
+			assignments = append(assignments, m)
+		}
+	}
+
+	var result []ClusterEndpoints
+	for _, cla := range assignments {
+		matched := clusters[cla.GetClusterName()]
+		if len(matched) == 0 {
+			result = append(result, clusterEndpoints(c.Id, cla.GetClusterName(), nil, cla))
+		}
+		for _, cluster := range matched {
+			result = append(result, clusterEndpoints(c.Id, cluster.GetName(), cluster, cla))
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Cluster < result[j].Cluster
+	})
+	return result
+}
+
+// clusterEndpoints counts the endpoints of the cluster load assignment by locality and priority
+func clusterEndpoints(id string, name string, cluster *envoy_config_cluster_v3.Cluster, cla *envoy_config_endpoint_v3.ClusterLoadAssignment) ClusterEndpoints {
+	e := ClusterEndpoints{Id: id, Cluster: name, PanicThreshold: defaultPanicThreshold}
+	if threshold := cluster.GetCommonLbConfig().GetHealthyPanicThreshold(); threshold != nil {
+		e.PanicThreshold = threshold.GetValue()
+	}
+	factor := float64(defaultOverprovisioningFactor)
+	if f := cla.GetPolicy().GetOverprovisioningFactor(); f != nil {
+		factor = float64(f.GetValue())
+	}
+
+	priorities := make(map[uint32]*PriorityEndpoints)
+	for _, locality := range cla.GetEndpoints() {
+		l := LocalityEndpoints{
+			Locality: localityName(locality.GetLocality()),
+			Priority: locality.GetPriority(),
+			Weight:   locality.GetLoadBalancingWeight().GetValue(),
+		}
+		for _, endpoint := range locality.GetLbEndpoints() {
+			switch endpoint.GetHealthStatus() {
+			case envoy_config_core_v3.HealthStatus_UNKNOWN, envoy_config_core_v3.HealthStatus_HEALTHY:
+				l.Healthy++
+			case envoy_config_core_v3.HealthStatus_DEGRADED:
+				l.Degraded++
+			case envoy_config_core_v3.HealthStatus_DRAINING:
+				l.Draining++
+			default:
+				l.Unhealthy++
+			}
+		}
+		e.Localities = append(e.Localities, l)
+		e.EndpointCounts.add(l.EndpointCounts)
+		if priorities[l.Priority] == nil {
+			priorities[l.Priority] = &PriorityEndpoints{Priority: l.Priority}
+		}
+		priorities[l.Priority].add(l.EndpointCounts)
+	}
+	sort.SliceStable(e.Localities, func(i, j int) bool {
+		if e.Localities[i].Priority != e.Localities[j].Priority {
+			return e.Localities[i].Priority < e.Localities[j].Priority
+		}
+		return e.Localities[i].Locality < e.Localities[j].Locality
+	})
+	for _, p := range priorities {
+		e.Priorities = append(e.Priorities, *p)
+	}
+	sort.Slice(e.Priorities, func(i, j int) bool {
+		return e.Priorities[i].Priority < e.Priorities[j].Priority
+	})
+
+	// a priority is in panic if its healthy and degraded endpoints are below the threshold, as
+	// isHostSetInPanic in Envoy
+	for i := range e.Priorities {
+		p := &e.Priorities[i]
+		if p.Total() > 0 {
+			p.Health = 100 * float64(p.Healthy+p.Degraded) / float64(p.Total())
+		}
+		p.Panic = e.PanicThreshold > 0 && p.Health < e.PanicThreshold
+	}
+
+	// the load of the priorities: the health of each priority is its healthy endpoints scaled up by
+	// the overprovisioning factor, and the loads are normalized if the sum of the health of the
+	// priorities is below 100%. All the load goes to the first priority if none is healthy.
+	var totalHealth float64
+	health := make([]float64, len(e.Priorities))
+	for i, p := range e.Priorities {
+		if p.Total() > 0 {
+			health[i] = minFloat(100, factor*float64(p.Healthy)/float64(p.Total()))
+		}
+		totalHealth += health[i]
+	}
+	totalHealth = minFloat(100, totalHealth)
+	remaining := 100.0
+	for i := range e.Priorities {
+		switch {
+		case totalHealth == 0:
+			if i == 0 {
+				e.Priorities[i].Load = 100
+			}
+		default:
+			e.Priorities[i].Load = minFloat(remaining, health[i]*100/totalHealth)
+			remaining -= e.Priorities[i].Load
+		}
+	}
+	return e
+}
+
+// localityName returns region/zone/sub_zone of the locality, without the empty trailing parts
+func localityName(locality *envoy_config_core_v3.Locality) string {
+	name := strings.TrimRight(strings.Join([]string{locality.GetRegion(), locality.GetZone(), locality.GetSubZone()}, "/"), "/")
+	if name == "" {
+		return "-"
+	}
+	return name
+}
+
+func minFloat(a float64, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// PrintEndpoints prints out the endpoints of the clusters, with each of their priorities followed
+// by its localities. The priorities in panic are flagged.
+func PrintEndpoints(clusters []ClusterEndpoints) {
+	if len(clusters) == 0 {
+		fmt.Println("No endpoints are reported")
+		return
+	}
+	row := func(columns ...interface{}) {
+		fmt.Println(strings.TrimRight(fmt.Sprintf("%-50s %-40s %-30s %-8v %-6v %-8v %-9v %-9v %-9v %-6v %v", columns...), " "))
+	}
+	row("Client ID", "Cluster", "Locality", "Priority", "Weight", "Healthy", "Degraded", "Unhealthy", "Draining", "Health", "Load")
+	for _, c := range clusters {
+		inPanic := ""
+		if priorities := c.PanicPriorities(); len(priorities) > 0 {
+			var names []string
+			for _, p := range priorities {
+				names = append(names, fmt.Sprint(p))
+			}
+			inPanic = fmt.Sprintf("PANIC in priority %v (threshold %.0f%%)", strings.Join(names, ", "), c.PanicThreshold)
+		}
+		row(c.Id, c.Cluster, "", "", "", c.Healthy, c.Degraded, c.Unhealthy, c.Draining, "", inPanic)
+		for _, p := range c.Priorities {
+			health := fmt.Sprintf("%.0f%%", p.Health)
+			load := fmt.Sprintf("%.0f%%", p.Load)
+			if p.Panic {
+				load += " PANIC"
+			}
+			row("", "", "", p.Priority, "", p.Healthy, p.Degraded, p.Unhealthy, p.Draining, health, load)
+			for _, l := range c.Localities {
+				if l.Priority != p.Priority {
+					continue
+				}
+				weight := "-"
+				if l.Weight > 0 {
+					weight = fmt.Sprint(l.Weight)
+				}
+				row("", "", l.Locality, "", weight, l.Healthy, l.Degraded, l.Unhealthy, l.Draining, "", "")
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestEndpoints tests the endpoints of the clusters by priority and locality, the load of the
+// priorities and the panic threshold, and how they are printed
+func TestEndpoints(t *testing.T) {
+	clients := ReadClientConfigs(t, "./response_for_endpoints.json")
+	clusters := Endpoints(clients[0])
+	want := []ClusterEndpoints{
+		{
+			// the panic mode of api is disabled by its threshold of 0
+			Id:      "test_node_1",
+			Cluster: "api",
+			Priorities: []PriorityEndpoints{
+				{Priority: 0, EndpointCounts: EndpointCounts{Unhealthy: 2}, Health: 0, Load: 100},
+			},
+			Localities: []LocalityEndpoints{
+				{Locality: "-", EndpointCounts: EndpointCounts{Unhealthy: 2}},
+			},
+			EndpointCounts: EndpointCounts{Unhealthy: 2},
+			PanicThreshold: 0,
+		},
+		{
+			Id:             "test_node_1",
+			Cluster:        "orphan_service",
+			PanicThreshold: 50,
+		},
+		{
+			// priority 0 has 4 healthy or degraded endpoints of 10, which is below the threshold of
+			// 50% even though its 3 healthy endpoints take 42% of the load with the overprovisioning
+			// factor of 140%, the rest spills over to priority 1
+			Id:      "test_node_1",
+			Cluster: "web",
+			Priorities: []PriorityEndpoints{
+				{Priority: 0, EndpointCounts: EndpointCounts{Healthy: 3, Degraded: 1, Unhealthy: 5, Draining: 1}, Health: 40, Load: 42, Panic: true},
+				{Priority: 1, EndpointCounts: EndpointCounts{Healthy: 1}, Health: 100, Load: 58},
+			},
+			Localities: []LocalityEndpoints{
+				{Locality: "us-east1/us-east1-b", Priority: 0, Weight: 100, EndpointCounts: EndpointCounts{Healthy: 2, Unhealthy: 3, Draining: 1}},
+				{Locality: "us-east1/us-east1-c", Priority: 0, Weight: 50, EndpointCounts: EndpointCounts{Healthy: 1, Degraded: 1, Unhealthy: 2}},
+				{Locality: "us-west1/us-west1-a", Priority: 1, EndpointCounts: EndpointCounts{Healthy: 1}},
+			},
+			EndpointCounts: EndpointCounts{Healthy: 4, Degraded: 1, Unhealthy: 5, Draining: 1},
+			PanicThreshold: 50,
+		},
+	}
+	if !reflect.DeepEqual(clusters, want) {
+		t.Errorf("Endpoints() = %+v, want %+v", clusters, want)
+	}
+
+	out := CaptureOutput(func() {
+		PrintEndpoints(clusters[2:])
+	})
+	row := func(columns ...interface{}) string {
+		return strings.TrimRight(fmt.Sprintf("%-50s %-40s %-30s %-8v %-6v %-8v %-9v %-9v %-9v %-6v %v", columns...), " ")
+	}
+	wantOut := []string{
+		row("Client ID", "Cluster", "Locality", "Priority", "Weight", "Healthy", "Degraded", "Unhealthy", "Draining", "Health", "Load"),
+		row("test_node_1", "web", "", "", "", 4, 1, 5, 1, "", "PANIC in priority 0 (threshold 50%)"),
+		row("", "", "", 0, "", 3, 1, 5, 1, "40%", "42% PANIC"),
+		row("", "", "us-east1/us-east1-b", "", "100", 2, 0, 3, 1, "", ""),
+		row("", "", "us-east1/us-east1-c", "", "50", 1, 1, 2, 0, "", ""),
+		row("", "", "", 1, "", 1, 0, 0, 0, "100%", "58%"),
+		row("", "", "us-west1/us-west1-a", "", "-", 1, 0, 0, 0, "", ""),
+	}
+	if got := strings.Split(strings.TrimSuffix(out, "\n"), "\n"); !reflect.DeepEqual(got, wantOut) {
+		t.Errorf("endpoints = \n%v\n, want: \n%v\n", strings.Join(got, "\n"), strings.Join(wantOut, "\n"))
+	}
+}
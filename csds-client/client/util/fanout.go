@@ -0,0 +1,112 @@
+package util
+
+import (
+	"bufio"
+	"envoy-tools/csds-client/client"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ControlPlaneKey is the node metadata key that tags each client with the control planes that
+// reported it, when the request is fanned out to more than one control plane
+const ControlPlaneKey = "CSDS_CONTROL_PLANE"
+
+// ServiceUris returns the uris of the control planes to connect to, which are listed one per line in
+// -service_uri_file if it is set, or are comma separated in -service_uri otherwise
+func ServiceUris(opts client.ClientOptions) ([]string, error) {
+	var candidates []string
+	if opts.UriFile != "" {
+		f, err := os.Open(opts.UriFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// lines starting with # are comments
+			if line := strings.TrimSpace(scanner.Text()); !strings.HasPrefix(line, "#") {
+				candidates = append(candidates, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else {
+		candidates = strings.Split(opts.Uri, ",")
+	}
+
+	var uris []string
+	seen := make(map[string]bool)
+	for _, uri := range candidates {
+		if uri = strings.TrimSpace(uri); uri != "" && !seen[uri] {
+			seen[uri] = true
+			uris = append(uris, uri)
+		}
+	}
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("missing service uri")
+	}
+	return uris, nil
+}
+
+// FanOut calls f for each of the n targets concurrently, with at most parallelism calls at a time.
+// If parallelism is not positive, all the calls run at the same time.
+func FanOut(n int, parallelism int, f func(i int)) {
+	if parallelism <= 0 || parallelism > n {
+		parallelism = n
+	}
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TagControlPlane adds the control plane uri to the ControlPlaneKey of the node metadata, and
+// returns the metadata and the number of control planes that the client is tagged with
+func TagControlPlane(md *structpb.Struct, uri string) (*structpb.Struct, int) {
+	if md == nil {
+		md = &structpb.Struct{}
+	}
+	if md.Fields == nil {
+		md.Fields = make(map[string]*structpb.Value)
+	}
+	var uris []string
+	if tagged := md.Fields[ControlPlaneKey].GetStringValue(); tagged != "" {
+		uris = strings.Split(tagged, ",")
+	}
+	for _, tagged := range uris {
+		if tagged == uri {
+			return md, len(uris)
+		}
+	}
+	uris = append(uris, uri)
+	sort.Strings(uris)
+	md.Fields[ControlPlaneKey] = structpb.NewStringValue(strings.Join(uris, ","))
+	return md, len(uris)
+}
+
+// PrintDuplicateClients warns on stderr about the clients that are reported by more than one control
+// plane, which usually means a misrouted xDS stream
+func PrintDuplicateClients(duplicates map[string]string) {
+	var ids []string
+	for id := range duplicates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		fmt.Fprintf(os.Stderr, "Warning: client %v is reported by more than one control plane: %v\n", id, duplicates[id])
+	}
+}
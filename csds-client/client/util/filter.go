@@ -0,0 +1,327 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Filter is a composable filter expression on the clients in a CSDS response, e.g.
+//
+//	zone=us-east1-b and CDS=STALE
+//	cluster=foo or (metadata.TRAFFICDIRECTOR_NETWORK_NAME~^prod- and not user_agent=envoy)
+type Filter interface {
+	// Match reports whether the client matches the filter
+	Match(c ClientConfig) bool
+}
+
+// filter fields on the node of a client
+var nodeFields = map[string]func(c ClientConfig) string{
+	"id":                 func(c ClientConfig) string { return c.Id },
+	"stream_type":        func(c ClientConfig) string { return c.StreamType },
+	"region":             func(c ClientConfig) string { return c.Region },
+	"zone":               func(c ClientConfig) string { return c.Zone },
+	"sub_zone":           func(c ClientConfig) string { return c.SubZone },
+	"user_agent":         func(c ClientConfig) string { return c.UserAgentName },
+	"user_agent_version": func(c ClientConfig) string { return c.UserAgentVersion },
+	"build_version":      func(c ClientConfig) string { return c.BuildVersion },
+}
+
+// filter fields on the presence of a named resource, mapped to the xDS type of the resource
+var resourceFields = map[string]string{
+	"listener":     "LDS",
+	"route":        "RDS",
+	"scoped_route": "SRDS",
+	"cluster":      "CDS",
+	"endpoint":     "EDS",
+}
+
+// filter fields on the config status of an xDS type
+var statusFields = map[string]bool{"LDS": true, "RDS": true, "SRDS": true, "CDS": true, "EDS": true}
+
+const metadataFieldPrefix = "metadata."
+
+type andFilter struct{ left, right Filter }
+
+func (f andFilter) Match(c ClientConfig) bool { return f.left.Match(c) && f.right.Match(c) }
+
+type orFilter struct{ left, right Filter }
+
+func (f orFilter) Match(c ClientConfig) bool { return f.left.Match(c) || f.right.Match(c) }
+
+type notFilter struct{ filter Filter }
+
+func (f notFilter) Match(c ClientConfig) bool { return !f.filter.Match(c) }
+
+// termFilter matches a single field against a value, with = for equality and ~ for regex
+type termFilter struct {
+	field string
+	op    string
+	value string
+	regex *regexp.Regexp
+}
+
+func (f termFilter) matchValue(value string) bool {
+	if f.regex != nil {
+		return f.regex.MatchString(value)
+	}
+	return value == f.value
+}
+
+func (f termFilter) Match(c ClientConfig) bool {
+	var matched bool
+	switch {
+	case strings.HasPrefix(f.field, metadataFieldPrefix):
+		var value string
+		if v, ok := c.Metadata[strings.TrimPrefix(f.field, metadataFieldPrefix)]; ok {
+			value = fmt.Sprint(v)
+		}
+		matched = f.matchValue(value)
+	case nodeFields[f.field] != nil:
+		matched = f.matchValue(nodeFields[f.field](c))
+	case statusFields[f.field]:
+		for _, status := range c.Statuses {
+			if status.Xds == f.field && f.matchValue(status.Status) {
+				matched = true
+				break
+			}
+		}
+	default:
+		for _, resource := range c.Resources {
+			if resource.Xds == resourceFields[f.field] && f.matchValue(resource.Name) {
+				matched = true
+				break
+			}
+		}
+	}
+	if f.op == "!=" {
+		return !matched
+	}
+	return matched
+}
+
+// ParseFilter parses a filter expression. Terms are field=value, field!=value or field~regex, and
+// can be combined with and, or, not and parentheses. The supported fields are:
+//
+//	id, stream_type, region, zone, sub_zone, user_agent, user_agent_version, build_version
+//	metadata.<key>                          the value of a node metadata key
+//	LDS, RDS, SRDS, CDS, EDS                the config status of the xDS type
+//	listener, route, scoped_route, cluster, endpoint   the name of a resource the client has
+func ParseFilter(expr string) (Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid filter %q: unexpected %q", expr, p.tokens[p.pos].text)
+	}
+	return f, nil
+}
+
+// FilterMetadataExacts returns the node metadata values that every client matching the filter must
+// have, so that they can be sent to the control plane in the NodeMatcher to narrow down the response.
+// The control plane matches them as strings, while the filter matches the text of any value, so only
+// the values that can only be the text of a string are returned, e.g. not 1.9 or true. The
+// ControlPlaneKey is left out, since it is only added by the client to the merged responses.
+func FilterMetadataExacts(f Filter) map[string]string {
+	exacts := make(map[string]string)
+	var collect func(f Filter)
+	collect = func(f Filter) {
+		switch t := f.(type) {
+		case andFilter:
+			collect(t.left)
+			collect(t.right)
+		case termFilter:
+			key := strings.TrimPrefix(t.field, metadataFieldPrefix)
+			if t.op == "=" && strings.HasPrefix(t.field, metadataFieldPrefix) && key != ControlPlaneKey && isStringOnly(t.value) {
+				exacts[key] = t.value
+			}
+		}
+	}
+	collect(f)
+	return exacts
+}
+
+// isStringOnly checks if the value can only be the text of a string metadata value, and not of a
+// number, a bool, a null, a list or a struct, nor of a missing key
+func isStringOnly(value string) bool {
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return false
+	}
+	switch value {
+	case "", "true", "false", "<nil>":
+		return false
+	}
+	return !strings.HasPrefix(value, "[") && !strings.HasPrefix(value, "map[")
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+// tokenizeFilter splits a filter expression into words, quoted strings, operators and parentheses
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		switch ch := expr[i]; {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			i++
+		case ch == '(' || ch == ')' || ch == '=' || ch == '~':
+			tokens = append(tokens, filterToken{text: string(ch)})
+			i++
+		case ch == '!':
+			if strings.HasPrefix(expr[i:], "!=") {
+				tokens = append(tokens, filterToken{text: "!="})
+				i += 2
+			} else {
+				tokens = append(tokens, filterToken{text: "not"})
+				i++
+			}
+		case strings.HasPrefix(expr[i:], "&&"):
+			tokens = append(tokens, filterToken{text: "and"})
+			i += 2
+		case strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, filterToken{text: "or"})
+			i += 2
+		case ch == '"' || ch == '\'':
+			end := strings.IndexByte(expr[i+1:], ch)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid filter %q: unterminated string", expr)
+			}
+			tokens = append(tokens, filterToken{text: expr[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		default:
+			start := i
+			for i < len(expr) && !strings.ContainsRune(" \t\n()=~!&|\"'", rune(expr[i])) {
+				i++
+			}
+			if i == start {
+				return nil, fmt.Errorf("invalid filter %q: unexpected %q", expr, expr[i])
+			}
+			tokens = append(tokens, filterToken{text: expr[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// keyword reports whether the next token is the unquoted keyword and consumes it if so
+func (p *filterParser) keyword(keyword string) bool {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	if p.keyword("not") {
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{f}, nil
+	}
+	if p.keyword("(") {
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword(")") {
+			return nil, fmt.Errorf("invalid filter: missing )")
+		}
+		return f, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *filterParser) parseTerm() (Filter, error) {
+	if p.pos+3 > len(p.tokens) {
+		return nil, fmt.Errorf("invalid filter: expect field=value, field!=value or field~regex at the end")
+	}
+	field, op, value := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+	p.pos += 3
+
+	if field.quoted || !isFilterField(field.text) {
+		return nil, fmt.Errorf("invalid filter: unknown field %q, supported fields: %v", field.text, strings.Join(filterFields(), ", "))
+	}
+	f := termFilter{field: field.text, op: op.text, value: value.text}
+	switch op.text {
+	case "=", "!=":
+	case "~":
+		regex, err := regexp.Compile(value.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter: %v", err)
+		}
+		f.regex = regex
+	default:
+		return nil, fmt.Errorf("invalid filter: expect =, != or ~ after %q, got %q", field.text, op.text)
+	}
+	return f, nil
+}
+
+func isFilterField(field string) bool {
+	if strings.HasPrefix(field, metadataFieldPrefix) {
+		return len(field) > len(metadataFieldPrefix)
+	}
+	_, resource := resourceFields[field]
+	return nodeFields[field] != nil || statusFields[field] || resource
+}
+
+// filterFields returns the supported fields in order for error messages
+func filterFields() []string {
+	var fields []string
+	for field := range nodeFields {
+		fields = append(fields, field)
+	}
+	for field := range resourceFields {
+		fields = append(fields, field)
+	}
+	for field := range statusFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return append(fields, metadataFieldPrefix+"<key>")
+}
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestFilter tests filtering clients with filter expressions
+func TestFilter(t *testing.T) {
+	configs := ReadClientConfigs(t, "./response_for_summary.json")
+
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{"zone=us-east1-b and CDS=STALE", []string{"test_node_2"}},
+		{"cluster=fake_cluster", []string{"test_node_1", "test_node_2", "test_node_3"}},
+		{"listener=fake_listener and not (user_agent=envoy)", []string{"test_node_1"}},
+		{"metadata.TRAFFICDIRECTOR_NETWORK_NAME~^other || region='us-east1'", []string{"test_node_2", "test_node_3"}},
+		{"stream_type!=ADS && LDS=SYNCED", nil},
+	}
+	for _, test := range tests {
+		filter, err := ParseFilter(test.filter)
+		if err != nil {
+			t.Errorf("Parse filter %q error: %v", test.filter, err)
+			continue
+		}
+		var got []string
+		for _, config := range configs {
+			if filter.Match(config) {
+				got = append(got, config.Id)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("filter %q = %v, want %v", test.filter, got, test.want)
+		}
+	}
+
+	for _, invalid := range []string{"zone", "unknown_field=foo", "id=foo and", "(id=foo", "id~[", "id=foo bar"} {
+		if _, err := ParseFilter(invalid); err == nil {
+			t.Errorf("Parse filter %q should fail", invalid)
+		}
+	}
+}
+
+// TestFilterMetadataExacts tests the node metadata values of a filter that are sent to the control
+// plane, which are only the exact matches on values that can only be strings
+func TestFilterMetadataExacts(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   map[string]string
+	}{
+		{"metadata.NETWORK=fake_network and metadata.INSTANCE_IP=10.0.0.1", map[string]string{"NETWORK": "fake_network", "INSTANCE_IP": "10.0.0.1"}},
+		{"metadata.ISTIO_VERSION=1.9 and metadata.ENABLED=true and metadata.EMPTY=''", map[string]string{}},
+		{"metadata.CSDS_CONTROL_PLANE=localhost:18000 and CDS=STALE and zone=us-east1-b", map[string]string{}},
+		{"metadata.NETWORK!=fake_network and metadata.ZONE~^us", map[string]string{}},
+		{"metadata.NETWORK=fake_network or metadata.ZONE=a", map[string]string{}},
+		{"not metadata.NETWORK=fake_network", map[string]string{}},
+	}
+	for _, test := range tests {
+		filter, err := ParseFilter(test.filter)
+		if err != nil {
+			t.Errorf("Parse filter %q error: %v", test.filter, err)
+			continue
+		}
+		if got := FilterMetadataExacts(filter); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("FilterMetadataExacts(%q) = %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
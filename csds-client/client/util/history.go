@@ -0,0 +1,458 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// the kinds of the changes recorded in the history
+const (
+	HistoryAdded   = "added"
+	HistoryChanged = "changed"
+	HistoryRemoved = "removed"
+)
+
+// HistoryChange is a change of a resource, or of the node of a client, recorded in the history
+type HistoryChange struct {
+	Time time.Time `json:"time"`
+	Id   string    `json:"id"`
+	// Xds and Name are empty for the node of the client, i.e. its node fields and config statuses
+	Xds          string `json:"xds,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Change       string `json:"change"`
+	Version      string `json:"version,omitempty"`
+	Status       string `json:"status,omitempty"`
+	ClientStatus string `json:"client_status,omitempty"`
+	// Object is the hash of the content of the resource, or of the node, in the objects
+	Object string `json:"object,omitempty"`
+}
+
+// HistoryStore is a local store of the configs of the clients over time, in a directory of
+//
+//	objects/<hash[:2]>/<hash>.gz  the gzipped resources and nodes, by the sha256 of their content
+//	index.jsonl                   the changes of the clients in time order, one json object per line
+//	lock                          locked by the process that records in the store
+//
+// Only the resources that changed since the last record are added to the index, so the config of a
+// client at any time is restored by replaying the changes up to it.
+type HistoryStore struct {
+	dir            string
+	redactedFields []string
+	changes        []HistoryChange
+	// last is the last recorded change of each resource and node, by client id and resource key
+	last map[string]map[string]HistoryChange
+	// lock is the locked lock file of a store opened to record, nil otherwise
+	lock *os.File
+}
+
+// OpenHistory opens the history store in the directory, which is created if it does not exist. The
+// fields are redacted from the resources before they are stored.
+func OpenHistory(dir string, redactedFields []string) (*HistoryStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0755); err != nil {
+		return nil, err
+	}
+	h := &HistoryStore{dir: dir, redactedFields: redactedFields, last: make(map[string]map[string]HistoryChange)}
+	f, err := os.Open(h.indexPath())
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var change HistoryChange
+		if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+			return nil, fmt.Errorf("%v:%d: %v", h.indexPath(), line, err)
+		}
+		h.changes = append(h.changes, change)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// the index of a store that was written by several processes at once may be out of order
+	sortHistoryChanges(h.changes)
+	for _, change := range h.changes {
+		h.apply(h.last, change)
+	}
+	return h, nil
+}
+
+// OpenHistoryRecorder opens the history store in the directory to record in it, as OpenHistory. The
+// directory is locked until Close, so that it fails if another process records in it.
+func OpenHistoryRecorder(dir string, redactedFields []string) (*HistoryStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	lock, err := lockFile(filepath.Join(dir, "lock"))
+	if err != nil {
+		return nil, fmt.Errorf("history directory %v is in use by another process: %v", dir, err)
+	}
+	h, err := OpenHistory(dir, redactedFields)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	h.lock = lock
+	return h, nil
+}
+
+// Close releases the lock of a store opened to record
+func (h *HistoryStore) Close() error {
+	if h.lock == nil {
+		return nil
+	}
+	err := h.lock.Close()
+	h.lock = nil
+	return err
+}
+
+func (h *HistoryStore) indexPath() string {
+	return filepath.Join(h.dir, "index.jsonl")
+}
+
+func (h *HistoryStore) objectPath(hash string) string {
+	return filepath.Join(h.dir, "objects", hash[:2], hash+".gz")
+}
+
+// resourceKey is the key of a resource of a client in the state, the node is ""
+func resourceKey(xds string, name string) string {
+	if xds == "" {
+		return ""
+	}
+	return xds + "/" + name
+}
+
+// apply applies the change to the state of the clients
+func (h *HistoryStore) apply(state map[string]map[string]HistoryChange, change HistoryChange) {
+	key := resourceKey(change.Xds, change.Name)
+	if change.Change == HistoryRemoved {
+		if key == "" {
+			delete(state, change.Id)
+		} else {
+			delete(state[change.Id], key)
+		}
+		return
+	}
+	if state[change.Id] == nil {
+		state[change.Id] = make(map[string]HistoryChange)
+	}
+	state[change.Id][key] = change
+}
+
+// Record records the clients at the time, and returns the changes since the last record. Only the
+// changes are added to the index.
+func (h *HistoryStore) Record(t time.Time, clients []ClientConfig) ([]HistoryChange, error) {
+	var changes []HistoryChange
+	add := func(change HistoryChange, previous HistoryChange, existed bool) {
+		change.Time = t
+		switch {
+		case !existed:
+			change.Change = HistoryAdded
+		case previous.Object != change.Object || previous.Version != change.Version || previous.Status != change.Status || previous.ClientStatus != change.ClientStatus:
+			change.Change = HistoryChanged
+		default:
+			return
+		}
+		changes = append(changes, change)
+	}
+
+	reported := make(map[string]bool)
+	for _, c := range clients {
+		reported[c.Id] = true
+		last := h.last[c.Id]
+		node := c
+		node.Resources = nil
+		data, err := json.Marshal(node)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := h.writeObject(data)
+		if err != nil {
+			return nil, err
+		}
+		previous, existed := last[""]
+		add(HistoryChange{Id: c.Id, Object: hash}, previous, existed)
+
+		resources := make(map[string]bool)
+		for _, r := range c.Resources {
+			key := resourceKey(r.Xds, r.Name)
+			resources[key] = true
+			var hash string
+			if r.Config != nil {
+				if hash, err = h.writeResource(r.Config); err != nil {
+					return nil, err
+				}
+			}
+			previous, existed := last[key]
+			add(HistoryChange{Id: c.Id, Xds: r.Xds, Name: r.Name, Version: r.Version, Status: r.Status, ClientStatus: r.ClientStatus, Object: hash}, previous, existed)
+		}
+		for key, previous := range last {
+			if key != "" && !resources[key] {
+				changes = append(changes, HistoryChange{Time: t, Id: c.Id, Xds: previous.Xds, Name: previous.Name, Change: HistoryRemoved})
+			}
+		}
+	}
+	for id := range h.last {
+		if !reported[id] {
+			changes = append(changes, HistoryChange{Time: t, Id: id, Change: HistoryRemoved})
+		}
+	}
+	sortHistoryChanges(changes)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	var b bytes.Buffer
+	for _, change := range changes {
+		line, err := json.Marshal(change)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	f, err := os.OpenFile(h.indexPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(b.Bytes()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		h.apply(h.last, change)
+	}
+	h.changes = append(h.changes, changes...)
+	return changes, nil
+}
+
+// sortHistoryChanges sorts the changes of the same time by client id, with the node first, xDS type
+// and name
+func sortHistoryChanges(changes []HistoryChange) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		if !changes[i].Time.Equal(changes[j].Time) {
+			return changes[i].Time.Before(changes[j].Time)
+		}
+		if changes[i].Id != changes[j].Id {
+			return changes[i].Id < changes[j].Id
+		}
+		if changes[i].Xds != changes[j].Xds {
+			return changes[i].Xds < changes[j].Xds
+		}
+		return changes[i].Name < changes[j].Name
+	})
+}
+
+// writeResource stores the resource with the fields redacted, and returns its hash
+func (h *HistoryStore) writeResource(config *anypb.Any) (string, error) {
+	var m proto.Message = config
+	if h.redactedFields != nil {
+		redacted, err := Redact(config, h.redactedFields)
+		if err != nil {
+			return "", err
+		}
+		m = redacted
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return h.writeObject(data)
+}
+
+// writeObject stores the content if it is not stored yet, and returns its hash
+func (h *HistoryStore) writeObject(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := h.objectPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	// the object is written to a temporary file first, so that a partial object is never read
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(tmp, path)
+}
+
+// readObject reads the content with the hash
+func (h *HistoryStore) readObject(hash string) ([]byte, error) {
+	f, err := os.Open(h.objectPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// Changes returns the recorded changes between the times, of the client with the id if it is not
+// empty. A zero time leaves that end of the range open.
+func (h *HistoryStore) Changes(since time.Time, until time.Time, id string) []HistoryChange {
+	var changes []HistoryChange
+	for _, change := range h.changes {
+		if !since.IsZero() && change.Time.Before(since) || !until.IsZero() && change.Time.After(until) {
+			continue
+		}
+		if id != "" && change.Id != id {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// FindId returns the id of the client in the history with the node id, or the only one whose id
+// contains it, as FindClient
+func (h *HistoryStore) FindId(node string) (string, error) {
+	var clients []ClientConfig
+	seen := make(map[string]bool)
+	for _, change := range h.changes {
+		if !seen[change.Id] {
+			seen[change.Id] = true
+			clients = append(clients, ClientConfig{Id: change.Id})
+		}
+	}
+	c, err := FindClient(clients, node)
+	return c.Id, err
+}
+
+// At restores the configs of the clients at the time, as recorded last before it
+func (h *HistoryStore) At(t time.Time) ([]ClientConfig, error) {
+	state := make(map[string]map[string]HistoryChange)
+	for _, change := range h.changes {
+		if change.Time.After(t) {
+			break
+		}
+		h.apply(state, change)
+	}
+
+	var clients []ClientConfig
+	for id, resources := range state {
+		var c ClientConfig
+		if node, ok := resources[""]; ok {
+			data, err := h.readObject(node.Object)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(data, &c); err != nil {
+				return nil, err
+			}
+		}
+		c.Id = id
+		for key, change := range resources {
+			if key == "" {
+				continue
+			}
+			r := Resource{Xds: change.Xds, Name: change.Name, Version: change.Version, Status: change.Status, ClientStatus: change.ClientStatus}
+			if change.Object != "" {
+				data, err := h.readObject(change.Object)
+				if err != nil {
+					return nil, err
+				}
+				r.Config = &anypb.Any{}
+				if err := proto.Unmarshal(data, r.Config); err != nil {
+					return nil, err
+				}
+			}
+			c.Resources = append(c.Resources, r)
+		}
+		sort.SliceStable(c.Resources, func(i, j int) bool {
+			if c.Resources[i].Xds != c.Resources[j].Xds {
+				return c.Resources[i].Xds < c.Resources[j].Xds
+			}
+			return c.Resources[i].Name < c.Resources[j].Name
+		})
+		clients = append(clients, c)
+	}
+	sort.SliceStable(clients, func(i, j int) bool {
+		return clients[i].Id < clients[j].Id
+	})
+	return clients, nil
+}
+
+// DiffHistory compares the resources of a client at two times of the history, matching them by xDS
+// type and name as DiffResources. The resources only reported at one of the times are reported as
+// added or removed.
+func DiffHistory(before ClientConfig, after ClientConfig) ([]Divergence, int) {
+	return compareResources(before.Resources, after.Resources, HistoryRemoved, HistoryAdded)
+}
+
+// ParseHistoryTime parses a time of the history relative to now. It is a RFC 3339 time, a local
+// date and time (2006-01-02 15:04[:05]), a local time of today (15:04[:05]), or a negative
+// duration before now (e.g. -1h30m).
+func ParseHistoryTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location()), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d <= 0 {
+		return now.Add(d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %v, expected e.g. 2006-01-02T15:04:05Z07:00, 2006-01-02 15:04, 15:04 or -1h", s)
+}
+
+// PrintHistoryChanges prints out the changes recorded in the history
+func PrintHistoryChanges(changes []HistoryChange) {
+	if len(changes) == 0 {
+		fmt.Println("No changes recorded")
+		return
+	}
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	fmt.Printf("%-25s %-50s %-8s %-6s %-40s %v\n", "Time", "Client ID", "Change", "xDS", "Resource", "Version")
+	for _, c := range changes {
+		fmt.Printf("%-25s %-50s %-8s %-6s %-40s %v\n", c.Time.Local().Format("2006-01-02 15:04:05"), c.Id, c.Change, dash(c.Xds), dash(c.Name), c.Version)
+	}
+}
@@ -0,0 +1,10 @@
+package util
+
+import (
+	"os"
+)
+
+// lockFile opens the file without locking it, as there is no advisory lock on windows
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+}
@@ -0,0 +1,127 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHistory tests that the clients are recorded in the history store, and that they are restored
+// at any time of the history
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds_history")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := ReadClientConfigs(t, "./response_for_summary.json")
+	second := ReadClientConfigs(t, "./response_for_summary.json")
+	// test_node_1 drops its listener and test_node_3 disconnects
+	second[0].Statuses = second[0].Statuses[:1]
+	second[0].Resources = second[0].Resources[:1]
+	second = second[:2]
+	recorder, err := OpenHistoryRecorder(dir, nil)
+	if err != nil {
+		t.Fatalf("Open history recorder error: %v", err)
+	}
+	start := time.Date(2021, 6, 1, 3, 0, 0, 0, time.UTC)
+	for i, clients := range [][]ClientConfig{first, second} {
+		if _, err := recorder.Record(start.Add(time.Duration(i)*time.Minute), clients); err != nil {
+			t.Fatalf("Record history error: %v", err)
+		}
+	}
+	recorder.Close()
+
+	h, err := OpenHistory(dir, nil)
+	if err != nil {
+		t.Fatalf("Open history error: %v", err)
+	}
+	changes := h.Changes(time.Time{}, time.Time{}, "")
+	// the 3 nodes and 5 resources are added, then the config statuses of test_node_1 change, its
+	// listener is removed and test_node_3 is removed
+	if len(changes) != 11 {
+		t.Fatalf("want 11 changes, got %v", changes)
+	}
+	t1, t2 := changes[0].Time, changes[10].Time
+	var got []string
+	for _, change := range h.Changes(t2, time.Time{}, "") {
+		got = append(got, change.Id+" "+change.Xds+" "+change.Name+" "+change.Change)
+	}
+	if want := []string{"test_node_1   changed", "test_node_1 LDS fake_listener removed", "test_node_3   removed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("changes = %v, want %v", got, want)
+	}
+	if id, err := h.FindId("node_3"); err != nil || id != "test_node_3" {
+		t.Errorf("want test_node_3, got %v, %v", id, err)
+	}
+
+	clients, err := h.At(t1)
+	if err != nil {
+		t.Fatalf("Restore history error: %v", err)
+	}
+	if len(clients) != 3 || len(clients[0].Resources) != 2 || clients[0].StreamType != "ADS" || len(clients[0].Statuses) != 2 {
+		t.Errorf("want the 3 clients of the first response, got %v", clients)
+	}
+	if clients, err = h.At(t2); err != nil || len(clients) != 2 || len(clients[0].Resources) != 1 {
+		t.Errorf("want the 2 clients of the second response, got %v, %v", clients, err)
+	}
+	after, _ := FindClient(clients, "test_node_1")
+	if clients, err = h.At(t1); err != nil {
+		t.Fatalf("Restore history error: %v", err)
+	}
+	before, _ := FindClient(clients, "test_node_1")
+	divergences, compared := DiffHistory(before, after)
+	if want := []Divergence{{Xds: "LDS", Name: "fake_listener", Reason: HistoryRemoved, Left: "fake_listener_version1"}}; compared != 2 || !reflect.DeepEqual(divergences, want) {
+		t.Errorf("DiffHistory = %v, %d, want %v, 2", divergences, compared, want)
+	}
+	if clients, err = h.At(t1.Add(-time.Second)); err != nil || len(clients) != 0 {
+		t.Errorf("want no clients before the history, got %v, %v", clients, err)
+	}
+
+	// the changes are sorted when the index is out of order
+	index, err := ioutil.ReadFile(filepath.Join(dir, "index.jsonl"))
+	if err != nil {
+		t.Fatalf("Read index error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(index), "\n"), "\n")
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.jsonl"), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("Write index error: %v", err)
+	}
+	if reversed, err := OpenHistory(dir, nil); err != nil || !reflect.DeepEqual(reversed.Changes(time.Time{}, time.Time{}, ""), changes) {
+		t.Errorf("want the changes in time order, got %v", err)
+	}
+
+	// a directory cannot be recorded in by two processes at once
+	recorder, err = OpenHistoryRecorder(dir, nil)
+	if err != nil {
+		t.Fatalf("Open history recorder error: %v", err)
+	}
+	if _, err := OpenHistoryRecorder(dir, nil); err == nil {
+		t.Errorf("want an error on a history directory that is in use")
+	}
+	recorder.Close()
+	if recorder, err = OpenHistoryRecorder(dir, nil); err != nil {
+		t.Errorf("want the history directory released, got %v", err)
+	} else {
+		recorder.Close()
+	}
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	for s, want := range map[string]time.Time{
+		"10:15":                time.Date(2021, 6, 1, 10, 15, 0, 0, time.UTC),
+		"2021-05-31 23:59:30":  time.Date(2021, 5, 31, 23, 59, 30, 0, time.UTC),
+		"2021-05-31T23:00:00Z": time.Date(2021, 5, 31, 23, 0, 0, 0, time.UTC),
+		"-90m":                 time.Date(2021, 6, 1, 10, 30, 0, 0, time.UTC),
+	} {
+		if got, err := ParseHistoryTime(s, now); err != nil || !got.Equal(want) {
+			t.Errorf("ParseHistoryTime(%v) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// the conditions of the hooks
+const (
+	// HookStale fires when a client reports a STALE config status or resource
+	HookStale = "stale"
+	// HookNacked fires when a client rejects a resource
+	HookNacked = "nacked"
+	// HookClientDrop fires when the number of clients drops by threshold percent
+	HookClientDrop = "client_drop"
+)
+
+// hookTimeout is the timeout of the webhooks and the commands of the hooks
+const hookTimeout = 10 * time.Second
+
+// defaultHookCooldown is the cooldown of the hooks that do not set one
+const defaultHookCooldown = 30 * time.Minute
+
+// Hook is an action invoked when a condition fires in monitor mode, defined in the hooks file, e.g.
+//
+//	hooks:
+//	- name: stale-clients
+//	  condition: stale
+//	  debounce: 1m
+//	  cooldown: 30m
+//	  webhook: https://alerts.example.com/csds
+//	- name: clients-gone
+//	  condition: client_drop
+//	  threshold: 20
+//	  command: [./page.sh, --severity, high]
+type Hook struct {
+	Name string `json:"name"`
+	// Condition is one of stale, nacked and client_drop
+	Condition string `json:"condition"`
+	// Threshold is the drop in percent of the number of clients for client_drop
+	Threshold float64 `json:"threshold,omitempty"`
+	// Debounce is how long the condition must hold before the hook fires
+	Debounce string `json:"debounce,omitempty"`
+	// Cooldown is how long the hook waits to fire again while its condition still holds, 30m by
+	// default
+	Cooldown string `json:"cooldown,omitempty"`
+	// Webhook is the url to which the HookEvent is posted as json
+	Webhook string `json:"webhook,omitempty"`
+	// Command is the command that is run with the HookEvent as json on its stdin
+	Command []string `json:"command,omitempty"`
+}
+
+// HookEvent is the payload of a hook that fires
+type HookEvent struct {
+	Hook      string    `json:"hook"`
+	Condition string    `json:"condition"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+	// Clients are the ids of the clients for which the condition holds, if any
+	Clients []string `json:"clients,omitempty"`
+	// hook is the hook that fires
+	hook *hookState
+}
+
+// Hooks evaluates the hooks on the clients of every response
+type Hooks struct {
+	hooks  []*hookState
+	client *http.Client
+	// pending are the events that are being fired
+	pending sync.WaitGroup
+}
+
+// hookState is the state of a hook between the responses
+type hookState struct {
+	Hook
+	debounce time.Duration
+	cooldown time.Duration
+	// since is when the condition started to hold, or zero if it does not
+	since time.Time
+	// fired is when the hook fired last while its condition holds, or zero if it has not fired since
+	// the condition started to hold
+	fired time.Time
+	// baseline is the highest number of clients since client_drop last fired, while it did not hold
+	baseline int
+}
+
+// LoadHooks loads the hooks file at path
+func LoadHooks(path string) (*Hooks, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Hooks []Hook `json:"hooks"`
+	}
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid hooks file %v: %v", path, err)
+	}
+	hooks, err := NewHooks(f.Hooks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hooks file %v: %v", path, err)
+	}
+	return hooks, nil
+}
+
+// NewHooks checks the hooks and creates their states
+func NewHooks(hooks []Hook) (*Hooks, error) {
+	h := &Hooks{client: &http.Client{Timeout: hookTimeout}}
+	names := map[string]bool{}
+	for i, hook := range hooks {
+		if hook.Name == "" {
+			hook.Name = fmt.Sprintf("hook%d", i)
+		}
+		if names[hook.Name] {
+			return nil, fmt.Errorf("hook %v: duplicate name, the hooks without a name are named hook<index>", hook.Name)
+		}
+		names[hook.Name] = true
+		s := &hookState{Hook: hook, cooldown: defaultHookCooldown}
+		switch hook.Condition {
+		case HookStale, HookNacked:
+		case HookClientDrop:
+			if hook.Threshold <= 0 || hook.Threshold > 100 {
+				return nil, fmt.Errorf("hook %v: threshold must be a percentage in (0, 100]", hook.Name)
+			}
+		default:
+			return nil, fmt.Errorf("hook %v: unsupported condition %q, list of supported conditions: %v, %v, %v", hook.Name, hook.Condition, HookStale, HookNacked, HookClientDrop)
+		}
+		if (hook.Webhook == "") == (len(hook.Command) == 0) {
+			return nil, fmt.Errorf("hook %v: exactly one of webhook and command must be set", hook.Name)
+		}
+		var err error
+		if hook.Debounce != "" {
+			if s.debounce, err = time.ParseDuration(hook.Debounce); err != nil {
+				return nil, fmt.Errorf("hook %v: invalid debounce: %v", hook.Name, err)
+			}
+		}
+		if hook.Cooldown != "" {
+			if s.cooldown, err = time.ParseDuration(hook.Cooldown); err != nil {
+				return nil, fmt.Errorf("hook %v: invalid cooldown: %v", hook.Name, err)
+			}
+			if s.cooldown <= 0 {
+				return nil, fmt.Errorf("hook %v: cooldown must be positive", hook.Name)
+			}
+		}
+		h.hooks = append(h.hooks, s)
+	}
+	return h, nil
+}
+
+// Evaluate evaluates the conditions on the clients of the response at the time, and returns the
+// events of the hooks that fire. A hook fires once its condition has held for its debounce, and
+// fires again only when the condition clears and holds again, or when it still holds after the
+// cooldown.
+func (h *Hooks) Evaluate(t time.Time, clients []ClientConfig) []HookEvent {
+	var events []HookEvent
+	for _, s := range h.hooks {
+		holds, message, ids := s.check(clients)
+		if !holds {
+			s.since = time.Time{}
+			s.fired = time.Time{}
+			continue
+		}
+		if s.since.IsZero() {
+			s.since = t
+		}
+		if t.Sub(s.since) < s.debounce || !s.fired.IsZero() && t.Sub(s.fired) < s.cooldown {
+			continue
+		}
+		s.fired = t
+		if s.Condition == HookClientDrop {
+			// the drop is reported once, the next drop is relative to the current number of clients
+			s.baseline = len(clients)
+		}
+		events = append(events, HookEvent{Hook: s.Name, Condition: s.Condition, Time: t, Message: message, Clients: ids, hook: s})
+	}
+	return events
+}
+
+// check checks if the condition of the hook holds on the clients, with the message and the ids of
+// the clients for which it holds
+func (s *hookState) check(clients []ClientConfig) (bool, string, []string) {
+	switch s.Condition {
+	case HookClientDrop:
+		n := len(clients)
+		if s.baseline == 0 || float64(n) > float64(s.baseline)*(1-s.Threshold/100) {
+			if n > s.baseline {
+				s.baseline = n
+			}
+			return false, "", nil
+		}
+		return true, fmt.Sprintf("the number of clients dropped from %d to %d", s.baseline, n), nil
+	case HookStale, HookNacked:
+		var ids []string
+		for _, c := range clients {
+			if s.Condition == HookStale && isStale(c) || s.Condition == HookNacked && isNacked(c) {
+				ids = append(ids, c.Id)
+			}
+		}
+		sort.Strings(ids)
+		if len(ids) == 0 {
+			return false, "", nil
+		}
+		return true, fmt.Sprintf("%d clients are %v", len(ids), s.Condition), ids
+	}
+	return false, "", nil
+}
+
+// isStale checks if the client reports a STALE config status or resource
+func isStale(c ClientConfig) bool {
+	for _, status := range c.Statuses {
+		if status.Status == "STALE" {
+			return true
+		}
+	}
+	for _, r := range c.Resources {
+		if r.Status == "STALE" {
+			return true
+		}
+	}
+	return false
+}
+
+// isNacked checks if the client rejects a resource, as the nacked rule of Lint
+func isNacked(c ClientConfig) bool {
+	for _, r := range c.Resources {
+		if r.ClientStatus == "NACKED" || r.Status == "ERROR" {
+			return true
+		}
+	}
+	for _, status := range c.Statuses {
+		if status.Status == "ERROR" {
+			return true
+		}
+	}
+	return false
+}
+
+// Fire invokes the action of the hook of the event, as returned by Evaluate
+func (h *Hooks) Fire(event HookEvent) error {
+	hook := event.hook
+	if hook == nil {
+		return fmt.Errorf("hook %v is not evaluated", event.Hook)
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if hook.Webhook != "" {
+		resp, err := h.client.Post(hook.Webhook, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook %v responded %v", hook.Webhook, resp.Status)
+		}
+		return nil
+	}
+	cmd := exec.Command(hook.Command[0], hook.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "CSDS_HOOK="+event.Hook, "CSDS_HOOK_CONDITION="+event.Condition)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(hookTimeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("command %v timed out after %v", strings.Join(hook.Command, " "), hookTimeout)
+	}
+}
+
+// Run evaluates the hooks on the clients of the response and fires the events in the background, so
+// that a slow webhook or command does not delay monitoring. The hooks that fail are reported.
+func (h *Hooks) Run(t time.Time, clients []ClientConfig) {
+	for _, event := range h.Evaluate(t, clients) {
+		fmt.Fprintf(os.Stderr, "Hook %v fired: %v\n", event.Hook, event.Message)
+		h.pending.Add(1)
+		go func(event HookEvent) {
+			defer h.pending.Done()
+			if err := h.Fire(event); err != nil {
+				fmt.Fprintf(os.Stderr, "Hook %v failed: %v\n", event.Hook, err)
+			}
+		}(event)
+	}
+}
+
+// Wait waits for the events that are being fired
+func (h *Hooks) Wait() {
+	h.pending.Wait()
+}
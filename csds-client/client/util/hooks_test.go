@@ -0,0 +1,87 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestHooks tests that the hooks fire once their condition has held for their debounce, again only
+// when it holds again or after their cooldown, and that the webhooks are posted the events
+func TestHooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []HookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event HookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Decode webhook error: %v", err)
+		}
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	hooks, err := NewHooks([]Hook{
+		{Name: "stale", Condition: HookStale, Debounce: "30s", Cooldown: "5m", Webhook: server.URL},
+		{Name: "drop", Condition: HookClientDrop, Threshold: 50, Webhook: server.URL},
+	})
+	if err != nil {
+		t.Fatalf("Create hooks error: %v", err)
+	}
+	clients := ReadClientConfigs(t, "./response_for_summary.json")
+
+	start := time.Date(2021, 6, 1, 3, 0, 0, 0, time.UTC)
+	for _, tick := range []struct {
+		offset  time.Duration
+		clients []ClientConfig
+	}{
+		// test_node_2 is stale, but not for the debounce yet
+		{0, clients},
+		{30 * time.Second, clients},
+		// within the cooldown
+		{time.Minute, clients},
+		// test_node_3 and test_node_2 disconnect, so the stale condition no longer holds
+		{2 * time.Minute, clients[:1]},
+		{3 * time.Minute, clients[:1]},
+		{6 * time.Minute, clients},
+		{6*time.Minute + 30*time.Second, clients},
+		// the condition still holds, so the hook fires again only after the cooldown
+		{10 * time.Minute, clients},
+		{12 * time.Minute, clients},
+	} {
+		hooks.Run(start.Add(tick.offset), tick.clients)
+		// the events are fired in the background
+		hooks.Wait()
+	}
+
+	var got []string
+	for _, event := range events {
+		got = append(got, fmt.Sprintf("%v %v %v %v", event.Hook, event.Time.Sub(start), event.Message, event.Clients))
+	}
+	want := []string{
+		"stale 30s 1 clients are stale [test_node_2]",
+		"drop 2m0s the number of clients dropped from 3 to 1 []",
+		"stale 6m30s 1 clients are stale [test_node_2]",
+		"stale 12m0s 1 clients are stale [test_node_2]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	if _, err := NewHooks([]Hook{{Name: "both", Condition: HookNacked, Webhook: server.URL, Command: []string{"true"}}}); err == nil {
+		t.Errorf("want an error on a hook with both a webhook and a command")
+	}
+	if _, err := NewHooks([]Hook{{Name: "typo", Condition: "stail", Webhook: server.URL}}); err == nil {
+		t.Errorf("want an error on an unsupported condition")
+	}
+	// the second hook is named hook1 as it has no name
+	if _, err := NewHooks([]Hook{{Name: "hook1", Condition: HookStale, Webhook: server.URL}, {Condition: HookNacked, Webhook: server.URL}}); err == nil {
+		t.Errorf("want an error on duplicate hook names")
+	}
+}
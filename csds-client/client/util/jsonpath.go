@@ -0,0 +1,630 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JsonPath is a compiled JSONPath expression. It supports the root $, child .name and ['name'],
+// wildcard * , recursive descent .., array index [n], slice [start:end] and filter [?(...)]
+// expressions. A filter expression compares paths relative to the current element @ (or the root $)
+// to literals with ==, !=, <, <=, >, >= and =~ /regex/, and can be combined with &&, || and !,
+// e.g. $..routes[?(@.route.retryPolicy.numRetries > 3)].name
+type JsonPath struct {
+	expr  string
+	steps []jsonPathStep
+}
+
+type jsonPathStepKind int
+
+const (
+	jsonPathChild jsonPathStepKind = iota
+	jsonPathWildcard
+	jsonPathIndex
+	jsonPathSlice
+	jsonPathFilter
+)
+
+type jsonPathStep struct {
+	kind jsonPathStepKind
+	// recursive applies the step to the value and all of its descendants
+	recursive bool
+	names     []string
+	indexes   []int
+	start     *int
+	end       *int
+	filter    jsonPathExpr
+}
+
+// jsonPathExpr is a boolean expression in a filter, evaluated on the current element and the root
+type jsonPathExpr interface {
+	eval(current interface{}, root interface{}) bool
+}
+
+// ParseJsonPath compiles a JSONPath expression
+func ParseJsonPath(expr string) (*JsonPath, error) {
+	p := &jsonPathParser{expr: expr}
+	p.skipSpaces()
+	if !p.consume("$") {
+		return nil, fmt.Errorf("invalid JSONPath %q: must start with $", expr)
+	}
+	steps, err := p.parseSteps()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.expr) {
+		return nil, p.errorf("unexpected %q", p.expr[p.pos:])
+	}
+	return &JsonPath{expr: expr, steps: steps}, nil
+}
+
+// String returns the JSONPath expression
+func (j *JsonPath) String() string {
+	return j.expr
+}
+
+// Eval returns the values selected by the JSONPath in the json document decoded by encoding/json
+func (j *JsonPath) Eval(doc interface{}) []interface{} {
+	return evalJsonPathSteps(j.steps, doc, doc)
+}
+
+func evalJsonPathSteps(steps []jsonPathStep, value interface{}, root interface{}) []interface{} {
+	values := []interface{}{value}
+	for _, step := range steps {
+		var next []interface{}
+		for _, v := range values {
+			if step.recursive {
+				for _, d := range descendants(v) {
+					next = append(next, step.apply(d, root)...)
+				}
+			} else {
+				next = append(next, step.apply(v, root)...)
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// apply selects the children of value that match the step
+func (s jsonPathStep) apply(value interface{}, root interface{}) []interface{} {
+	var selected []interface{}
+	switch s.kind {
+	case jsonPathChild:
+		if m, ok := value.(map[string]interface{}); ok {
+			for _, name := range s.names {
+				if v, ok := m[name]; ok {
+					selected = append(selected, v)
+				}
+			}
+		}
+	case jsonPathWildcard:
+		selected = children(value)
+	case jsonPathIndex:
+		if a, ok := value.([]interface{}); ok {
+			for _, i := range s.indexes {
+				if i < 0 {
+					i += len(a)
+				}
+				if i >= 0 && i < len(a) {
+					selected = append(selected, a[i])
+				}
+			}
+		}
+	case jsonPathSlice:
+		if a, ok := value.([]interface{}); ok {
+			start, end := 0, len(a)
+			if s.start != nil {
+				start = *s.start
+			}
+			if s.end != nil {
+				end = *s.end
+			}
+			if start < 0 {
+				start += len(a)
+			}
+			if end < 0 {
+				end += len(a)
+			}
+			for i := start; i < end && i < len(a); i++ {
+				if i >= 0 {
+					selected = append(selected, a[i])
+				}
+			}
+		}
+	case jsonPathFilter:
+		for _, child := range children(value) {
+			if s.filter.eval(child, root) {
+				selected = append(selected, child)
+			}
+		}
+	}
+	return selected
+}
+
+// children returns the elements of an array or the values of an object ordered by key
+func children(value interface{}) []interface{} {
+	switch t := value.(type) {
+	case []interface{}:
+		return t
+	case map[string]interface{}:
+		var keys []string
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var values []interface{}
+		for _, k := range keys {
+			values = append(values, t[k])
+		}
+		return values
+	}
+	return nil
+}
+
+// descendants returns the value and all of its descendants in document order
+func descendants(value interface{}) []interface{} {
+	values := []interface{}{value}
+	for _, child := range children(value) {
+		values = append(values, descendants(child)...)
+	}
+	return values
+}
+
+type jsonPathParser struct {
+	expr string
+	pos  int
+}
+
+func (p *jsonPathParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("invalid JSONPath %q at position %d: %v", p.expr, p.pos, fmt.Sprintf(format, a...))
+}
+
+func (p *jsonPathParser) skipSpaces() {
+	for p.pos < len(p.expr) && (p.expr[p.pos] == ' ' || p.expr[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *jsonPathParser) peek(s string) bool {
+	return strings.HasPrefix(p.expr[p.pos:], s)
+}
+
+func (p *jsonPathParser) consume(s string) bool {
+	if p.peek(s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// consumeKeyword consumes the keyword only if it is not the prefix of a longer word, e.g. true but
+// not trueish
+func (p *jsonPathParser) consumeKeyword(keyword string) bool {
+	if !p.peek(keyword) {
+		return false
+	}
+	if end := p.pos + len(keyword); end < len(p.expr) && isJsonPathWordChar(p.expr[end]) {
+		return false
+	}
+	p.pos += len(keyword)
+	return true
+}
+
+func isJsonPathWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// parseSteps parses the steps following $ or @ until the path ends
+func (p *jsonPathParser) parseSteps() ([]jsonPathStep, error) {
+	var steps []jsonPathStep
+	for p.pos < len(p.expr) {
+		var step jsonPathStep
+		var err error
+		switch {
+		case p.consume(".."):
+			if p.peek("[") {
+				step, err = p.parseBracket()
+			} else {
+				step, err = p.parseDotStep()
+			}
+			step.recursive = true
+		case p.consume("."):
+			step, err = p.parseDotStep()
+		case p.peek("["):
+			step, err = p.parseBracket()
+		default:
+			return steps, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// parseDotStep parses the name or wildcard after a dot
+func (p *jsonPathParser) parseDotStep() (jsonPathStep, error) {
+	if p.consume("*") {
+		return jsonPathStep{kind: jsonPathWildcard}, nil
+	}
+	start := p.pos
+	for p.pos < len(p.expr) && !strings.ContainsRune(" \t.[]()=!<>&|~,'\"", rune(p.expr[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		return jsonPathStep{}, p.errorf("expect a name")
+	}
+	return jsonPathStep{kind: jsonPathChild, names: []string{p.expr[start:p.pos]}}, nil
+}
+
+// parseBracket parses a [...] step
+func (p *jsonPathParser) parseBracket() (jsonPathStep, error) {
+	p.consume("[")
+	p.skipSpaces()
+	var step jsonPathStep
+	switch {
+	case p.consume("*"):
+		step = jsonPathStep{kind: jsonPathWildcard}
+	case p.consume("?("):
+		filter, err := p.parseOr()
+		if err != nil {
+			return step, err
+		}
+		p.skipSpaces()
+		if !p.consume(")") {
+			return step, p.errorf("expect ) to close the filter")
+		}
+		step = jsonPathStep{kind: jsonPathFilter, filter: filter}
+	case p.peek("'") || p.peek("\""):
+		step = jsonPathStep{kind: jsonPathChild}
+		for {
+			name, err := p.parseString()
+			if err != nil {
+				return step, err
+			}
+			step.names = append(step.names, name)
+			p.skipSpaces()
+			if !p.consume(",") {
+				break
+			}
+			p.skipSpaces()
+		}
+	default:
+		first, err := p.parseOptionalInt()
+		if err != nil {
+			return step, err
+		}
+		if p.consume(":") {
+			end, err := p.parseOptionalInt()
+			if err != nil {
+				return step, err
+			}
+			step = jsonPathStep{kind: jsonPathSlice, start: first, end: end}
+			break
+		}
+		if first == nil {
+			return step, p.errorf("expect an index, a slice, a quoted name, * or a filter")
+		}
+		step = jsonPathStep{kind: jsonPathIndex, indexes: []int{*first}}
+		for p.consume(",") {
+			p.skipSpaces()
+			next, err := p.parseOptionalInt()
+			if err != nil {
+				return step, err
+			}
+			if next == nil {
+				return step, p.errorf("expect an index")
+			}
+			step.indexes = append(step.indexes, *next)
+		}
+	}
+	p.skipSpaces()
+	if !p.consume("]") {
+		return step, p.errorf("expect ]")
+	}
+	return step, nil
+}
+
+func (p *jsonPathParser) parseOptionalInt() (*int, error) {
+	p.skipSpaces()
+	start := p.pos
+	if p.peek("-") {
+		p.pos++
+	}
+	for p.pos < len(p.expr) && p.expr[p.pos] >= '0' && p.expr[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, nil
+	}
+	i, err := strconv.Atoi(p.expr[start:p.pos])
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	p.skipSpaces()
+	return &i, nil
+}
+
+// parseString parses a single or double quoted string
+func (p *jsonPathParser) parseString() (string, error) {
+	quote := p.expr[p.pos]
+	end := strings.IndexByte(p.expr[p.pos+1:], quote)
+	if end < 0 {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.expr[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return s, nil
+}
+
+type jsonPathOr struct{ left, right jsonPathExpr }
+
+func (e jsonPathOr) eval(current, root interface{}) bool {
+	return e.left.eval(current, root) || e.right.eval(current, root)
+}
+
+type jsonPathAnd struct{ left, right jsonPathExpr }
+
+func (e jsonPathAnd) eval(current, root interface{}) bool {
+	return e.left.eval(current, root) && e.right.eval(current, root)
+}
+
+type jsonPathNot struct{ expr jsonPathExpr }
+
+func (e jsonPathNot) eval(current, root interface{}) bool {
+	return !e.expr.eval(current, root)
+}
+
+// jsonPathOperand is either a path relative to @ or $, or a literal
+type jsonPathOperand struct {
+	relative bool
+	steps    []jsonPathStep
+	isPath   bool
+	literal  interface{}
+}
+
+func (o jsonPathOperand) values(current, root interface{}) []interface{} {
+	if !o.isPath {
+		return []interface{}{o.literal}
+	}
+	if o.relative {
+		return evalJsonPathSteps(o.steps, current, root)
+	}
+	return evalJsonPathSteps(o.steps, root, root)
+}
+
+// jsonPathComparison compares two operands, it is true if any pair of the selected values matches.
+// Without an operator it is a presence test, true if the path selects any value, even null or false.
+type jsonPathComparison struct {
+	left  jsonPathOperand
+	op    string
+	right jsonPathOperand
+	regex *regexp.Regexp
+}
+
+func (e jsonPathComparison) eval(current, root interface{}) bool {
+	lefts := e.left.values(current, root)
+	if e.op == "" {
+		if !e.left.isPath {
+			return e.left.literal != nil && e.left.literal != false
+		}
+		return len(lefts) > 0
+	}
+	if e.regex != nil {
+		for _, v := range lefts {
+			if s, ok := v.(string); ok && e.regex.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, l := range lefts {
+		for _, r := range e.right.values(current, root) {
+			if compareJsonValues(l, e.op, r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compareJsonValues compares numbers (including numeric strings, as protojson encodes 64-bit
+// integers as strings) numerically, strings lexically and other values by equality
+func compareJsonValues(l interface{}, op string, r interface{}) bool {
+	if lf, ok := jsonNumber(l); ok {
+		if rf, ok := jsonNumber(r); ok {
+			switch op {
+			case "==":
+				return lf == rf
+			case "!=":
+				return lf != rf
+			case "<":
+				return lf < rf
+			case "<=":
+				return lf <= rf
+			case ">":
+				return lf > rf
+			case ">=":
+				return lf >= rf
+			}
+		}
+	}
+	ls, lok := l.(string)
+	rs, rok := r.(string)
+	if lok && rok {
+		switch op {
+		case "==":
+			return ls == rs
+		case "!=":
+			return ls != rs
+		case "<":
+			return ls < rs
+		case "<=":
+			return ls <= rs
+		case ">":
+			return ls > rs
+		case ">=":
+			return ls >= rs
+		}
+	}
+	switch op {
+	case "==":
+		return fmt.Sprint(l) == fmt.Sprint(r)
+	case "!=":
+		return fmt.Sprint(l) != fmt.Sprint(r)
+	}
+	return false
+}
+
+func jsonNumber(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case string:
+		f, err := strconv.ParseFloat(t, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func (p *jsonPathParser) parseOr() (jsonPathExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.skipSpaces(); p.consume("||"); p.skipSpaces() {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = jsonPathOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *jsonPathParser) parseAnd() (jsonPathExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.skipSpaces(); p.consume("&&"); p.skipSpaces() {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = jsonPathAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *jsonPathParser) parseUnary() (jsonPathExpr, error) {
+	p.skipSpaces()
+	if p.peek("!") && !p.peek("!=") {
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return jsonPathNot{expr}, nil
+	}
+	if p.consume("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if !p.consume(")") {
+			return nil, p.errorf("expect )")
+		}
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *jsonPathParser) parseComparison() (jsonPathExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	var op string
+	for _, candidate := range []string{"==", "!=", "<=", ">=", "=~", "<", ">"} {
+		if p.consume(candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		if !left.isPath {
+			return nil, p.errorf("expect a comparison operator")
+		}
+		return jsonPathComparison{left: left}, nil
+	}
+	p.skipSpaces()
+	if op == "=~" {
+		var pattern string
+		if p.peek("/") {
+			end := strings.IndexByte(p.expr[p.pos+1:], '/')
+			if end < 0 {
+				return nil, p.errorf("unterminated regex")
+			}
+			pattern = p.expr[p.pos+1 : p.pos+1+end]
+			p.pos += end + 2
+		} else if p.peek("'") || p.peek("\"") {
+			if pattern, err = p.parseString(); err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, p.errorf("expect a /regex/ after =~")
+		}
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		return jsonPathComparison{left: left, op: op, regex: regex}, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return jsonPathComparison{left: left, op: op, right: right}, nil
+}
+
+func (p *jsonPathParser) parseOperand() (jsonPathOperand, error) {
+	p.skipSpaces()
+	switch {
+	case p.peek("@") || p.peek("$"):
+		relative := p.expr[p.pos] == '@'
+		p.pos++
+		steps, err := p.parseSteps()
+		if err != nil {
+			return jsonPathOperand{}, err
+		}
+		return jsonPathOperand{isPath: true, relative: relative, steps: steps}, nil
+	case p.peek("'") || p.peek("\""):
+		s, err := p.parseString()
+		return jsonPathOperand{literal: s}, err
+	case p.consumeKeyword("true"):
+		return jsonPathOperand{literal: true}, nil
+	case p.consumeKeyword("false"):
+		return jsonPathOperand{literal: false}, nil
+	case p.consumeKeyword("null"):
+		return jsonPathOperand{literal: nil}, nil
+	}
+	start := p.pos
+	for p.pos < len(p.expr) && strings.ContainsRune("+-.0123456789eE", rune(p.expr[p.pos])) {
+		p.pos++
+	}
+	f, err := strconv.ParseFloat(p.expr[start:p.pos], 64)
+	if err != nil {
+		p.pos = start
+		return jsonPathOperand{}, p.errorf("expect @, $, a string, a number, true, false or null")
+	}
+	return jsonPathOperand{literal: f}, nil
+}
@@ -0,0 +1,149 @@
+package util
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const jsonPathTestDoc = `{
+	"name": "listener",
+	"port": 8080,
+	"id": "42",
+	"enabled": false,
+	"tags": ["a", "b", "c", "d"],
+	"routes": [
+		{"name": "r0", "cluster": "c0", "retries": 5, "prefix": "/api", "autoHostRewrite": false},
+		{"name": "r1", "cluster": "c1", "retries": 1, "prefix": "/static"},
+		{"name": "r2", "cluster": "c0", "retries": 3, "timeout": null, "nested": {"name": "n0"}}
+	],
+	"limits": {"max": "c0"}
+}`
+
+// TestJsonPathEval tests the values selected by the JSONPath expressions of the grammar
+func TestJsonPathEval(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(jsonPathTestDoc), &doc); err != nil {
+		t.Fatalf("Parse json error: %v", err)
+	}
+	tests := []struct {
+		expr string
+		want string
+	}{
+		// paths
+		{expr: "$", want: ""},
+		{expr: "$.name", want: `["listener"]`},
+		{expr: "$['name', 'port']", want: `["listener",8080]`},
+		{expr: `$["name"]`, want: `["listener"]`},
+		{expr: "$.unknown", want: `null`},
+		{expr: "$.tags[*]", want: `["a","b","c","d"]`},
+		{expr: "$.limits.*", want: `["c0"]`},
+		{expr: "$.tags[0]", want: `["a"]`},
+		{expr: "$.tags[-1]", want: `["d"]`},
+		{expr: "$.tags[0, 2]", want: `["a","c"]`},
+		{expr: "$.tags[4]", want: `null`},
+		{expr: "$.tags[1:3]", want: `["b","c"]`},
+		{expr: "$.tags[:2]", want: `["a","b"]`},
+		{expr: "$.tags[-2:]", want: `["c","d"]`},
+		{expr: "$.tags[:]", want: `["a","b","c","d"]`},
+		{expr: "$..name", want: `["listener","r0","r1","r2","n0"]`},
+		{expr: "$..[0]", want: `["r0-object","a"]`},
+		{expr: "$.name[0]", want: `null`},
+		// filters
+		{expr: "$.routes[?(@.retries > 3)].name", want: `["r0"]`},
+		{expr: "$.routes[?(@.retries >= 3)].name", want: `["r0","r2"]`},
+		{expr: "$.routes[?(@.retries < 3)].name", want: `["r1"]`},
+		{expr: "$.routes[?(@.retries <= 3)].name", want: `["r1","r2"]`},
+		{expr: "$.routes[?(@.retries == 1)].name", want: `["r1"]`},
+		{expr: "$.routes[?(@.retries != 1)].name", want: `["r0","r2"]`},
+		{expr: "$.routes[?(@.cluster == 'c0')].name", want: `["r0","r2"]`},
+		{expr: `$.routes[?(@.cluster == "c1")].name`, want: `["r1"]`},
+		{expr: "$.routes[?(@.cluster > 'c0')].name", want: `["r1"]`},
+		{expr: "$.routes[?(@.cluster == $.limits.max)].name", want: `["r0","r2"]`},
+		{expr: "$.routes[?(@.prefix =~ /^.api/)].name", want: `["r0"]`},
+		{expr: "$.routes[?(@.prefix =~ 'static$')].name", want: `["r1"]`},
+		{expr: "$.routes[?(@.retries > 1 && @.cluster == 'c0')].name", want: `["r0","r2"]`},
+		{expr: "$.routes[?(@.retries == 1 || @.retries == 3)].name", want: `["r1","r2"]`},
+		{expr: "$.routes[?(!(@.retries == 1 || @.retries == 3))].name", want: `["r0"]`},
+		{expr: "$.routes[?(!@.prefix)].name", want: `["r2"]`},
+		{expr: "$.routes[?( @.retries>3 )].name", want: `["r0"]`},
+		{expr: "$..[?(@.name == 'n0')].name", want: `["n0"]`},
+		// a filter on a path without an operator is a presence test, also of a false or null value
+		{expr: "$.routes[?(@.autoHostRewrite)].name", want: `["r0"]`},
+		{expr: "$.routes[?(@.timeout)].name", want: `["r2"]`},
+		{expr: "$.routes[?(@.timeout == null)].name", want: `["r2"]`},
+		{expr: "$[?(@ == false)]", want: `[false]`},
+		{expr: "$[?(@ == true)]", want: `null`},
+		// 64-bit integers are encoded as strings by protojson, and are compared as numbers
+		{expr: "$[?(@ == 42)]", want: `["42"]`},
+		{expr: "$[?(@ > 8000)]", want: `[8080]`},
+		{expr: "$[?(@ == 8.08e3)]", want: `[8080]`},
+		{expr: "$.routes[?(@.retries == -5)].name", want: `null`},
+	}
+	for _, test := range tests {
+		path, err := ParseJsonPath(test.expr)
+		if err != nil {
+			t.Errorf("ParseJsonPath(%q) error: %v", test.expr, err)
+			continue
+		}
+		got := path.Eval(doc)
+		if test.want == "" {
+			if len(got) != 1 || !reflect.DeepEqual(got[0], doc) {
+				t.Errorf("Eval(%q) = %v, want the document", test.expr, got)
+			}
+			continue
+		}
+		// an object is abbreviated to its name
+		for i, v := range got {
+			if m, ok := v.(map[string]interface{}); ok {
+				got[i] = m["name"].(string) + "-object"
+			}
+		}
+		js, _ := json.Marshal(got)
+		if string(js) != test.want {
+			t.Errorf("Eval(%q) = %s, want %s", test.expr, js, test.want)
+		}
+	}
+}
+
+// TestParseJsonPathError tests the errors of the invalid JSONPath expressions
+func TestParseJsonPathError(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: "routes", want: "must start with $"},
+		{expr: "", want: "must start with $"},
+		{expr: "$routes", want: `unexpected "routes"`},
+		{expr: "$.", want: "expect a name"},
+		{expr: "$.routes[", want: "expect an index, a slice, a quoted name, * or a filter"},
+		{expr: "$.routes[0", want: "expect ]"},
+		{expr: "$.routes[0,]", want: "expect an index"},
+		{expr: "$.routes['name", want: "unterminated string"},
+		{expr: "$.routes[?(@.name == 'a']", want: "expect ) to close the filter"},
+		{expr: "$.routes[?((@.name == 'a')]", want: "expect )"},
+		{expr: "$..routes[?(@.name ==)]", want: "expect @, $, a string, a number, true, false or null"},
+		{expr: "$.routes[?(@.name == foo)]", want: "expect @, $, a string, a number, true, false or null"},
+		{expr: "$.routes[?(@.enabled == trueish)]", want: "expect @, $, a string, a number, true, false or null"},
+		{expr: "$.routes[?(@.enabled == nullable)]", want: "expect @, $, a string, a number, true, false or null"},
+		{expr: "$.routes[?(@.enabled == false_)]", want: "expect @, $, a string, a number, true, false or null"},
+		{expr: "$.routes[?(true)]", want: "expect a comparison operator"},
+		{expr: "$.routes[?('a')]", want: "expect a comparison operator"},
+		{expr: "$[?(@.name =~ /[/)]", want: "error parsing regexp"},
+		{expr: "$[?(@.name =~ /a)]", want: "unterminated regex"},
+		{expr: "$[?(@.name =~ a)]", want: "expect a /regex/ after =~"},
+		{expr: "$.routes[?(@.retries > 1 &&)]", want: "expect @, $, a string, a number, true, false or null"},
+		{expr: "$.routes[0] foo", want: `unexpected "foo"`},
+	}
+	for _, test := range tests {
+		_, err := ParseJsonPath(test.expr)
+		if err == nil {
+			t.Errorf("ParseJsonPath(%q) should fail", test.expr)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("ParseJsonPath(%q) error = %v, want %q", test.expr, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoy_extensions_filters_network_http_connection_manager_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// the severities of the lint problems
+const (
+	LintError   = "ERROR"
+	LintWarning = "WARNING"
+)
+
+// LintProblem is a problem found in the config of a client
+type LintProblem struct {
+	Id       string
+	Severity string
+	// Rule is the name of the check, e.g. "nacked", "missing-route-config", "missing-cluster", ...
+	Rule    string
+	Xds     string
+	Name    string
+	Message string
+}
+
+// Lint checks the configs of the clients for problems:
+//
+//	nacked                the resource is rejected by the client, or in error according to the control plane
+//	missing-route-config  a listener refers to a route configuration that is not reported
+//	missing-cluster       a route refers to a cluster that is not reported
+//	missing-endpoints     an EDS cluster has no endpoints reported
+//
+// The references to the resources of an xDS type are only checked if the client reports that type,
+// as a control plane may not serve all the types to a client.
+func Lint(clients []ClientConfig) []LintProblem {
+	var problems []LintProblem
+	for _, c := range clients {
+		problems = append(problems, lintClient(c)...)
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Id != problems[j].Id {
+			return problems[i].Id < problems[j].Id
+		}
+		if problems[i].Xds != problems[j].Xds {
+			return problems[i].Xds < problems[j].Xds
+		}
+		return problems[i].Name < problems[j].Name
+	})
+	return problems
+}
+
+// lintClient checks the config of a client
+func lintClient(c ClientConfig) []LintProblem {
+	var problems []LintProblem
+	add := func(severity string, rule string, r Resource, format string, a ...interface{}) {
+		problems = append(problems, LintProblem{Id: c.Id, Severity: severity, Rule: rule, Xds: r.Xds, Name: r.Name, Message: fmt.Sprintf(format, a...)})
+	}
+
+	reported := make(map[string]map[string]bool)
+	for _, status := range c.Statuses {
+		reported[status.Xds] = make(map[string]bool)
+	}
+	for _, r := range c.Resources {
+		if reported[r.Xds] == nil {
+			reported[r.Xds] = make(map[string]bool)
+		}
+		reported[r.Xds][r.Name] = true
+	}
+	// checkRefs reports the names that are not reported resources of the xDS type
+	checkRefs := func(r Resource, xds string, rule string, names []string) {
+		if reported[xds] == nil {
+			return
+		}
+		for _, name := range names {
+			if !reported[xds][name] {
+				add(LintError, rule, r, "refers to %v %v which is not reported", xds, name)
+			}
+		}
+	}
+
+	for _, r := range c.Resources {
+		if r.ClientStatus == "NACKED" || r.Status == "ERROR" {
+			add(LintError, "nacked", r, "the resource is rejected (status %v, client status %v)", r.Status, r.ClientStatus)
+		}
+		if r.Config == nil {
+			continue
+		}
+		m, err := DecodeResource(r.Config)
+		if err != nil {
+			continue
+		}
+		switch m := m.(type) {
+		case *envoy_config_listener_v3.Listener:
+			var routeConfigs []string
+			for _, hcm := range HttpConnectionManagers(m) {
+				if name := hcm.GetRds().GetRouteConfigName(); name != "" {
+					routeConfigs = append(routeConfigs, name)
+				}
+				checkRefs(r, "CDS", "missing-cluster", routeClusters(hcm.GetRouteConfig()))
+			}
+			checkRefs(r, "RDS", "missing-route-config", routeConfigs)
+		case *envoy_config_route_v3.RouteConfiguration:
+			checkRefs(r, "CDS", "missing-cluster", routeClusters(m))
+		case *envoy_config_cluster_v3.Cluster:
+			if m.GetType() != envoy_config_cluster_v3.Cluster_EDS || reported["EDS"] == nil {
+				continue
+			}
+			serviceName := m.GetEdsClusterConfig().GetServiceName()
+			if serviceName == "" {
+				serviceName = m.GetName()
+			}
+			if !reported["EDS"][serviceName] {
+				add(LintWarning, "missing-endpoints", r, "no endpoints are reported for %v", serviceName)
+			}
+		case *envoy_config_endpoint_v3.ClusterLoadAssignment:
+			var endpoints int
+			for _, locality := range m.GetEndpoints() {
+				endpoints += len(locality.GetLbEndpoints())
+			}
+			if endpoints == 0 {
+				add(LintWarning, "missing-endpoints", r, "the cluster load assignment has no endpoints")
+			}
+		}
+	}
+	return problems
+}
+
+// HttpConnectionManagers returns the HTTP connection managers in the filter chains of a listener. The
+// filters of v2 listeners are converted to v3.
+func HttpConnectionManagers(listener *envoy_config_listener_v3.Listener) []*envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager {
+	var hcms []*envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager
+	chains := append([]*envoy_config_listener_v3.FilterChain{}, listener.GetFilterChains()...)
+	if listener.GetDefaultFilterChain() != nil {
+		chains = append(chains, listener.GetDefaultFilterChain())
+	}
+	for _, chain := range chains {
+		for _, filter := range chain.GetFilters() {
+			config := filter.GetTypedConfig()
+			if !strings.HasSuffix(config.GetTypeUrl(), ".HttpConnectionManager") {
+				continue
+			}
+			hcm := &envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager{}
+			if err := proto.Unmarshal(config.GetValue(), hcm); err == nil {
+				hcms = append(hcms, hcm)
+			}
+		}
+	}
+	return hcms
+}
+
+// routeClusters returns the names of the clusters that the routes of the route configuration refer to
+func routeClusters(routeConfig *envoy_config_route_v3.RouteConfiguration) []string {
+	var clusters []string
+	for _, virtualHost := range routeConfig.GetVirtualHosts() {
+		for _, route := range virtualHost.GetRoutes() {
+			if cluster := route.GetRoute().GetCluster(); cluster != "" {
+				clusters = append(clusters, cluster)
+			}
+			for _, weighted := range route.GetRoute().GetWeightedClusters().GetClusters() {
+				clusters = append(clusters, weighted.GetName())
+			}
+		}
+	}
+	return clusters
+}
+
+// PrintLintProblems prints out the problems found by Lint
+func PrintLintProblems(problems []LintProblem) {
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+		return
+	}
+	fmt.Printf("%-50s %-8s %-22s %-6s %-40s %v\n", "Client ID", "Severity", "Rule", "xDS", "Resource", "Message")
+	for _, p := range problems {
+		fmt.Printf("%-50s %-8s %-22s %-6s %-40s %v\n", p.Id, p.Severity, p.Rule, p.Xds, p.Name, p.Message)
+	}
+}
@@ -0,0 +1,26 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestLint tests the checks of lint, the references of the second client are not checked since
+// it does not report the clusters
+func TestLint(t *testing.T) {
+	configs := ReadClientConfigs(t, "./response_for_lint.json")
+	want := []string{
+		"test_node_1 WARNING missing-endpoints CDS fake_cluster",
+		"test_node_1 ERROR nacked CDS rejected_cluster",
+		"test_node_1 WARNING missing-endpoints EDS other_service",
+		"test_node_1 ERROR missing-route-config LDS fake_listener",
+		"test_node_1 ERROR missing-cluster RDS fake_route",
+	}
+	var got []string
+	for _, p := range Lint(configs) {
+		got = append(got, strings.Join([]string{p.Id, p.Severity, p.Rule, p.Xds, p.Name}, " "))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want\n%v\ngot\n%v", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
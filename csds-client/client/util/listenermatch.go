@@ -0,0 +1,360 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+)
+
+// Connection is an incoming connection to match against the listeners of a client
+type Connection struct {
+	DestinationIp   net.IP
+	DestinationPort uint32
+	// ServerName is the SNI of the connection, if it is TLS
+	ServerName string
+	// TransportProtocol is tls if the connection has an SNI or ALPN, raw_buffer otherwise, as the TLS
+	// inspector detects it
+	TransportProtocol    string
+	ApplicationProtocols []string
+	// SourceIp is 127.0.0.1 if it is not set
+	SourceIp   net.IP
+	SourcePort uint32
+}
+
+// ParseConnection parses a connection to ip:port from an optional source ip[:port], with an optional
+// SNI and comma separated ALPN protocols
+func ParseConnection(destination string, serverName string, alpn string, source string) (Connection, error) {
+	c := Connection{ServerName: serverName, TransportProtocol: "raw_buffer", SourceIp: net.ParseIP("127.0.0.1")}
+	host, port, err := net.SplitHostPort(destination)
+	if err != nil {
+		return c, fmt.Errorf("invalid destination %q, expected ip:port: %v", destination, err)
+	}
+	if c.DestinationIp = net.ParseIP(host); c.DestinationIp == nil {
+		return c, fmt.Errorf("invalid destination ip %q", host)
+	}
+	if c.DestinationPort, err = parsePort(port); err != nil {
+		return c, fmt.Errorf("invalid destination port %q", port)
+	}
+	if source != "" {
+		host = source
+		if h, p, err := net.SplitHostPort(source); err == nil {
+			host = h
+			if c.SourcePort, err = parsePort(p); err != nil {
+				return c, fmt.Errorf("invalid source port %q", p)
+			}
+		}
+		if c.SourceIp = net.ParseIP(host); c.SourceIp == nil {
+			return c, fmt.Errorf("invalid source ip %q", host)
+		}
+	}
+	if alpn != "" {
+		c.ApplicationProtocols = strings.Split(alpn, ",")
+	}
+	if c.ServerName != "" || len(c.ApplicationProtocols) > 0 {
+		c.TransportProtocol = "tls"
+	}
+	return c, nil
+}
+
+func parsePort(port string) (uint32, error) {
+	p, err := strconv.ParseUint(port, 10, 16)
+	return uint32(p), err
+}
+
+// ListenerMatch is the listener and the filter chain that a client selects for a connection
+type ListenerMatch struct {
+	Id       string
+	Listener string
+	Address  string
+	// FilterChain is the selected filter chain, nil if no filter chain matches and the listener has
+	// no default filter chain, in which case Envoy closes the connection
+	FilterChain *FilterChainSummary
+	// Steps explain how the listener and the filter chain are selected, each of them with the filter
+	// chains that are left
+	Steps []string
+}
+
+// candidateChain is a filter chain of a listener that is still a candidate for a connection
+type candidateChain struct {
+	name  string
+	chain *envoy_config_listener_v3.FilterChain
+}
+
+// MatchListener selects the listener and the filter chain of the client for the connection as Envoy
+// does. The listener bound to the destination ip and port is preferred over the one bound to the
+// wildcard address on the port, and the connections to other addresses are handled by the listener
+// with use_original_dst, as the one of a transparent proxy that they are redirected to.
+//
+// The filter chains are then narrowed by the criteria of their match in order: destination port,
+// destination ip, server name, transport protocol, application protocols, direct source ip, source
+// type, source ip and source port. At each step only the filter chains with the most specific match
+// are left, and the ones without the criterion only if no filter chain has a matching one. Envoy does
+// not backtrack, so the default filter chain is selected as soon as no filter chain is left.
+func MatchListener(c ClientConfig, conn Connection) (ListenerMatch, error) {
+	var listeners []*envoy_config_listener_v3.Listener
+	for _, r := range c.Resources {
+		if r.Config == nil {
+			continue
+		}
+		if m, err := DecodeResource(r.Config); err == nil {
+			if listener, ok := m.(*envoy_config_listener_v3.Listener); ok {
+				listeners = append(listeners, listener)
+			}
+		}
+	}
+	sort.SliceStable(listeners, func(i, j int) bool {
+		return listeners[i].GetName() < listeners[j].GetName()
+	})
+
+	destination := net.JoinHostPort(conn.DestinationIp.String(), fmt.Sprint(conn.DestinationPort))
+	var exact, wildcard, originalDst *envoy_config_listener_v3.Listener
+	for _, listener := range listeners {
+		socket := listener.GetAddress().GetSocketAddress()
+		ip := net.ParseIP(socket.GetAddress())
+		switch {
+		case ip == nil || socket.GetPortValue() != conn.DestinationPort:
+		case ip.Equal(conn.DestinationIp) && exact == nil:
+			exact = listener
+		case ip.IsUnspecified() && wildcard == nil:
+			wildcard = listener
+		}
+		if listener.GetUseOriginalDst().GetValue() && originalDst == nil {
+			originalDst = listener
+		}
+	}
+	m := ListenerMatch{Id: c.Id}
+	var listener *envoy_config_listener_v3.Listener
+	switch {
+	case exact != nil:
+		listener = exact
+		m.Steps = append(m.Steps, fmt.Sprintf("listener %v is bound to %v", listener.GetName(), destination))
+	case wildcard != nil:
+		listener = wildcard
+		m.Steps = append(m.Steps, fmt.Sprintf("listener %v is bound to the wildcard address on port %d", listener.GetName(), conn.DestinationPort))
+	case originalDst != nil:
+		listener = originalDst
+		m.Steps = append(m.Steps, fmt.Sprintf("no listener is bound to %v, the original destination listener %v handles it", destination, listener.GetName()))
+	default:
+		return m, fmt.Errorf("no listener of %v is bound to %v", c.Id, destination)
+	}
+	m.Listener = listener.GetName()
+	m.Address = FormatAddress(listener.GetAddress())
+
+	var candidates []candidateChain
+	for i, chain := range listener.GetFilterChains() {
+		name := chain.GetName()
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		candidates = append(candidates, candidateChain{name: name, chain: chain})
+	}
+	for _, step := range filterChainSteps(conn) {
+		if len(candidates) == 0 {
+			break
+		}
+		candidates = narrowChains(candidates, step.specificity)
+		var names []string
+		for _, candidate := range candidates {
+			names = append(names, candidate.name)
+		}
+		if len(names) == 0 {
+			names = []string{"none"}
+		}
+		m.Steps = append(m.Steps, fmt.Sprintf("%v: %v", step.name, strings.Join(names, ", ")))
+	}
+
+	switch {
+	case len(candidates) > 0:
+		// the filter chain matches are unique, so there is only one left after the last step
+		chain := summarizeFilterChain(candidates[0].name, candidates[0].chain)
+		m.FilterChain = &chain
+	case listener.GetDefaultFilterChain() != nil:
+		chain := summarizeFilterChain("default", listener.GetDefaultFilterChain())
+		m.FilterChain = &chain
+		m.Steps = append(m.Steps, "no filter chain matches, the default filter chain is selected")
+	default:
+		m.Steps = append(m.Steps, "no filter chain matches and there is no default filter chain, the connection is closed")
+	}
+	return m, nil
+}
+
+// filterChainStep is a criterion of the filter chain match. Its specificity is -1 if the filter
+// chain does not match the connection, 0 if the criterion is not set and higher the more specific
+// the match is.
+type filterChainStep struct {
+	name        string
+	specificity func(match *envoy_config_listener_v3.FilterChainMatch) int
+}
+
+// filterChainSteps returns the criteria of the filter chain match in the order Envoy applies them
+func filterChainSteps(conn Connection) []filterChainStep {
+	sourceIsLocal := conn.SourceIp.IsLoopback() || conn.SourceIp.Equal(conn.DestinationIp)
+	serverName := conn.ServerName
+	if serverName == "" {
+		serverName = "(none)"
+	}
+	alpn := strings.Join(conn.ApplicationProtocols, ",")
+	if alpn == "" {
+		alpn = "(none)"
+	}
+	return []filterChainStep{
+		{fmt.Sprintf("destination port %d", conn.DestinationPort), func(match *envoy_config_listener_v3.FilterChainMatch) int {
+			if match.GetDestinationPort() == nil {
+				return 0
+			}
+			return boolSpecificity(match.GetDestinationPort().GetValue() == conn.DestinationPort)
+		}},
+		{fmt.Sprintf("destination ip %v", conn.DestinationIp), func(match *envoy_config_listener_v3.FilterChainMatch) int {
+			return cidrSpecificity(match.GetPrefixRanges(), conn.DestinationIp)
+		}},
+		{"server name " + serverName, func(match *envoy_config_listener_v3.FilterChainMatch) int {
+			return serverNameSpecificity(match.GetServerNames(), conn.ServerName)
+		}},
+		{"transport protocol " + conn.TransportProtocol, func(match *envoy_config_listener_v3.FilterChainMatch) int {
+			if match.GetTransportProtocol() == "" {
+				return 0
+			}
+			return boolSpecificity(match.GetTransportProtocol() == conn.TransportProtocol)
+		}},
+		{"application protocols " + alpn, func(match *envoy_config_listener_v3.FilterChainMatch) int {
+			if len(match.GetApplicationProtocols()) == 0 {
+				return 0
+			}
+			for _, protocol := range match.GetApplicationProtocols() {
+				for _, p := range conn.ApplicationProtocols {
+					if protocol == p {
+						return 1
+					}
+				}
+			}
+			return -1
+		}},
+		{fmt.Sprintf("direct source ip %v", conn.SourceIp), func(match *envoy_config_listener_v3.FilterChainMatch) int {
+			return cidrSpecificity(match.GetDirectSourcePrefixRanges(), conn.SourceIp)
+		}},
+		{"source type", func(match *envoy_config_listener_v3.FilterChainMatch) int {
+			switch match.GetSourceType() {
+			case envoy_config_listener_v3.FilterChainMatch_SAME_IP_OR_LOOPBACK:
+				return boolSpecificity(sourceIsLocal)
+			case envoy_config_listener_v3.FilterChainMatch_EXTERNAL:
+				return boolSpecificity(!sourceIsLocal)
+			}
+			return 0
+		}},
+		{fmt.Sprintf("source ip %v", conn.SourceIp), func(match *envoy_config_listener_v3.FilterChainMatch) int {
+			return cidrSpecificity(match.GetSourcePrefixRanges(), conn.SourceIp)
+		}},
+		{fmt.Sprintf("source port %d", conn.SourcePort), func(match *envoy_config_listener_v3.FilterChainMatch) int {
+			if len(match.GetSourcePorts()) == 0 {
+				return 0
+			}
+			for _, port := range match.GetSourcePorts() {
+				if port == conn.SourcePort {
+					return 1
+				}
+			}
+			return -1
+		}},
+	}
+}
+
+// narrowChains keeps the filter chains with the most specific match
+func narrowChains(candidates []candidateChain, specificity func(match *envoy_config_listener_v3.FilterChainMatch) int) []candidateChain {
+	best := -1
+	for _, candidate := range candidates {
+		if s := specificity(candidate.chain.GetFilterChainMatch()); s > best {
+			best = s
+		}
+	}
+	var narrowed []candidateChain
+	if best < 0 {
+		return narrowed
+	}
+	for _, candidate := range candidates {
+		if specificity(candidate.chain.GetFilterChainMatch()) == best {
+			narrowed = append(narrowed, candidate)
+		}
+	}
+	return narrowed
+}
+
+func boolSpecificity(matches bool) int {
+	if matches {
+		return 1
+	}
+	return -1
+}
+
+// cidrSpecificity returns 1 + the length of the longest prefix of the ranges that contains the ip
+func cidrSpecificity(ranges []*envoy_config_core_v3.CidrRange, ip net.IP) int {
+	if len(ranges) == 0 {
+		return 0
+	}
+	best := -1
+	for _, r := range ranges {
+		prefix := net.ParseIP(r.GetAddressPrefix())
+		if prefix == nil {
+			continue
+		}
+		length := int(r.GetPrefixLen().GetValue())
+		_, network, err := net.ParseCIDR(fmt.Sprintf("%v/%d", prefix, length))
+		if err != nil || !network.Contains(ip) {
+			continue
+		}
+		if length+1 > best {
+			best = length + 1
+		}
+	}
+	return best
+}
+
+// serverNameSpecificity prefers the exact server names over the longest wildcard ones, e.g.
+// *.example.com over *.com
+func serverNameSpecificity(serverNames []string, serverName string) int {
+	if len(serverNames) == 0 {
+		return 0
+	}
+	best := -1
+	for _, name := range serverNames {
+		switch {
+		case name == serverName && serverName != "":
+			return 1 << 16
+		case strings.HasPrefix(name, "*.") && strings.HasSuffix(serverName, name[1:]) && len(serverName) > len(name)-1:
+			if len(name) > best {
+				best = len(name)
+			}
+		}
+	}
+	return best
+}
+
+// PrintListenerMatch prints out the selected listener and filter chain, how they are selected and
+// the filters of the filter chain
+func PrintListenerMatch(m ListenerMatch) {
+	fmt.Printf("%-18s %v\n", "Client ID:", m.Id)
+	fmt.Printf("%-18s %v %v\n", "Listener:", m.Listener, m.Address)
+	if m.FilterChain == nil {
+		fmt.Printf("%-18s %v\n", "Filter Chain:", "none")
+	} else {
+		fmt.Printf("%-18s %v (%v)\n", "Filter Chain:", m.FilterChain.Name, m.FilterChain.Match)
+	}
+	fmt.Println("Steps:")
+	for _, step := range m.Steps {
+		fmt.Printf("  %v\n", step)
+	}
+	if m.FilterChain == nil {
+		return
+	}
+	fmt.Println("Filters:")
+	for _, filter := range m.FilterChain.Filters {
+		fmt.Println(strings.TrimRight(fmt.Sprintf("  %v %v", filter.Name, filter.Detail), " "))
+		for _, httpFilter := range filter.HttpFilters {
+			fmt.Println(strings.TrimRight(fmt.Sprintf("    %v %v", httpFilter.Name, httpFilter.Detail), " "))
+		}
+	}
+}
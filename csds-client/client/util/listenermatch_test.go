@@ -0,0 +1,76 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestMatchListener tests the selection of the listener and the filter chain for a connection, and the
+// steps that explain it
+func TestMatchListener(t *testing.T) {
+	clients := ReadClientConfigs(t, "./response_for_listeners.json")
+	tests := []struct {
+		name        string
+		destination string
+		sni         string
+		alpn        string
+		source      string
+		listener    string
+		filterChain string
+		wantErr     string
+	}{
+		{name: "wildcard server name", destination: "192.168.1.1:443", sni: "a.web.example.com", alpn: "h2", listener: "ingress", filterChain: "web"},
+		{name: "destination ip", destination: "10.0.0.5:443", source: "127.0.0.1:1234", listener: "ingress", filterChain: "#1"},
+		// the chain of 10.0.0.0/8 is the only one left after the destination ip, so there is no
+		// backtracking to the other chains when its source port does not match
+		{name: "default filter chain", destination: "10.0.0.5:443", sni: "web.example.com", source: "127.0.0.1:999", listener: "ingress", filterChain: "default"},
+		{name: "bound address", destination: "[::1]:9901", listener: "admin", filterChain: "#0"},
+		{name: "no listener", destination: "1.2.3.4:80", wantErr: "no listener of test_node_1 is bound to 1.2.3.4:80"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := ParseConnection(tt.destination, tt.sni, tt.alpn, tt.source)
+			if err != nil {
+				t.Fatalf("ParseConnection() error = %v", err)
+			}
+			m, err := MatchListener(clients[0], conn)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("MatchListener() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MatchListener() error = %v", err)
+			}
+			if m.Listener != tt.listener || m.FilterChain == nil || m.FilterChain.Name != tt.filterChain {
+				t.Errorf("MatchListener() = %v %+v, want %v %v", m.Listener, m.FilterChain, tt.listener, tt.filterChain)
+			}
+		})
+	}
+
+	conn, err := ParseConnection("10.0.0.5:443", "web.example.com", "", "127.0.0.1:999")
+	if err != nil {
+		t.Fatalf("ParseConnection() error = %v", err)
+	}
+	m, err := MatchListener(clients[0], conn)
+	if err != nil {
+		t.Fatalf("MatchListener() error = %v", err)
+	}
+	want := []string{
+		"listener ingress is bound to the wildcard address on port 443",
+		"destination port 443: web, #1",
+		"destination ip 10.0.0.5: #1",
+		"server name web.example.com: #1",
+		"transport protocol tls: #1",
+		"application protocols (none): #1",
+		"direct source ip 127.0.0.1: #1",
+		"source type: #1",
+		"source ip 127.0.0.1: #1",
+		"source port 999: none",
+		"no filter chain matches, the default filter chain is selected",
+	}
+	if m.Address != "0.0.0.0:443" || !reflect.DeepEqual(m.Steps, want) {
+		t.Errorf("MatchListener() = %v %v, want 0.0.0.0:443 %v", m.Address, m.Steps, want)
+	}
+}
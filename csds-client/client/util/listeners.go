@@ -0,0 +1,311 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	envoy_extensions_filters_http_fault_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	envoy_extensions_filters_http_router_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	envoy_extensions_filters_network_http_connection_manager_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	envoy_extensions_filters_network_tcp_proxy_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// ListenerSummary is the view of the filter chains of a listener of a client
+type ListenerSummary struct {
+	Id   string
+	Name string
+	// Address is address:port, or unix:path for a pipe
+	Address         string
+	ListenerFilters []string
+	// FilterChains are in the order of the listener, followed by the default filter chain if any
+	FilterChains []FilterChainSummary
+}
+
+// FilterChainSummary is the view of a filter chain of a listener
+type FilterChainSummary struct {
+	// Name is the name of the filter chain, #index if it has none, or default for the default
+	// filter chain
+	Name string
+	// Match are the criteria of the filter chain match, any if there are none
+	Match string
+	// Tls is set if the filter chain terminates TLS with a DownstreamTlsContext
+	Tls     bool
+	Filters []FilterSummary
+}
+
+// FilterSummary is a network filter of a filter chain, or an HTTP filter of an HTTP connection manager
+type FilterSummary struct {
+	Name string
+	// Detail is what the typed config of the filter is about, e.g. the routes of an HTTP
+	// connection manager or the cluster of a TCP proxy
+	Detail string
+	// HttpFilters are the HTTP filters of an HTTP connection manager in order
+	HttpFilters []FilterSummary
+}
+
+// Listeners returns the summaries of the listeners of the client by name. v2 listeners are upgraded
+// to v3 along with the tls_context and the config structs of the filters of their filter chains.
+func Listeners(c ClientConfig) []ListenerSummary {
+	var summaries []ListenerSummary
+	for _, r := range c.Resources {
+		if r.Config == nil {
+			continue
+		}
+		m, err := DecodeResource(r.Config)
+		if err != nil {
+			continue
+		}
+		if listener, ok := m.(*envoy_config_listener_v3.Listener); ok {
+			summaries = append(summaries, summarizeListener(c.Id, listener))
+		}
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
+
+// summarizeListener summarizes the address and the filter chains of the listener
+func summarizeListener(id string, listener *envoy_config_listener_v3.Listener) ListenerSummary {
+	s := ListenerSummary{Id: id, Name: listener.GetName(), Address: FormatAddress(listener.GetAddress())}
+	for _, filter := range listener.GetListenerFilters() {
+		s.ListenerFilters = append(s.ListenerFilters, filter.GetName())
+	}
+	for i, chain := range listener.GetFilterChains() {
+		name := chain.GetName()
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		s.FilterChains = append(s.FilterChains, summarizeFilterChain(name, chain))
+	}
+	if chain := listener.GetDefaultFilterChain(); chain != nil {
+		s.FilterChains = append(s.FilterChains, summarizeFilterChain("default", chain))
+	}
+	return s
+}
+
+// summarizeFilterChain summarizes the match and the filters of the filter chain
+func summarizeFilterChain(name string, chain *envoy_config_listener_v3.FilterChain) FilterChainSummary {
+	s := FilterChainSummary{
+		Name:  name,
+		Match: FormatFilterChainMatch(chain.GetFilterChainMatch()),
+		Tls:   strings.HasSuffix(chain.GetTransportSocket().GetTypedConfig().GetTypeUrl(), ".DownstreamTlsContext"),
+	}
+	for _, filter := range chain.GetFilters() {
+		s.Filters = append(s.Filters, summarizeNetworkFilter(filter.GetName(), filter.GetTypedConfig()))
+	}
+	return s
+}
+
+// summarizeNetworkFilter describes the typed config of the HTTP connection managers and the TCP
+// proxies, along with the HTTP filters of the HTTP connection managers
+func summarizeNetworkFilter(name string, config *anypb.Any) FilterSummary {
+	s := FilterSummary{Name: name}
+	switch typeUrl := config.GetTypeUrl(); {
+	case strings.HasSuffix(typeUrl, ".HttpConnectionManager"):
+		hcm := &envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager{}
+		if err := proto.Unmarshal(config.GetValue(), hcm); err != nil {
+			return s
+		}
+		switch {
+		case hcm.GetRds() != nil:
+			s.Detail = "rds=" + hcm.GetRds().GetRouteConfigName()
+		case hcm.GetRouteConfig() != nil:
+			s.Detail = fmt.Sprintf("route_config=%v (%d virtual hosts)", hcm.GetRouteConfig().GetName(), len(hcm.GetRouteConfig().GetVirtualHosts()))
+		case hcm.GetScopedRoutes() != nil:
+			s.Detail = "scoped_routes=" + hcm.GetScopedRoutes().GetName()
+		}
+		for _, filter := range hcm.GetHttpFilters() {
+			httpFilter := FilterSummary{Name: filter.GetName(), Detail: httpFilterDetail(filter.GetTypedConfig())}
+			if filter.GetConfigDiscovery() != nil {
+				httpFilter.Detail = "discovered by ECDS"
+			}
+			s.HttpFilters = append(s.HttpFilters, httpFilter)
+		}
+	case strings.HasSuffix(typeUrl, ".TcpProxy"):
+		tcpProxy := &envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy{}
+		if err := proto.Unmarshal(config.GetValue(), tcpProxy); err != nil {
+			return s
+		}
+		if cluster := tcpProxy.GetCluster(); cluster != "" {
+			s.Detail = "cluster=" + cluster
+		}
+		var weighted []string
+		for _, cluster := range tcpProxy.GetWeightedClusters().GetClusters() {
+			weighted = append(weighted, fmt.Sprintf("%v:%d", cluster.GetName(), cluster.GetWeight()))
+		}
+		if len(weighted) > 0 {
+			s.Detail = "weighted_clusters=" + strings.Join(weighted, ",")
+		}
+	}
+	return s
+}
+
+// httpFilterDetail describes the faults of the fault filters, the CORS filters and the options of the
+// routers
+func httpFilterDetail(config *anypb.Any) string {
+	var details []string
+	switch typeUrl := config.GetTypeUrl(); {
+	case strings.HasSuffix(typeUrl, ".HTTPFault"):
+		fault := &envoy_extensions_filters_http_fault_v3.HTTPFault{}
+		if err := proto.Unmarshal(config.GetValue(), fault); err != nil {
+			return ""
+		}
+		if delay := fault.GetDelay(); delay != nil {
+			if delay.GetFixedDelay() != nil {
+				details = append(details, fmt.Sprintf("delay=%v at %v", formatDuration(delay.GetFixedDelay()), formatPercent(delay.GetPercentage())))
+			} else {
+				details = append(details, "delay by header at "+formatPercent(delay.GetPercentage()))
+			}
+		}
+		if abort := fault.GetAbort(); abort != nil {
+			switch {
+			case abort.GetHttpStatus() != 0:
+				details = append(details, fmt.Sprintf("abort=%d at %v", abort.GetHttpStatus(), formatPercent(abort.GetPercentage())))
+			case abort.GetGrpcStatus() != 0:
+				details = append(details, fmt.Sprintf("abort=grpc %d at %v", abort.GetGrpcStatus(), formatPercent(abort.GetPercentage())))
+			default:
+				details = append(details, "abort by header at "+formatPercent(abort.GetPercentage()))
+			}
+		}
+	case strings.HasSuffix(typeUrl, ".Cors"):
+		// the CORS filter applies the policies of the virtual hosts and the routes
+		details = append(details, "policies of the routes")
+	case strings.HasSuffix(typeUrl, ".Router"):
+		router := &envoy_extensions_filters_http_router_v3.Router{}
+		if err := proto.Unmarshal(config.GetValue(), router); err != nil {
+			return ""
+		}
+		if router.GetDynamicStats() != nil && !router.GetDynamicStats().GetValue() {
+			details = append(details, "no dynamic stats")
+		}
+		if router.GetStartChildSpan() {
+			details = append(details, "start_child_span")
+		}
+		if router.GetSuppressEnvoyHeaders() {
+			details = append(details, "suppress_envoy_headers")
+		}
+	}
+	return strings.Join(details, " ")
+}
+
+// formatPercent formats a fractional percent as a percentage, e.g. 0.5%
+func formatPercent(p *envoy_type_v3.FractionalPercent) string {
+	if p == nil {
+		// the default percentage of the faults is 100%
+		return "100%"
+	}
+	denominator := 100.0
+	switch p.GetDenominator() {
+	case envoy_type_v3.FractionalPercent_TEN_THOUSAND:
+		denominator = 10000
+	case envoy_type_v3.FractionalPercent_MILLION:
+		denominator = 1000000
+	}
+	return fmt.Sprintf("%g%%", float64(p.GetNumerator())*100/denominator)
+}
+
+// FormatAddress formats a socket address as address:port, or a pipe as unix:path
+func FormatAddress(address *envoy_config_core_v3.Address) string {
+	if pipe := address.GetPipe(); pipe != nil {
+		return "unix:" + pipe.GetPath()
+	}
+	socket := address.GetSocketAddress()
+	if socket == nil {
+		return "-"
+	}
+	host := socket.GetAddress()
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if socket.GetNamedPort() != "" {
+		return host + ":" + socket.GetNamedPort()
+	}
+	return fmt.Sprintf("%v:%d", host, socket.GetPortValue())
+}
+
+// FormatFilterChainMatch formats the criteria of a filter chain match, e.g.
+// dst_port=443 server_names=*.example.com transport=tls, or any if there are none
+func FormatFilterChainMatch(match *envoy_config_listener_v3.FilterChainMatch) string {
+	var criteria []string
+	add := func(name string, values []string) {
+		if len(values) > 0 {
+			criteria = append(criteria, name+"="+strings.Join(values, ","))
+		}
+	}
+	if match.GetDestinationPort() != nil {
+		add("dst_port", []string{fmt.Sprint(match.GetDestinationPort().GetValue())})
+	}
+	add("dst", formatCidrRanges(match.GetPrefixRanges()))
+	add("server_names", match.GetServerNames())
+	if match.GetTransportProtocol() != "" {
+		add("transport", []string{match.GetTransportProtocol()})
+	}
+	add("alpn", match.GetApplicationProtocols())
+	add("direct_src", formatCidrRanges(match.GetDirectSourcePrefixRanges()))
+	if match.GetSourceType() != envoy_config_listener_v3.FilterChainMatch_ANY {
+		add("source_type", []string{strings.ToLower(match.GetSourceType().String())})
+	}
+	add("src", formatCidrRanges(match.GetSourcePrefixRanges()))
+	var ports []string
+	for _, port := range match.GetSourcePorts() {
+		ports = append(ports, fmt.Sprint(port))
+	}
+	add("src_ports", ports)
+	if len(criteria) == 0 {
+		return "any"
+	}
+	return strings.Join(criteria, " ")
+}
+
+// formatCidrRanges formats the ranges as address/prefix_len
+func formatCidrRanges(ranges []*envoy_config_core_v3.CidrRange) []string {
+	var formatted []string
+	for _, r := range ranges {
+		if r.GetPrefixLen() == nil {
+			formatted = append(formatted, r.GetAddressPrefix())
+		} else {
+			formatted = append(formatted, fmt.Sprintf("%v/%d", r.GetAddressPrefix(), r.GetPrefixLen().GetValue()))
+		}
+	}
+	return formatted
+}
+
+// PrintListeners prints out the listeners with their listener filters, then each filter chain with
+// its network filters in order, and the HTTP filters of the HTTP connection managers indented
+func PrintListeners(listeners []ListenerSummary) {
+	if len(listeners) == 0 {
+		fmt.Println("No listeners are reported")
+		return
+	}
+	row := func(columns ...interface{}) {
+		fmt.Println(strings.TrimRight(fmt.Sprintf("%-50s %-30s %-22s %-16s %-50s %-4s %-50s %v", columns...), " "))
+	}
+	row("Client ID", "Listener", "Address", "Filter Chain", "Match", "TLS", "Filter", "Detail")
+	for _, l := range listeners {
+		listenerFilters := "-"
+		if len(l.ListenerFilters) > 0 {
+			listenerFilters = strings.Join(l.ListenerFilters, ", ")
+		}
+		row(l.Id, l.Name, l.Address, "", "", "", listenerFilters, "")
+		for _, chain := range l.FilterChains {
+			tls := "off"
+			if chain.Tls {
+				tls = "on"
+			}
+			row("", "", "", chain.Name, chain.Match, tls, "", "")
+			for _, filter := range chain.Filters {
+				row("", "", "", "", "", "", filter.Name, filter.Detail)
+				for _, httpFilter := range filter.HttpFilters {
+					row("", "", "", "", "", "", "  "+httpFilter.Name, httpFilter.Detail)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestListeners tests the summaries of the filter chains, filters and routes of the listeners, with
+// the default filter chain last
+func TestListeners(t *testing.T) {
+	clients := ReadClientConfigs(t, "./response_for_listeners.json")
+	hcm := "envoy.filters.network.http_connection_manager"
+	tcpProxy := "envoy.filters.network.tcp_proxy"
+	want := []ListenerSummary{
+		{
+			Id:      "test_node_1",
+			Name:    "admin",
+			Address: "[::1]:9901",
+			FilterChains: []FilterChainSummary{
+				{Name: "#0", Match: "any", Filters: []FilterSummary{
+					{Name: hcm, Detail: "route_config=local_route (1 virtual hosts)", HttpFilters: []FilterSummary{{Name: "envoy.filters.http.router"}}},
+				}},
+			},
+		},
+		{
+			Id:              "test_node_1",
+			Name:            "ingress",
+			Address:         "0.0.0.0:443",
+			ListenerFilters: []string{"envoy.filters.listener.tls_inspector"},
+			FilterChains: []FilterChainSummary{
+				{Name: "web", Match: "server_names=web.example.com,*.web.example.com transport=tls alpn=h2", Tls: true, Filters: []FilterSummary{
+					{Name: hcm, Detail: "rds=web_routes", HttpFilters: []FilterSummary{
+						// the percentage of the delay is per ten thousand, and the one of the abort per hundred
+						{Name: "envoy.filters.http.fault", Detail: "delay=500ms at 0.25% abort=503 at 10%"},
+						{Name: "envoy.filters.http.cors", Detail: "policies of the routes"},
+						{Name: "envoy.filters.http.router", Detail: "suppress_envoy_headers"},
+					}},
+				}},
+				{Name: "#1", Match: "dst=10.0.0.0/8 source_type=same_ip_or_loopback src_ports=1234", Filters: []FilterSummary{
+					{Name: tcpProxy, Detail: "weighted_clusters=blue:80,green:20"},
+				}},
+				{Name: "default", Match: "any", Filters: []FilterSummary{
+					{Name: tcpProxy, Detail: "cluster=passthrough"},
+				}},
+			},
+		},
+	}
+	if got := Listeners(clients[0]); !reflect.DeepEqual(got, want) {
+		t.Errorf("Listeners() = %+v, want %+v", got, want)
+	}
+}
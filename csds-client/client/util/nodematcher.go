@@ -0,0 +1,216 @@
+package util
+
+import (
+	"envoy-tools/csds-client/client"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// NodeMatcherFromOptions builds the NodeMatcher of the -node_id, -node_id_prefix, -metadata and
+// -gcp_project, -gcp_network and -gcp_mesh flags, or returns nil if none of them is set. The gcp
+// flags are exact matches on the TRAFFICDIRECTOR_* metadata keys.
+func NodeMatcherFromOptions(opts client.ClientOptions) (*envoy_type_matcher_v3.NodeMatcher, error) {
+	if opts.NodeId != "" && opts.NodeIdPrefix != "" {
+		return nil, fmt.Errorf("cannot set both -node_id and -node_id_prefix")
+	}
+	var keys []string
+	values := make(map[string]string)
+	add := func(key string, value string) {
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	for _, kv := range opts.Metadata {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid -metadata %v, expected KEY=VALUE", kv)
+		}
+		add(kv[:i], kv[i+1:])
+	}
+	for key, value := range map[string]string{
+		"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER": opts.GcpProject,
+		"TRAFFICDIRECTOR_NETWORK_NAME":       opts.GcpNetwork,
+		"TRAFFICDIRECTOR_MESH_SCOPE_NAME":    opts.GcpMesh,
+	} {
+		if value != "" {
+			add(key, value)
+		}
+	}
+	if opts.NodeId == "" && opts.NodeIdPrefix == "" && len(keys) == 0 {
+		return nil, nil
+	}
+
+	matcher := &envoy_type_matcher_v3.NodeMatcher{}
+	switch {
+	case opts.NodeId != "":
+		matcher.NodeId = &envoy_type_matcher_v3.StringMatcher{MatchPattern: &envoy_type_matcher_v3.StringMatcher_Exact{Exact: opts.NodeId}}
+	case opts.NodeIdPrefix != "":
+		matcher.NodeId = &envoy_type_matcher_v3.StringMatcher{MatchPattern: &envoy_type_matcher_v3.StringMatcher_Prefix{Prefix: opts.NodeIdPrefix}}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		matcher.NodeMetadatas = append(matcher.NodeMetadatas, &envoy_type_matcher_v3.StructMatcher{
+			Path: []*envoy_type_matcher_v3.StructMatcher_PathSegment{
+				{Segment: &envoy_type_matcher_v3.StructMatcher_PathSegment_Key{Key: key}},
+			},
+			Value: &envoy_type_matcher_v3.ValueMatcher{
+				MatchPattern: &envoy_type_matcher_v3.ValueMatcher_StringMatch{
+					StringMatch: &envoy_type_matcher_v3.StringMatcher{
+						MatchPattern: &envoy_type_matcher_v3.StringMatcher_Exact{Exact: values[key]},
+					},
+				},
+			},
+		})
+	}
+	return matcher, nil
+}
+
+// MatchNode reports whether the node matches any of the NodeMatchers, all the nodes match if there
+// is no NodeMatcher. The matchers are evaluated as Envoy evaluates them.
+func MatchNode(matchers []*envoy_type_matcher_v3.NodeMatcher, node *envoy_config_core_v3.Node) bool {
+	if len(matchers) == 0 {
+		return true
+	}
+	for _, matcher := range matchers {
+		if matchNodeMatcher(matcher, node) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchNodeMatcher reports whether the node matches the node id and all the node metadatas of the NodeMatcher
+func matchNodeMatcher(matcher *envoy_type_matcher_v3.NodeMatcher, node *envoy_config_core_v3.Node) bool {
+	if matcher.GetNodeId() != nil && !MatchString(matcher.GetNodeId(), node.GetId()) {
+		return false
+	}
+	for _, structMatcher := range matcher.GetNodeMetadatas() {
+		if !matchStruct(structMatcher, node.GetMetadata()) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchStruct looks up the value at the path in the struct and matches it with the ValueMatcher
+func matchStruct(matcher *envoy_type_matcher_v3.StructMatcher, s *structpb.Struct) bool {
+	var value *structpb.Value
+	for i, segment := range matcher.GetPath() {
+		if s == nil {
+			return false
+		}
+		var ok bool
+		if value, ok = s.GetFields()[segment.GetKey()]; !ok {
+			return false
+		}
+		if i < len(matcher.GetPath())-1 {
+			s = value.GetStructValue()
+		}
+	}
+	return matchValue(matcher.GetValue(), value)
+}
+
+// matchValue matches a value with the ValueMatcher
+func matchValue(matcher *envoy_type_matcher_v3.ValueMatcher, value *structpb.Value) bool {
+	switch pattern := matcher.GetMatchPattern().(type) {
+	case *envoy_type_matcher_v3.ValueMatcher_NullMatch_:
+		_, ok := value.GetKind().(*structpb.Value_NullValue)
+		return ok
+	case *envoy_type_matcher_v3.ValueMatcher_DoubleMatch:
+		number, ok := value.GetKind().(*structpb.Value_NumberValue)
+		if !ok {
+			return false
+		}
+		if r := pattern.DoubleMatch.GetRange(); r != nil {
+			return number.NumberValue >= r.GetStart() && number.NumberValue < r.GetEnd()
+		}
+		return number.NumberValue == pattern.DoubleMatch.GetExact()
+	case *envoy_type_matcher_v3.ValueMatcher_StringMatch:
+		str, ok := value.GetKind().(*structpb.Value_StringValue)
+		return ok && MatchString(pattern.StringMatch, str.StringValue)
+	case *envoy_type_matcher_v3.ValueMatcher_BoolMatch:
+		b, ok := value.GetKind().(*structpb.Value_BoolValue)
+		return ok && b.BoolValue == pattern.BoolMatch
+	case *envoy_type_matcher_v3.ValueMatcher_PresentMatch:
+		// only a present value matches, as in Envoy present_match: false never matches
+		return pattern.PresentMatch && value.GetKind() != nil
+	case *envoy_type_matcher_v3.ValueMatcher_ListMatch:
+		for _, item := range value.GetListValue().GetValues() {
+			if matchValue(pattern.ListMatch.GetOneOf(), item) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// MatchString matches a string with the StringMatcher. An invalid regex never matches.
+func MatchString(matcher *envoy_type_matcher_v3.StringMatcher, s string) bool {
+	ignoreCase := matcher.GetIgnoreCase()
+	fold := func(s string) string {
+		if ignoreCase {
+			return strings.ToLower(s)
+		}
+		return s
+	}
+	switch pattern := matcher.GetMatchPattern().(type) {
+	case *envoy_type_matcher_v3.StringMatcher_Exact:
+		return fold(s) == fold(pattern.Exact)
+	case *envoy_type_matcher_v3.StringMatcher_Prefix:
+		return strings.HasPrefix(fold(s), fold(pattern.Prefix))
+	case *envoy_type_matcher_v3.StringMatcher_Suffix:
+		return strings.HasSuffix(fold(s), fold(pattern.Suffix))
+	case *envoy_type_matcher_v3.StringMatcher_Contains:
+		return strings.Contains(fold(s), fold(pattern.Contains))
+	case *envoy_type_matcher_v3.StringMatcher_SafeRegex:
+		// the regex matches the whole string, and ignore_case does not apply to it
+		regex, err := regexp.Compile("^(?:" + pattern.SafeRegex.GetRegex() + ")$")
+		return err == nil && regex.MatchString(s)
+	default:
+		return false
+	}
+}
+
+// NarrowNodeMatchers combines the NodeMatchers of a request with the NodeMatchers of an incoming
+// request, so that a proxy only asks the control planes for the clients that the incoming request
+// may match. Each pair of matchers is combined into one with the node metadatas of both, and the
+// node id of the request if it has one, or else the node id of the incoming request. An exact node
+// id of the incoming request is also taken if the node id of the request matches it. The combined
+// matchers may match more clients than both, so the incoming NodeMatchers must still be applied to
+// the response. The matches on ControlPlaneKey are left out, since it is only added by the client to
+// the merged responses.
+func NarrowNodeMatchers(matchers []*envoy_type_matcher_v3.NodeMatcher, incoming []*envoy_type_matcher_v3.NodeMatcher) []*envoy_type_matcher_v3.NodeMatcher {
+	if len(incoming) == 0 {
+		return matchers
+	}
+	if len(matchers) == 0 {
+		matchers = []*envoy_type_matcher_v3.NodeMatcher{{}}
+	}
+	var narrowed []*envoy_type_matcher_v3.NodeMatcher
+	for _, matcher := range matchers {
+		for _, in := range incoming {
+			combined := &envoy_type_matcher_v3.NodeMatcher{NodeId: matcher.GetNodeId()}
+			if exact := in.GetNodeId().GetExact(); combined.NodeId == nil || (exact != "" && MatchString(combined.NodeId, exact)) {
+				combined.NodeId = in.GetNodeId()
+			}
+			combined.NodeMetadatas = append(combined.NodeMetadatas, matcher.GetNodeMetadatas()...)
+			for _, structMatcher := range in.GetNodeMetadatas() {
+				if path := structMatcher.GetPath(); len(path) > 0 && path[0].GetKey() == ControlPlaneKey {
+					continue
+				}
+				combined.NodeMetadatas = append(combined.NodeMetadatas, structMatcher)
+			}
+			narrowed = append(narrowed, combined)
+		}
+	}
+	return narrowed
+}
@@ -0,0 +1,122 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// TestMatchNode tests evaluating NodeMatchers on nodes
+func TestMatchNode(t *testing.T) {
+	node := &envoy_config_core_v3.Node{Id: "projects/123/nodes/fake_node_id"}
+	node.Metadata, _ = structpb.NewStruct(map[string]interface{}{
+		"TRAFFICDIRECTOR_NETWORK_NAME": "fake_network_name",
+		"ISTIO_VERSION":                1.9,
+		"LABELS":                       map[string]interface{}{"app": "fake_app"},
+		"INTERCEPTION":                 []interface{}{"inbound", "outbound"},
+	})
+	tests := []struct {
+		yaml string
+		want bool
+	}{
+		{`{"node_id": {"suffix": "/fake_node_id"}}`, true},
+		{`{"node_id": {"exact": "PROJECTS/123/NODES/FAKE_NODE_ID", "ignore_case": true}}`, true},
+		{`{"node_id": {"safe_regex": {"google_re2": {}, "regex": "projects/[0-9]+"}}}`, false},
+		{`{"node_metadatas": [{"path": [{"key": "TRAFFICDIRECTOR_NETWORK_NAME"}], "value": {"string_match": {"prefix": "fake_"}}}]}`, true},
+		{`{"node_metadatas": [{"path": [{"key": "LABELS"}, {"key": "app"}], "value": {"string_match": {"exact": "fake_app"}}}]}`, true},
+		{`{"node_metadatas": [{"path": [{"key": "ISTIO_VERSION"}], "value": {"double_match": {"range": {"start": 1.8, "end": 2}}}}]}`, true},
+		{`{"node_metadatas": [{"path": [{"key": "INTERCEPTION"}], "value": {"list_match": {"one_of": {"string_match": {"exact": "outbound"}}}}}]}`, true},
+		{`{"node_metadatas": [{"path": [{"key": "MISSING"}], "value": {"present_match": true}}]}`, false},
+		{`{"node_id": {"contains": "fake"}, "node_metadatas": [{"path": [{"key": "LABELS"}], "value": {"present_match": true}}, {"path": [{"key": "ISTIO_VERSION"}], "value": {"bool_match": true}}]}`, false},
+	}
+	for _, test := range tests {
+		matcher := &envoy_type_matcher_v3.NodeMatcher{}
+		if err := protojson.Unmarshal([]byte(test.yaml), matcher); err != nil {
+			t.Fatalf("Parse NodeMatcher %v error: %v", test.yaml, err)
+		}
+		if got := MatchNode([]*envoy_type_matcher_v3.NodeMatcher{matcher}, node); got != test.want {
+			t.Errorf("NodeMatcher %v: want %v, got %v", test.yaml, test.want, got)
+		}
+	}
+}
+
+// TestNarrowNodeMatchers tests combining the NodeMatchers of a request with the NodeMatchers of an
+// incoming request
+func TestNarrowNodeMatchers(t *testing.T) {
+	parse := func(js string) []*envoy_type_matcher_v3.NodeMatcher {
+		var matchers []*envoy_type_matcher_v3.NodeMatcher
+		for _, m := range strings.Split(js, ";") {
+			if m == "" {
+				continue
+			}
+			matcher := &envoy_type_matcher_v3.NodeMatcher{}
+			if err := protojson.Unmarshal([]byte(m), matcher); err != nil {
+				t.Fatalf("Parse NodeMatcher %v error: %v", m, err)
+			}
+			matchers = append(matchers, matcher)
+		}
+		return matchers
+	}
+	project := `{"path": [{"key": "PROJECT"}], "value": {"string_match": {"exact": "123"}}}`
+	zone := `{"path": [{"key": "ZONE"}], "value": {"string_match": {"exact": "a"}}}`
+	tag := `{"path": [{"key": "CSDS_CONTROL_PLANE"}], "value": {"string_match": {"exact": "localhost:1"}}}`
+	tests := []struct {
+		name     string
+		matchers string
+		incoming string
+		want     string
+	}{
+		{
+			name:     "no incoming matchers",
+			matchers: `{"node_metadatas": [` + project + `]}`,
+			want:     `{"node_metadatas": [` + project + `]}`,
+		},
+		{
+			name:     "no matchers",
+			incoming: `{"node_id": {"exact": "node_1"}}`,
+			want:     `{"node_id": {"exact": "node_1"}}`,
+		},
+		{
+			name:     "each pair of matchers",
+			matchers: `{"node_metadatas": [` + project + `]}`,
+			incoming: `{"node_id": {"exact": "node_1"}};{"node_metadatas": [` + zone + `]}`,
+			want:     `{"node_id": {"exact": "node_1"}, "node_metadatas": [` + project + `]};{"node_metadatas": [` + project + `, ` + zone + `]}`,
+		},
+		{
+			name:     "exact node id that the node id matches",
+			matchers: `{"node_id": {"prefix": "node_"}}`,
+			incoming: `{"node_id": {"exact": "node_1"}}`,
+			want:     `{"node_id": {"exact": "node_1"}}`,
+		},
+		{
+			name:     "node id that does not match",
+			matchers: `{"node_id": {"prefix": "other_"}}`,
+			incoming: `{"node_id": {"exact": "node_1"}};{"node_id": {"prefix": "node_"}}`,
+			want:     `{"node_id": {"prefix": "other_"}};{"node_id": {"prefix": "other_"}}`,
+		},
+		{
+			name:     "control plane tag",
+			matchers: `{"node_metadatas": [` + project + `]}`,
+			incoming: `{"node_metadatas": [` + tag + `, ` + zone + `]}`,
+			want:     `{"node_metadatas": [` + project + `, ` + zone + `]}`,
+		},
+	}
+	for _, test := range tests {
+		got := NarrowNodeMatchers(parse(test.matchers), parse(test.incoming))
+		want := parse(test.want)
+		if len(got) != len(want) {
+			t.Errorf("%v: NarrowNodeMatchers() = %v, want %v", test.name, got, want)
+			continue
+		}
+		for i := range got {
+			if !proto.Equal(got[i], want[i]) {
+				t.Errorf("%v: NarrowNodeMatchers()[%d] = %v, want %v", test.name, i, got[i], want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"encoding/json"
+	"envoy-tools/csds-client/client"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// PrintQueryResult runs the JSONPath query on each client config, with google.protobuf.Any types
+// resolved to the typed xDS resources, and prints out the clients that have any value selected by
+// the query together with the selected values
+func PrintQueryResult(configs []proto.Message, opts client.ClientOptions) error {
+	path, err := ParseJsonPath(opts.Query)
+	if err != nil {
+		return err
+	}
+	redactedFields := RedactedFields(opts)
+
+	m := protojson.MarshalOptions{Resolver: &TypeResolver{}}
+	var matched bool
+	for _, config := range configs {
+		if redactedFields != nil {
+			if config, err = Redact(config, redactedFields); err != nil {
+				return err
+			}
+		}
+		js, err := m.Marshal(config)
+		if err != nil {
+			return err
+		}
+		var doc interface{}
+		if err := json.Unmarshal(js, &doc); err != nil {
+			return err
+		}
+		results := path.Eval(doc)
+		if len(results) == 0 {
+			continue
+		}
+
+		if !matched {
+			fmt.Printf("%-50s %-30s \n", "Client ID", "Query Result")
+			matched = true
+		}
+		var id string
+		if node, ok := doc.(map[string]interface{})["node"].(map[string]interface{}); ok {
+			id, _ = node["id"].(string)
+		}
+		for i, result := range results {
+			out, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				fmt.Printf("%-50s %-30s \n", id, out)
+			} else {
+				fmt.Printf("%-50s %-30s \n", "", out)
+			}
+		}
+	}
+	if !matched {
+		fmt.Printf("No xDS clients matched the query.\n")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"envoy-tools/csds-client/client"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// TestQuery tests running JSONPath queries on the client configs
+func TestQuery(t *testing.T) {
+	var configs []proto.Message
+	for _, config := range ReadResponse(t, "./response_for_query.json").GetConfig() {
+		configs = append(configs, config)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{
+			query: "$..routes[?(@.route.retryPolicy.numRetries > 3)].name",
+			want: `Client ID                                          Query Result                   
+test_node_1                                        "test_route_0"                 
+`,
+		},
+		{
+			query: "$.genericXdsConfigs[0].xdsConfig.virtualHosts[*].routes[-1:]['name', 'match']",
+			want: `Client ID                                          Query Result                   
+test_node_1                                        "test_route_1"                 
+                                                   {"prefix":"/api"}              
+test_node_2                                        "test_route_1"                 
+                                                   {"prefix":"/api"}              
+`,
+		},
+		{
+			query: "$..[?(@.versionInfo =~ /version2$/ && !@.xdsConfig.virtualHosts[0].routes[0].route.retryPolicy)].name",
+			want: `Client ID                                          Query Result                   
+test_node_2                                        "test_rds_0"                   
+`,
+		},
+		{
+			query: "$..routes[?(@.route.cluster == 'test_cds_2')]",
+			want:  "No xDS clients matched the query.\n",
+		},
+	}
+	for _, test := range tests {
+		out := CaptureOutput(func() {
+			if err := PrintQueryResult(configs, client.ClientOptions{Query: test.query}); err != nil {
+				t.Errorf("Print query result error: %v", err)
+			}
+		})
+		if out != test.want {
+			t.Errorf("query %q: want\n%vout\n%v", test.query, test.want, out)
+		}
+	}
+}
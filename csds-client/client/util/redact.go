@@ -0,0 +1,199 @@
+package util
+
+import (
+	"envoy-tools/csds-client/client"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// DefaultRedactedFields are the fields that hold secrets in xDS resources. They are redacted from
+// the config dumps by default, in both v2 and v3 resources.
+var DefaultRedactedFields = []string{
+	"TlsCertificate.private_key",
+	"TlsCertificate.password",
+	"TlsCertificate.private_key_provider",
+	"TlsSessionTicketKeys.keys",
+	"GenericSecret.secret",
+}
+
+// redactedValue replaces the values of the redacted fields
+const redactedValue = "[redacted]"
+
+// RedactedFields returns the fields to redact according to the options, or nil if redaction is disabled
+func RedactedFields(opts client.ClientOptions) []string {
+	if opts.NoRedact {
+		return nil
+	}
+	return append(append([]string{}, DefaultRedactedFields...), opts.RedactFields...)
+}
+
+// Redact returns a copy of the message in which the fields are redacted, including the fields of
+// the google.protobuf.Any types that the TypeResolver resolves. A field is either a fully qualified
+// field name (e.g. envoy.config.route.v3.HeaderValue.value) or its suffix after a dot (e.g.
+// HeaderValue.value or value). String and bytes values are replaced by "[redacted]", and so are
+// all the string and bytes values in message fields.
+func Redact(m proto.Message, fields []string) (proto.Message, error) {
+	redacted := proto.Clone(m)
+	r := redactor{fields: fields, resolver: &TypeResolver{}}
+	if _, err := r.redact(redacted.ProtoReflect()); err != nil {
+		return nil, err
+	}
+	return redacted, nil
+}
+
+type redactor struct {
+	fields   []string
+	resolver *TypeResolver
+}
+
+// match checks if the field is one of the fields to redact
+func (r redactor) match(fd protoreflect.FieldDescriptor) bool {
+	name := string(fd.FullName())
+	for _, field := range r.fields {
+		if name == field || strings.HasSuffix(name, "."+field) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact redacts the matched fields in the message and reports whether the message is changed
+func (r redactor) redact(m protoreflect.Message) (bool, error) {
+	if m.Descriptor().FullName() == "google.protobuf.Any" {
+		return r.redactAny(m, false)
+	}
+
+	var changed bool
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		var fieldChanged bool
+		if r.match(fd) {
+			fieldChanged, err = r.redactField(m, fd, v)
+		} else {
+			fieldChanged, err = r.rangeMessages(fd, v, r.redact)
+		}
+		changed = changed || fieldChanged
+		return err == nil
+	})
+	return changed, err
+}
+
+// redactAll redacts all the string and bytes values in the message
+func (r redactor) redactAll(m protoreflect.Message) (bool, error) {
+	if m.Descriptor().FullName() == "google.protobuf.Any" {
+		return r.redactAny(m, true)
+	}
+	// a data source is replaced by an inline string, so that the redacted value is readable
+	if m.Descriptor().Name() == "DataSource" {
+		if fd := m.Descriptor().Fields().ByName("inline_string"); fd != nil {
+			m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+				m.Clear(fd)
+				return true
+			})
+			m.Set(fd, protoreflect.ValueOfString(redactedValue))
+			return true, nil
+		}
+	}
+
+	var changed bool
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		var fieldChanged bool
+		fieldChanged, err = r.redactField(m, fd, v)
+		changed = changed || fieldChanged
+		return err == nil
+	})
+	return changed, err
+}
+
+// redactField redacts the string and bytes values of a field
+func (r redactor) redactField(m protoreflect.Message, fd protoreflect.FieldDescriptor, v protoreflect.Value) (bool, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		redacted := protoreflect.ValueOfString(redactedValue)
+		if fd.Kind() == protoreflect.BytesKind {
+			redacted = protoreflect.ValueOfBytes([]byte(redactedValue))
+		}
+		switch {
+		case fd.IsList():
+			for i := 0; i < v.List().Len(); i++ {
+				v.List().Set(i, redacted)
+			}
+		case fd.IsMap():
+			v.Map().Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+				v.Map().Set(k, redacted)
+				return true
+			})
+		default:
+			m.Set(fd, redacted)
+		}
+		return true, nil
+	default:
+		return r.rangeMessages(fd, v, r.redactAll)
+	}
+}
+
+// rangeMessages calls f on the message values of a field
+func (r redactor) rangeMessages(fd protoreflect.FieldDescriptor, v protoreflect.Value, f func(protoreflect.Message) (bool, error)) (bool, error) {
+	var changed bool
+	var err error
+	visit := func(m protoreflect.Message) bool {
+		var messageChanged bool
+		messageChanged, err = f(m)
+		changed = changed || messageChanged
+		return err == nil
+	}
+	switch {
+	case fd.IsList() && fd.Message() != nil:
+		for i := 0; i < v.List().Len() && err == nil; i++ {
+			visit(v.List().Get(i).Message())
+		}
+	case fd.IsMap() && fd.MapValue().Message() != nil:
+		v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+			return visit(mv.Message())
+		})
+	case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+		visit(v.Message())
+	}
+	return changed, err
+}
+
+// redactAny unpacks a google.protobuf.Any of a known type, redacts it and packs it back if it
+// changed. If all is set, all the values of the message are redacted, and the value of an Any of an
+// unknown type is dropped.
+func (r redactor) redactAny(m protoreflect.Message, all bool) (bool, error) {
+	any, ok := m.Interface().(*anypb.Any)
+	if !ok {
+		return false, nil
+	}
+	mt, err := r.resolver.FindMessageByURL(any.GetTypeUrl())
+	if err != nil || mt.Descriptor().FullName() == "google.protobuf.Any" {
+		// the type of the message is unknown
+		if all && len(any.GetValue()) > 0 {
+			any.Value = nil
+			return true, nil
+		}
+		return false, nil
+	}
+	unpacked := mt.New()
+	if err := proto.Unmarshal(any.GetValue(), unpacked.Interface()); err != nil {
+		return false, err
+	}
+	f := r.redact
+	if all {
+		f = r.redactAll
+	}
+	changed, err := f(unpacked)
+	if err != nil || !changed {
+		return false, err
+	}
+	value, err := proto.Marshal(unpacked.Interface())
+	if err != nil {
+		return false, err
+	}
+	any.Value = value
+	return true, nil
+}
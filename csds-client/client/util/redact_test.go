@@ -0,0 +1,71 @@
+package util
+
+import (
+	"envoy-tools/csds-client/client"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestRedaction tests redacting secrets and sensitive fields in the detailed config
+func TestRedaction(t *testing.T) {
+	response := ReadResponse(t, "./response_for_redaction.json")
+
+	redactedKey := `"privateKey":{"inlineString":"[redacted]"},"password":{"inlineString":"[redacted]"}`
+	tests := []struct {
+		name    string
+		opts    client.ClientOptions
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "default",
+			opts:    client.ClientOptions{},
+			want:    []string{redactedKey, `"filename":"/etc/certs/cert.pem"`, `"value":"Bearer fake_token"`},
+			notWant: []string{"ZmFrZV9wcml2YXRlX2tleQ==", "fake_password"},
+		},
+		{
+			name:    "redact fields",
+			opts:    client.ClientOptions{RedactFields: []string{"HeaderValue.value"}},
+			want:    []string{redactedKey, `"key":"authorization","value":"[redacted]"`},
+			notWant: []string{"Bearer fake_token"},
+		},
+		{
+			name:    "no redact",
+			opts:    client.ClientOptions{NoRedact: true, RedactFields: []string{"HeaderValue.value"}},
+			want:    []string{`"inlineBytes":"ZmFrZV9wcml2YXRlX2tleQ=="`, `"value":"Bearer fake_token"`},
+			notWant: []string{"[redacted]"},
+		},
+	}
+	for _, test := range tests {
+		var redacted proto.Message = response
+		if fields := RedactedFields(test.opts); fields != nil {
+			var err error
+			if redacted, err = Redact(response, fields); err != nil {
+				t.Fatalf("%v: Redact error: %v", test.name, err)
+			}
+		}
+		outputjson, err := protojson.MarshalOptions{Resolver: &TypeResolver{}}.Marshal(redacted)
+		if err != nil {
+			t.Fatalf("%v: Marshal error: %v", test.name, err)
+		}
+		compact := strings.Join(strings.Fields(string(outputjson)), "")
+		for _, want := range test.want {
+			if !strings.Contains(compact, strings.Join(strings.Fields(want), "")) {
+				t.Errorf("%v: config should contain %v, got\n%v", test.name, want, compact)
+			}
+		}
+		for _, notWant := range test.notWant {
+			if strings.Contains(compact, strings.Join(strings.Fields(notWant), "")) {
+				t.Errorf("%v: config should not contain %v, got\n%v", test.name, notWant, compact)
+			}
+		}
+	}
+
+	// the response itself must not be changed by the redaction
+	if got := response.GetConfig()[0].GetGenericXdsConfigs()[1].GetXdsConfig(); !strings.Contains(string(got.GetValue()), "Bearer fake_token") {
+		t.Errorf("Redaction should not change the response")
+	}
+}
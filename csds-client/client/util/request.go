@@ -0,0 +1,536 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// RequestSchema is the schema of a request yaml, i.e. the messages of its top level fields
+type RequestSchema struct {
+	NodeMatcher protoreflect.MessageDescriptor
+	Node        protoreflect.MessageDescriptor
+}
+
+// RequestError is a problem at a line and column of a request yaml
+type RequestError struct {
+	// Source is the file of the request, or -request_yaml
+	Source  string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e RequestError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%v: %v", e.Source, e.Message)
+	}
+	return fmt.Sprintf("%v:%d:%d: %v", e.Source, e.Line, e.Column, e.Message)
+}
+
+// RequestErrors are all the problems found in a request yaml
+type RequestErrors []RequestError
+
+func (e RequestErrors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ReadRequestFile reads the request yaml file, validates it and parses it to a map
+func ReadRequestFile(path string, schema RequestSchema) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRequest(data, path, schema)
+}
+
+// ParseRequest renders the request yaml, or json, validates it and parses it to a map. The source is
+// the name of the request in the errors.
+func ParseRequest(data []byte, source string, schema RequestSchema) (map[string]interface{}, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return nil, RequestErrors{{Source: source, Message: strings.TrimPrefix(err.Error(), "yaml: ")}}
+	}
+	if err := RenderRequest(&doc, source); err != nil {
+		return nil, err
+	}
+	if err := ValidateRequest(&doc, source, schema); err != nil {
+		return nil, err
+	}
+	var request map[string]interface{}
+	if err := doc.Decode(&request); err != nil {
+		return nil, err
+	}
+	// the request goes through json, so that its values are the ones of encoding/json as for the
+	// json requests
+	js, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	request = nil
+	if err := json.Unmarshal(js, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// envVarPattern matches the ${VAR} references to the environment variables in a request
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// RenderRequest substitutes the environment variables and executes the Go template in each value
+// of the parsed request, so that one request file serves every environment, e.g.
+//
+//	node_matchers:
+//	- node_id:
+//	    prefix: projects/${GCP_PROJECT}/networks/{{ env "GCP_NETWORK" | default "default" }}/nodes/
+//
+// Only the values are rendered, so the comments are left as they are and the errors have the
+// position of the value in the request. A value that starts with {{ must be quoted to be a string
+// in yaml. A ${VAR} that is not set is an error. The template has the env and default functions,
+// and the environment variables as .Env, on which a missing key is an error.
+func RenderRequest(doc *yamlv3.Node, source string) error {
+	r := &requestRenderer{source: source, env: make(map[string]string)}
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i > 0 {
+			r.env[kv[:i]] = kv[i+1:]
+		}
+	}
+	r.render(doc)
+	if len(r.errs) > 0 {
+		return r.errs
+	}
+	return nil
+}
+
+// requestRenderer renders the values of a request yaml and collects the errors
+type requestRenderer struct {
+	source string
+	env    map[string]string
+	errs   RequestErrors
+}
+
+func (r *requestRenderer) errorf(n *yamlv3.Node, format string, a ...interface{}) {
+	r.errs = append(r.errs, RequestError{Source: r.source, Line: n.Line, Column: n.Column, Message: fmt.Sprintf(format, a...)})
+}
+
+// render renders the scalars of the node and of its content, the aliases are rendered with the
+// nodes that they refer to
+func (r *requestRenderer) render(n *yamlv3.Node) {
+	if n.Kind == yamlv3.MappingNode && n.Style&yamlv3.FlowStyle != 0 && len(n.Content) > 0 && n.Content[0].Kind == yamlv3.MappingNode && n.Content[0].Style&yamlv3.FlowStyle != 0 {
+		// {{ .Env.X }} that is not quoted is a mapping in a mapping in yaml
+		r.errorf(n, "a value that starts with {{ must be quoted")
+		return
+	}
+	if n.Kind != yamlv3.ScalarNode {
+		for _, child := range n.Content {
+			r.render(child)
+		}
+		return
+	}
+	if !strings.Contains(n.Value, "${") && !strings.Contains(n.Value, "{{") {
+		return
+	}
+
+	var missing []string
+	value := envVarPattern.ReplaceAllStringFunc(n.Value, func(ref string) string {
+		name := envVarPattern.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		r.errorf(n, "environment variables not set: %v", strings.Join(missing, ", "))
+		return
+	}
+	if strings.Contains(value, "{{") {
+		t, err := template.New(r.source).Option("missingkey=error").Funcs(template.FuncMap{
+			"env": os.Getenv,
+			"default": func(value string, s string) string {
+				if s == "" {
+					return value
+				}
+				return s
+			},
+		}).Parse(value)
+		if err != nil {
+			r.errorf(n, "%v", templateError(err, r.source))
+			return
+		}
+		var b bytes.Buffer
+		if err := t.Execute(&b, struct{ Env map[string]string }{r.env}); err != nil {
+			r.errorf(n, "%v", templateError(err, r.source))
+			return
+		}
+		value = b.String()
+	}
+	n.Value = value
+	if n.Style&(yamlv3.DoubleQuotedStyle|yamlv3.SingleQuotedStyle|yamlv3.LiteralStyle|yamlv3.FoldedStyle) == 0 {
+		// a plain value is resolved again, e.g. ${PORT} is a number once it is rendered
+		n.Tag = ""
+	}
+}
+
+// templateError returns the message of a template error without the name of the template, which is
+// the source that the position of the value is already reported with
+func templateError(err error, source string) string {
+	return strings.TrimPrefix(err.Error(), "template: "+source+":")
+}
+
+// SelectNodeMatchers returns the node matchers of the query with the name in the request, and if the
+// query is found. The queries are named in queries, e.g.
+//
+//	queries:
+//	  prod:
+//	    node_matchers: [...]
+//	  staging:
+//	    node_matchers: [...]
+//
+// Without a name, the node_matchers at the top level of the request are returned.
+func SelectNodeMatchers(request map[string]interface{}, name string) ([]interface{}, bool) {
+	if name == "" {
+		matchers, _ := request["node_matchers"].([]interface{})
+		return matchers, true
+	}
+	queries, _ := request["queries"].(map[string]interface{})
+	query, ok := queries[name]
+	q, _ := query.(map[string]interface{})
+	matchers, _ := q["node_matchers"].([]interface{})
+	return matchers, ok
+}
+
+// RequestQueries returns the names of the queries that are sent separately when no query is
+// selected by name, over the requests that are merged: "" for the node_matchers at the top level,
+// if a request has them or if no request has queries, then the names of the queries in order.
+func RequestQueries(requests ...map[string]interface{}) []string {
+	var names []string
+	topLevel := false
+	seen := make(map[string]bool)
+	for _, request := range requests {
+		if _, ok := request["node_matchers"]; ok {
+			topLevel = true
+		}
+		for _, name := range QueryNames(request) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	if topLevel || len(names) == 0 {
+		names = append([]string{""}, names...)
+	}
+	return names
+}
+
+// QueryLabel returns the name of a query in the output, with "" for the node_matchers at the top
+// level of the request
+func QueryLabel(name string) string {
+	if name == "" {
+		return "(top level)"
+	}
+	return name
+}
+
+// QueryNames returns the names of the queries of the request in order
+func QueryNames(request map[string]interface{}) []string {
+	queries, _ := request["queries"].(map[string]interface{})
+	var names []string
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// QueryNotFoundError returns the error of a -request_name that is not in the queries of the request
+func QueryNotFoundError(name string, names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("query %v not found, the request has no queries", name)
+	}
+	if suggestion := Suggest(name, names); suggestion != "" {
+		return fmt.Errorf("query %v not found, did you mean %v?", name, suggestion)
+	}
+	return fmt.Errorf("query %v not found, list of queries: %v", name, strings.Join(names, ", "))
+}
+
+// ValidateRequest checks the rendered request yaml against the schema. It returns the RequestErrors
+// with the position of each unknown field, value of the wrong type and unknown enum value, with a
+// suggestion for the misspelled names.
+func ValidateRequest(doc *yamlv3.Node, source string, schema RequestSchema) error {
+	v := &requestValidator{source: source}
+	if len(doc.Content) == 0 {
+		v.errorf(doc, "empty request, expected node_matchers")
+		return v.errs
+	}
+	root := resolveAlias(doc.Content[0])
+	if root.Kind != yamlv3.MappingNode {
+		v.errorf(root, "expected a mapping with node_matchers, got %v", kindName(root))
+		return v.errs
+	}
+	fields := []string{"node_matchers", "node", "queries"}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], resolveAlias(root.Content[i+1])
+		switch key.Value {
+		case "node_matchers":
+			v.validateNodeMatchers(value, schema.NodeMatcher, "node_matchers")
+		case "node":
+			v.validateMessage(value, schema.Node, "node")
+		case "queries":
+			v.validateQueries(value, schema.NodeMatcher)
+		default:
+			v.unknownField(key, "the request", fields)
+		}
+	}
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+// requestValidator collects the errors of a request yaml
+type requestValidator struct {
+	source string
+	errs   RequestErrors
+}
+
+func (v *requestValidator) errorf(n *yamlv3.Node, format string, a ...interface{}) {
+	v.errs = append(v.errs, RequestError{Source: v.source, Line: n.Line, Column: n.Column, Message: fmt.Sprintf(format, a...)})
+}
+
+// unknownField reports the key that is not a field, with the closest field if it is misspelled
+func (v *requestValidator) unknownField(key *yamlv3.Node, in string, fields []string) {
+	if suggestion := Suggest(key.Value, fields); suggestion != "" {
+		v.errorf(key, "unknown field %q in %v, did you mean %q?", key.Value, in, suggestion)
+		return
+	}
+	v.errorf(key, "unknown field %q in %v, list of fields: %v", key.Value, in, strings.Join(fields, ", "))
+}
+
+// validateNodeMatchers checks the yaml of a list of node matchers
+func (v *requestValidator) validateNodeMatchers(n *yamlv3.Node, md protoreflect.MessageDescriptor, path string) {
+	if isNull(n) {
+		return
+	}
+	if n.Kind != yamlv3.SequenceNode {
+		v.errorf(n, "%v: expected a list, got %v", path, kindName(n))
+		return
+	}
+	for i, item := range n.Content {
+		v.validateMessage(resolveAlias(item), md, path+"["+strconv.Itoa(i)+"]")
+	}
+}
+
+// validateQueries checks the yaml of the named queries, each of which has node matchers
+func (v *requestValidator) validateQueries(n *yamlv3.Node, md protoreflect.MessageDescriptor) {
+	if isNull(n) {
+		return
+	}
+	if n.Kind != yamlv3.MappingNode {
+		v.errorf(n, "queries: expected a mapping of the queries by name, got %v", kindName(n))
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		name, query := n.Content[i].Value, resolveAlias(n.Content[i+1])
+		path := "queries." + name
+		if isNull(query) {
+			continue
+		}
+		if query.Kind != yamlv3.MappingNode {
+			v.errorf(query, "%v: expected a mapping with node_matchers, got %v", path, kindName(query))
+			continue
+		}
+		for j := 0; j+1 < len(query.Content); j += 2 {
+			key, value := query.Content[j], resolveAlias(query.Content[j+1])
+			if key.Value != "node_matchers" {
+				v.unknownField(key, path, []string{"node_matchers"})
+				continue
+			}
+			v.validateNodeMatchers(value, md, path+".node_matchers")
+		}
+	}
+}
+
+// validateMessage checks the yaml of a message, the path is the field path of the message in the errors
+func (v *requestValidator) validateMessage(n *yamlv3.Node, md protoreflect.MessageDescriptor, path string) {
+	// the well-known types (e.g. Struct, Value, Duration, wrappers) have their own json mapping
+	if md == nil || isNull(n) || strings.HasPrefix(string(md.FullName()), "google.protobuf.") {
+		return
+	}
+	if n.Kind != yamlv3.MappingNode {
+		v.errorf(n, "%v: expected a mapping for %v, got %v", path, md.Name(), kindName(n))
+		return
+	}
+	fields := md.Fields()
+	var names []string
+	for i := 0; i < fields.Len(); i++ {
+		names = append(names, string(fields.Get(i).Name()))
+	}
+	oneofs := make(map[protoreflect.FullName]string)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], resolveAlias(n.Content[i+1])
+		fd := fields.ByName(protoreflect.Name(key.Value))
+		if fd == nil {
+			fd = fields.ByJSONName(key.Value)
+		}
+		if fd == nil {
+			v.unknownField(key, path+" ("+string(md.Name())+")", names)
+			continue
+		}
+		if oneof := fd.ContainingOneof(); oneof != nil && !isNull(value) {
+			if other, ok := oneofs[oneof.FullName()]; ok {
+				v.errorf(key, "%v: only one of %v and %v can be set", path, other, key.Value)
+			}
+			oneofs[oneof.FullName()] = key.Value
+		}
+		v.validateField(value, fd, path+"."+string(fd.Name()))
+	}
+}
+
+// validateField checks the yaml of a field, which is a list or a map if the field is repeated
+func (v *requestValidator) validateField(n *yamlv3.Node, fd protoreflect.FieldDescriptor, path string) {
+	switch {
+	case isNull(n):
+	case fd.IsMap():
+		if n.Kind != yamlv3.MappingNode {
+			v.errorf(n, "%v: expected a mapping, got %v", path, kindName(n))
+			return
+		}
+		for i := 1; i < len(n.Content); i += 2 {
+			v.validateValue(resolveAlias(n.Content[i]), fd.MapValue(), path+"."+n.Content[i-1].Value)
+		}
+	case fd.IsList():
+		if n.Kind != yamlv3.SequenceNode {
+			v.errorf(n, "%v: expected a list, got %v", path, kindName(n))
+			return
+		}
+		for i, item := range n.Content {
+			v.validateValue(resolveAlias(item), fd, path+"["+strconv.Itoa(i)+"]")
+		}
+	default:
+		v.validateValue(n, fd, path)
+	}
+}
+
+// validateValue checks the yaml of a single value of a field
+func (v *requestValidator) validateValue(n *yamlv3.Node, fd protoreflect.FieldDescriptor, path string) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		v.validateMessage(n, fd.Message(), path)
+	case protoreflect.EnumKind:
+		if n.Kind != yamlv3.ScalarNode {
+			v.errorf(n, "%v: expected an enum value, got %v", path, kindName(n))
+			return
+		}
+		if _, err := strconv.Atoi(n.Value); err == nil {
+			return
+		}
+		values := fd.Enum().Values()
+		if values.ByName(protoreflect.Name(n.Value)) != nil {
+			return
+		}
+		var names []string
+		for i := 0; i < values.Len(); i++ {
+			names = append(names, string(values.Get(i).Name()))
+		}
+		if suggestion := Suggest(n.Value, names); suggestion != "" {
+			v.errorf(n, "%v: unknown value %q, did you mean %q?", path, n.Value, suggestion)
+		} else {
+			v.errorf(n, "%v: unknown value %q, list of values: %v", path, n.Value, strings.Join(names, ", "))
+		}
+	default:
+		if n.Kind != yamlv3.ScalarNode {
+			v.errorf(n, "%v: expected a %v, got %v", path, fd.Kind(), kindName(n))
+		}
+	}
+}
+
+// resolveAlias returns the node that an alias refers to
+func resolveAlias(n *yamlv3.Node) *yamlv3.Node {
+	for n.Kind == yamlv3.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+// isNull checks if the node is an empty value, which leaves the field unset
+func isNull(n *yamlv3.Node) bool {
+	return n.Kind == yamlv3.ScalarNode && n.ShortTag() == "!!null"
+}
+
+// kindName returns the name of the kind of a node in the errors
+func kindName(n *yamlv3.Node) string {
+	switch n.Kind {
+	case yamlv3.MappingNode:
+		return "a mapping"
+	case yamlv3.SequenceNode:
+		return "a list"
+	default:
+		return fmt.Sprintf("%q", n.Value)
+	}
+}
+
+// Suggest returns the candidate that is the closest to the name by edit distance, if it is close
+// enough to be a misspelling of the name, or "" otherwise
+func Suggest(name string, candidates []string) string {
+	best, bestDistance := "", 0
+	for _, candidate := range candidates {
+		d := levenshtein(strings.ToLower(name), strings.ToLower(candidate))
+		if best == "" || d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	// a misspelling differs by a couple of characters, or by a third of a long name
+	if best == "" || bestDistance > 2 && bestDistance > len(name)/3 {
+		return ""
+	}
+	return best
+}
+
+// levenshtein returns the edit distance between the strings
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,229 @@
+package util
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+)
+
+var testRequestSchema = RequestSchema{
+	NodeMatcher: (&envoy_type_matcher_v3.NodeMatcher{}).ProtoReflect().Descriptor(),
+	Node:        (&envoy_config_core_v3.Node{}).ProtoReflect().Descriptor(),
+}
+
+// TestParseRequest tests rendering and parsing the valid requests
+func TestParseRequest(t *testing.T) {
+	os.Setenv("CSDS_TEST_PROJECT", "fake_project_number")
+	os.Setenv("CSDS_TEST_PORT", "8080")
+	defer os.Unsetenv("CSDS_TEST_PROJECT")
+	defer os.Unsetenv("CSDS_TEST_PORT")
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{
+			name: "json",
+			yaml: `{"node_matchers": [{"node_id": {"exact": "fake_node_id"}}]}`,
+			want: `{"node_matchers":[{"node_id":{"exact":"fake_node_id"}}]}`,
+		},
+		{
+			name: "json names and aliases",
+			yaml: "node_matchers:\n- nodeId: &id\n    exact: fake_node_id\n- node_id: *id",
+			want: `{"node_matchers":[{"nodeId":{"exact":"fake_node_id"}},{"node_id":{"exact":"fake_node_id"}}]}`,
+		},
+		{
+			name: "environment variables",
+			yaml: "node_matchers:\n- node_id:\n    prefix: projects/${CSDS_TEST_PROJECT}/nodes/\n# ${CSDS_TEST_UNSET} is not rendered in a comment\nnode:\n  id: '${CSDS_TEST_PORT}'\n  cluster: ${CSDS_TEST_PORT}",
+			want: `{"node":{"cluster":8080,"id":"8080"},"node_matchers":[{"node_id":{"prefix":"projects/fake_project_number/nodes/"}}]}`,
+		},
+		{
+			name: "templates",
+			yaml: "node_matchers:\n- node_id:\n    exact: '{{ .Env.CSDS_TEST_PROJECT }}-{{ env \"CSDS_TEST_UNSET\" | default \"default\" }}'",
+			want: `{"node_matchers":[{"node_id":{"exact":"fake_project_number-default"}}]}`,
+		},
+		{
+			name: "queries",
+			yaml: "queries:\n  prod:\n    node_matchers:\n    - node_id:\n        exact: prod\n  empty:",
+			want: `{"queries":{"empty":null,"prod":{"node_matchers":[{"node_id":{"exact":"prod"}}]}}}`,
+		},
+		{
+			name: "well-known types",
+			yaml: "node:\n  metadata:\n    LABELS: {app: [a, b]}\nnode_matchers:\n- node_metadatas:\n  - path: [{key: LABELS}]\n    value: {present_match: true}\n- node_id: {safe_regex: {regex: a.*}}",
+			want: `{"node":{"metadata":{"LABELS":{"app":["a","b"]}}},"node_matchers":[{"node_metadatas":[{"path":[{"key":"LABELS"}],"value":{"present_match":true}}]},{"node_id":{"safe_regex":{"regex":"a.*"}}}]}`,
+		},
+	}
+	for _, test := range tests {
+		request, err := ParseRequest([]byte(test.yaml), "-request_yaml", testRequestSchema)
+		if err != nil {
+			t.Errorf("%v: ParseRequest() error: %v", test.name, err)
+			continue
+		}
+		got, _ := json.Marshal(request)
+		if string(got) != test.want {
+			t.Errorf("%v: ParseRequest() = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+// TestParseRequestError tests that the problems of the requests are reported with their positions
+func TestParseRequestError(t *testing.T) {
+	os.Setenv("CSDS_TEST_PROJECT", "fake_project_number")
+	defer os.Unsetenv("CSDS_TEST_PROJECT")
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{
+			name: "unknown fields and wrong types",
+			yaml: "node_matcher:\n- node_id:\n    exct: fake_node_id\nnode_matchers:\n- node_id:\n    exct: fake_node_id\n  node_metadatas:\n  - path: TRAFFICDIRECTOR_NETWORK_NAME\nnode:\n  locality:\n    zone: [us-east1-b]",
+			want: `-request_yaml:1:1: unknown field "node_matcher" in the request, did you mean "node_matchers"?
+-request_yaml:6:5: unknown field "exct" in node_matchers[0].node_id (StringMatcher), did you mean "exact"?
+-request_yaml:8:11: node_matchers[0].node_metadatas[0].path: expected a list, got "TRAFFICDIRECTOR_NETWORK_NAME"
+-request_yaml:11:11: node.locality.zone: expected a string, got a list`,
+		},
+		{
+			name: "unknown field without suggestion",
+			yaml: "matchers: []",
+			want: `-request_yaml:1:1: unknown field "matchers" in the request, list of fields: node_matchers, node, queries`,
+		},
+		{
+			name: "empty request",
+			yaml: "",
+			want: "-request_yaml: empty request, expected node_matchers",
+		},
+		{
+			name: "not a mapping",
+			yaml: "- node_id: {exact: a}",
+			want: "-request_yaml:1:1: expected a mapping with node_matchers, got a list",
+		},
+		{
+			name: "invalid yaml",
+			yaml: "node_matchers: [",
+			want: "-request_yaml: line 1: did not find expected node content",
+		},
+		{
+			name: "message that is not a mapping",
+			yaml: "node_matchers:\n- node_id: fake_node_id",
+			want: `-request_yaml:2:12: node_matchers[0].node_id: expected a mapping for StringMatcher, got "fake_node_id"`,
+		},
+		{
+			name: "oneof set twice",
+			yaml: "node_matchers:\n- node_id: {exact: a, prefix: b}",
+			want: "-request_yaml:2:23: node_matchers[0].node_id: only one of exact and prefix can be set",
+		},
+		{
+			name: "queries",
+			yaml: "queries:\n  prod:\n    node_matcher: []\n  staging: [a]\n  dev:\n    node_matchers: {}",
+			want: `-request_yaml:3:5: unknown field "node_matcher" in queries.prod, did you mean "node_matchers"?
+-request_yaml:4:12: queries.staging: expected a mapping with node_matchers, got a list
+-request_yaml:6:20: queries.dev.node_matchers: expected a list, got a mapping`,
+		},
+		{
+			name: "queries that are not a mapping",
+			yaml: "queries: [prod]",
+			want: "-request_yaml:1:10: queries: expected a mapping of the queries by name, got a list",
+		},
+		{
+			name: "environment variables not set",
+			yaml: "node_matchers:\n- node_id:\n    exact: ${CSDS_TEST_UNSET}/${CSDS_TEST_PROJECT}/${CSDS_TEST_UNSET_2}",
+			want: "-request_yaml:3:12: environment variables not set: CSDS_TEST_UNSET, CSDS_TEST_UNSET_2",
+		},
+		{
+			name: "unquoted template",
+			yaml: "node_matchers:\n- node_id:\n    exact: {{ .Env.CSDS_TEST_PROJECT }}",
+			want: "-request_yaml:3:12: a value that starts with {{ must be quoted",
+		},
+		{
+			name: "template syntax",
+			yaml: "node_matchers:\n- node_id:\n    exact: '{{ .Env.CSDS_TEST_PROJECT '",
+			want: "-request_yaml:3:12: 1: unclosed action",
+		},
+		{
+			name: "template missing key",
+			yaml: "node_matchers:\n- node_id:\n    exact: '{{ .Env.CSDS_TEST_UNSET }}'",
+			want: `-request_yaml:3:12: 1:7: executing "-request_yaml" at <.Env.CSDS_TEST_UNSET>: map has no entry for key "CSDS_TEST_UNSET"`,
+		},
+	}
+	for _, test := range tests {
+		_, err := ParseRequest([]byte(test.yaml), "-request_yaml", testRequestSchema)
+		if err == nil {
+			t.Errorf("%v: ParseRequest() should fail with %v", test.name, test.want)
+			continue
+		}
+		if err.Error() != test.want {
+			t.Errorf("%v: ParseRequest() error = \n%v\n, want: \n%v", test.name, err, test.want)
+		}
+	}
+}
+
+// TestRequestQueries tests selecting the node matchers of the queries of the merged requests
+func TestRequestQueries(t *testing.T) {
+	parse := func(yaml string) map[string]interface{} {
+		request, err := ParseRequest([]byte(yaml), "-request_yaml", testRequestSchema)
+		if err != nil {
+			t.Fatalf("ParseRequest() error: %v", err)
+		}
+		return request
+	}
+	topLevel := parse("node_matchers:\n- node_id: {exact: a}")
+	queries := parse("queries:\n  staging:\n    node_matchers: [{node_id: {exact: b}}]\n  prod:\n    node_matchers: [{node_id: {exact: c}}]")
+
+	for _, test := range []struct {
+		requests []map[string]interface{}
+		want     []string
+	}{
+		{[]map[string]interface{}{topLevel}, []string{""}},
+		{[]map[string]interface{}{queries}, []string{"prod", "staging"}},
+		{[]map[string]interface{}{queries, topLevel}, []string{"", "prod", "staging"}},
+		{[]map[string]interface{}{{}}, []string{""}},
+	} {
+		if got := RequestQueries(test.requests...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("RequestQueries() = %q, want %q", got, test.want)
+		}
+	}
+
+	if matchers, ok := SelectNodeMatchers(queries, "prod"); !ok || len(matchers) != 1 {
+		t.Errorf("SelectNodeMatchers(prod) = %v, %v, want the node matchers of prod", matchers, ok)
+	}
+	if matchers, ok := SelectNodeMatchers(queries, ""); !ok || len(matchers) != 0 {
+		t.Errorf("SelectNodeMatchers() = %v, %v, want no node matchers at the top level", matchers, ok)
+	}
+	if _, ok := SelectNodeMatchers(queries, "dev"); ok {
+		t.Errorf("SelectNodeMatchers(dev) should not find the query")
+	}
+	if label := QueryLabel(""); label != "(top level)" {
+		t.Errorf("QueryLabel() = %v, want (top level)", label)
+	}
+	for _, test := range []struct {
+		name  string
+		names []string
+		want  string
+	}{
+		{"prd", QueryNames(queries), "query prd not found, did you mean prod?"},
+		{"dev", QueryNames(queries), "query dev not found, list of queries: prod, staging"},
+		{"dev", QueryNames(topLevel), "query dev not found, the request has no queries"},
+	} {
+		if err := QueryNotFoundError(test.name, test.names); err.Error() != test.want {
+			t.Errorf("QueryNotFoundError(%v) = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+// TestSuggest tests suggesting the closest name to a misspelled one
+func TestSuggest(t *testing.T) {
+	if suggestion := Suggest("validate-requset", []string{"get", "verify", "validate-request"}); suggestion != "validate-request" {
+		t.Errorf("Suggestion = %v, want validate-request", suggestion)
+	}
+	if suggestion := Suggest("foo", []string{"get", "verify"}); suggestion != "" {
+		t.Errorf("Suggestion = %v, want none", suggestion)
+	}
+	if suggestion := Suggest("NODE_ID", []string{"node_id", "node_metadatas"}); suggestion != "node_id" {
+		t.Errorf("Suggestion = %v, want node_id", suggestion)
+	}
+}
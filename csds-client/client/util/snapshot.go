@@ -0,0 +1,70 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Snapshot is a response fetched once from the control planes, with the api version independent
+// view of its clients. It is what the commands that do not monitor work on.
+type Snapshot struct {
+	// Response is the ClientStatusResponse of the api version, with only the selected clients
+	Response proto.Message
+	Clients  []ClientConfig
+}
+
+// FindClient returns the client with the node id, or the only client whose id contains it
+func FindClient(clients []ClientConfig, node string) (ClientConfig, error) {
+	var matched []ClientConfig
+	for _, c := range clients {
+		if c.Id == node {
+			return c, nil
+		}
+		if strings.Contains(c.Id, node) {
+			matched = append(matched, c)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return ClientConfig{}, fmt.Errorf("node %v not found", node)
+	case 1:
+		return matched[0], nil
+	}
+	var ids []string
+	for _, c := range matched {
+		ids = append(ids, c.Id)
+	}
+	sort.Strings(ids)
+	return ClientConfig{}, fmt.Errorf("node %v is ambiguous, matching nodes: %v", node, strings.Join(ids, ", "))
+}
+
+// PrintGraph prints out the graph of the xDS resources in the response in dot, or saves it to the
+// output file if there is one
+func PrintGraph(response proto.Message, outputFile string) error {
+	js, err := protojson.MarshalOptions{Resolver: &TypeResolver{}}.Marshal(response)
+	if err != nil {
+		return err
+	}
+	graphData, err := ParseXdsRelationship(js)
+	if err != nil {
+		return err
+	}
+	dot, err := GenerateGraph(graphData)
+	if err != nil {
+		return err
+	}
+	if outputFile == "" {
+		fmt.Println(dot)
+		return nil
+	}
+	if err := ioutil.WriteFile(outputFile, []byte(dot), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Config graph has been saved to %v\n", outputFile)
+	return nil
+}
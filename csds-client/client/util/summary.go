@@ -0,0 +1,159 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Summary aggregates the clients in a CSDS response at the fleet level
+type Summary struct {
+	Clients int `json:"clients"`
+	// Statuses counts the clients per xDS type and config status
+	Statuses map[string]map[string]int `json:"statuses"`
+	// StreamTypes counts the clients per xDS stream type
+	StreamTypes map[string]int `json:"streamTypes"`
+	// Metadata counts the clients per value of the grouped node metadata keys
+	Metadata map[string]map[string]int `json:"metadata,omitempty"`
+	// Versions counts the clients per version_info of each resource, keyed by xDS type and resource name
+	Versions map[string]map[string]map[string]int `json:"versions"`
+}
+
+// BuildSummary aggregates the clients, grouping them by the values of the metadata keys
+func BuildSummary(configs []ClientConfig, metadataKeys []string) Summary {
+	s := Summary{
+		Clients:     len(configs),
+		Statuses:    make(map[string]map[string]int),
+		StreamTypes: make(map[string]int),
+		Versions:    make(map[string]map[string]map[string]int),
+	}
+	if len(metadataKeys) > 0 {
+		s.Metadata = make(map[string]map[string]int)
+		for _, key := range metadataKeys {
+			s.Metadata[key] = make(map[string]int)
+		}
+	}
+
+	for _, config := range configs {
+		for _, status := range config.Statuses {
+			if s.Statuses[status.Xds] == nil {
+				s.Statuses[status.Xds] = make(map[string]int)
+			}
+			s.Statuses[status.Xds][status.Status]++
+		}
+
+		streamType := config.StreamType
+		if streamType == "" {
+			streamType = "N/A"
+		}
+		s.StreamTypes[streamType]++
+
+		for _, key := range metadataKeys {
+			value := "N/A"
+			if v, ok := config.Metadata[key]; ok {
+				value = fmt.Sprint(v)
+			}
+			s.Metadata[key][value]++
+		}
+
+		// a client is counted once per resource even if the resource is reported more than once
+		counted := make(map[string]bool)
+		for _, resource := range config.Resources {
+			if resource.Name == "" || counted[resource.Xds+"/"+resource.Name] {
+				continue
+			}
+			counted[resource.Xds+"/"+resource.Name] = true
+			if s.Versions[resource.Xds] == nil {
+				s.Versions[resource.Xds] = make(map[string]map[string]int)
+			}
+			if s.Versions[resource.Xds][resource.Name] == nil {
+				s.Versions[resource.Xds][resource.Name] = make(map[string]int)
+			}
+			s.Versions[resource.Xds][resource.Name][resource.Version]++
+		}
+	}
+	return s
+}
+
+// PrintSummary prints out the fleet level summary
+func PrintSummary(s Summary) {
+	fmt.Printf("Total clients: %d\n", s.Clients)
+	if s.Clients == 0 {
+		return
+	}
+
+	fmt.Printf("\n%-10s %-20s %-10s \n", "xDS", "Config Status", "Clients")
+	for _, xds := range sortedMapKeys(s.Statuses) {
+		for _, status := range sortedKeys(s.Statuses[xds]) {
+			fmt.Printf("%-10s %-20s %-10d \n", xds, status, s.Statuses[xds][status])
+		}
+	}
+
+	fmt.Printf("\n%-30s %-10s \n", "xDS stream type", "Clients")
+	for _, streamType := range sortedKeys(s.StreamTypes) {
+		fmt.Printf("%-30s %-10d \n", streamType, s.StreamTypes[streamType])
+	}
+
+	for _, key := range sortedMapKeys(s.Metadata) {
+		fmt.Printf("\n%-50s %-10s \n", key, "Clients")
+		for _, value := range sortedKeys(s.Metadata[key]) {
+			fmt.Printf("%-50s %-10d \n", value, s.Metadata[key][value])
+		}
+	}
+
+	if len(s.Versions) == 0 {
+		return
+	}
+	fmt.Printf("\n%-10s %-50s %-30s %-10s \n", "xDS", "Resource", "Version", "Clients")
+	var xdsTypes []string
+	for xds := range s.Versions {
+		xdsTypes = append(xdsTypes, xds)
+	}
+	sort.Strings(xdsTypes)
+	for _, xds := range xdsTypes {
+		for _, name := range sortedMapKeys(s.Versions[xds]) {
+			versions := s.Versions[xds][name]
+			total := 0
+			for _, count := range versions {
+				total += count
+			}
+			for i, version := range sortedKeys(versions) {
+				clients := fmt.Sprintf("%d (%.1f%%)", versions[version], float64(versions[version])*100/float64(total))
+				if i == 0 {
+					fmt.Printf("%-10s %-50s %-30s %-10s \n", xds, name, version, clients)
+				} else {
+					fmt.Printf("%-10s %-50s %-30s %-10s \n", "", "", version, clients)
+				}
+			}
+		}
+	}
+
+	var skewed int
+	for _, resources := range s.Versions {
+		for _, versions := range resources {
+			if len(versions) > 1 {
+				skewed++
+			}
+		}
+	}
+	fmt.Printf("\nResources with version skew: %d\n", skewed)
+}
+
+// sortedKeys returns the keys of a map of counts in order
+func sortedKeys(m map[string]int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedMapKeys returns the keys of a map of counts by key in order
+func sortedMapKeys(m map[string]map[string]int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestPrintSummary tests that the summary is printed out in the order of its keys
+func TestPrintSummary(t *testing.T) {
+	s := Summary{
+		Clients:     3,
+		Statuses:    map[string]map[string]int{"RDS": {"SYNCED": 3}, "LDS": {"STALE": 1, "NACKED": 2}},
+		StreamTypes: map[string]int{"xDS full state": 2, "ADS": 1},
+		Metadata:    map[string]map[string]int{"zone": {"us-east1-b": 1, "us-central1-a": 2}},
+		Versions: map[string]map[string]map[string]int{
+			"RDS": {"route": {"1": 3}},
+			"LDS": {"listener_b": {"2": 1, "1": 2}, "listener_a": {"1": 3}},
+		},
+	}
+	out := CaptureOutput(func() {
+		PrintSummary(s)
+	})
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines = append(lines, strings.Join(fields, " "))
+		}
+	}
+	want := []string{
+		"LDS NACKED 2",
+		"LDS STALE 1",
+		"RDS SYNCED 3",
+		"ADS 1",
+		"xDS full state 2",
+		"us-central1-a 2",
+		"us-east1-b 1",
+		"LDS listener_a 1 3 (100.0%)",
+		"LDS listener_b 1 2 (66.7%)",
+		"2 1 (33.3%)",
+		"RDS route 1 3 (100.0%)",
+		"Resources with version skew: 1",
+	}
+	i := 0
+	for _, line := range lines {
+		if i < len(want) && line == want[i] {
+			i++
+		}
+	}
+	if i < len(want) {
+		t.Errorf("PrintSummary() is missing %q in order, got:\n%v", want[i], out)
+	}
+}
+
+// TestSummary tests aggregating the clients in the response to a fleet-wide summary
+func TestSummary(t *testing.T) {
+	configs := ReadClientConfigs(t, "./response_for_summary.json")
+	out := CaptureOutput(func() {
+		PrintSummary(BuildSummary(configs, []string{"TRAFFICDIRECTOR_NETWORK_NAME"}))
+	})
+	want := `Total clients: 3
+
+xDS        Config Status        Clients    
+CDS        STALE                1          
+CDS        SYNCED               2          
+LDS        SYNCED               2          
+
+xDS stream type                Clients    
+ADS                            2          
+xDS                            1          
+
+TRAFFICDIRECTOR_NETWORK_NAME                       Clients    
+fake_network_name                                  2          
+other_network_name                                 1          
+
+xDS        Resource                                           Version                        Clients    
+CDS        fake_cluster                                       fake_cluster_version1          1 (33.3%)  
+                                                              fake_cluster_version2          2 (66.7%)  
+LDS        fake_listener                                      fake_listener_version1         2 (100.0%) 
+
+Resources with version skew: 1
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
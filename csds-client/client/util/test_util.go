@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"reflect"
 	"sync"
 	"testing"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 // CaptureOutput captures the stdout for testing.
@@ -70,3 +74,46 @@ func EqualJSONBytes(s1, s2 []byte) (bool, error) {
 
 	return reflect.DeepEqual(o1, o2), nil
 }
+
+// ReadResponse reads a v3 CSDS response in json for testing
+func ReadResponse(t *testing.T, path string) *csdspb_v3.ClientStatusResponse {
+	t.Helper()
+	responsejson, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err = protojson.Unmarshal(responsejson, response); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	return response
+}
+
+// ReadClientConfigs reads the clients of a v3 CSDS response in json for testing, with their generic
+// xDS configs as the resources as in client/v3
+func ReadClientConfigs(t *testing.T, path string) []ClientConfig {
+	t.Helper()
+	var configs []ClientConfig
+	for _, config := range ReadResponse(t, path).GetConfig() {
+		c := NewClientConfig(config.GetNode())
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			xds := XdsName(genericXdsConfig.GetTypeUrl())
+			if xds == "" {
+				xds = genericXdsConfig.GetTypeUrl()
+			}
+			status := genericXdsConfig.GetConfigStatus().String()
+			c.AddStatus(xds, status)
+			c.Resources = append(c.Resources, Resource{
+				Xds:          xds,
+				Name:         genericXdsConfig.GetName(),
+				Version:      genericXdsConfig.GetVersionInfo(),
+				Status:       status,
+				ClientStatus: genericXdsConfig.GetClientStatus().String(),
+				Config:       genericXdsConfig.GetXdsConfig(),
+				Error:        genericXdsConfig.GetErrorState().GetDetails(),
+			})
+		}
+		configs = append(configs, c)
+	}
+	return configs
+}
@@ -0,0 +1,158 @@
+package util
+
+import (
+	envoy_api_v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoy_config_filter_http_cors_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/cors/v2"
+	envoy_config_filter_http_fault_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/fault/v2"
+	envoy_config_filter_http_router_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/router/v2"
+	envoy_config_filter_network_http_connection_manager_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	envoy_config_filter_network_tcp_proxy_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
+	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// the v2 type urls of the resources whose deprecated fields are carried over to v3
+const (
+	v2ClusterTypeUrl  = "type.googleapis.com/envoy.api.v2.Cluster"
+	v2ListenerTypeUrl = "type.googleapis.com/envoy.api.v2.Listener"
+)
+
+// upgradeV2Cluster carries over the deprecated fields of a v2 cluster that are reserved in v3, and
+// so are dropped by Upgrade: tls_context becomes the tls transport socket, and hosts the endpoints
+// of the load assignment, as Envoy does
+func upgradeV2Cluster(value []byte, cluster *envoy_config_cluster_v3.Cluster) error {
+	v2 := &envoy_api_v2.Cluster{}
+	if err := proto.Unmarshal(value, v2); err != nil {
+		return err
+	}
+	if tls := v2.GetTlsContext(); tls != nil && cluster.GetTransportSocket() == nil {
+		config, err := anypb.New(tls)
+		if err != nil {
+			return err
+		}
+		cluster.TransportSocket = &envoy_config_core_v3.TransportSocket{
+			Name:       "envoy.transport_sockets.tls",
+			ConfigType: &envoy_config_core_v3.TransportSocket_TypedConfig{TypedConfig: config},
+		}
+	}
+	if hosts := v2.GetHosts(); len(hosts) > 0 && cluster.GetLoadAssignment() == nil {
+		locality := &envoy_config_endpoint_v3.LocalityLbEndpoints{}
+		for _, host := range hosts {
+			address := &envoy_config_core_v3.Address{}
+			if err := Upgrade(host, address); err != nil {
+				return err
+			}
+			locality.LbEndpoints = append(locality.LbEndpoints, &envoy_config_endpoint_v3.LbEndpoint{
+				HostIdentifier: &envoy_config_endpoint_v3.LbEndpoint_Endpoint{
+					Endpoint: &envoy_config_endpoint_v3.Endpoint{Address: address},
+				},
+			})
+		}
+		cluster.LoadAssignment = &envoy_config_endpoint_v3.ClusterLoadAssignment{
+			ClusterName: cluster.GetName(),
+			Endpoints:   []*envoy_config_endpoint_v3.LocalityLbEndpoints{locality},
+		}
+	}
+	return nil
+}
+
+// upgradeV2Listener carries over the deprecated fields of the filter chains of a v2 listener that are
+// reserved in v3: tls_context becomes the tls transport socket, and the config structs of the
+// filters their typed configs
+func upgradeV2Listener(value []byte, listener *envoy_config_listener_v3.Listener) error {
+	v2 := &envoy_api_v2.Listener{}
+	if err := proto.Unmarshal(value, v2); err != nil {
+		return err
+	}
+	for i, v2Chain := range v2.GetFilterChains() {
+		chain := listener.GetFilterChains()[i]
+		if tls := v2Chain.GetTlsContext(); tls != nil && chain.GetTransportSocket() == nil {
+			config, err := anypb.New(tls)
+			if err != nil {
+				return err
+			}
+			chain.TransportSocket = &envoy_config_core_v3.TransportSocket{
+				Name:       "envoy.transport_sockets.tls",
+				ConfigType: &envoy_config_core_v3.TransportSocket_TypedConfig{TypedConfig: config},
+			}
+		}
+		for j, v2Filter := range v2Chain.GetFilters() {
+			if v2Filter.GetConfig() == nil {
+				continue
+			}
+			config, err := upgradeV2NetworkFilterConfig(v2Filter.GetName(), v2Filter.GetConfig())
+			if err != nil {
+				return err
+			}
+			chain.GetFilters()[j].ConfigType = &envoy_config_listener_v3.Filter_TypedConfig{TypedConfig: config}
+		}
+	}
+	return nil
+}
+
+// upgradeV2NetworkFilterConfig converts the config struct of a v2 network filter to the typed config
+// of the filter by its well known name, along with the config structs of the HTTP filters of an
+// HTTP connection manager. The struct of an unknown filter is kept as is.
+func upgradeV2NetworkFilterConfig(name string, config *structpb.Struct) (*anypb.Any, error) {
+	switch name {
+	case "envoy.http_connection_manager", "envoy.filters.network.http_connection_manager":
+		hcm := &envoy_config_filter_network_http_connection_manager_v2.HttpConnectionManager{}
+		if err := unmarshalStruct(config, hcm); err != nil {
+			return nil, err
+		}
+		for _, filter := range hcm.GetHttpFilters() {
+			if filter.GetConfig() == nil {
+				continue
+			}
+			typedConfig, err := upgradeV2HttpFilterConfig(filter.GetName(), filter.GetConfig())
+			if err != nil {
+				return nil, err
+			}
+			filter.ConfigType = &envoy_config_filter_network_http_connection_manager_v2.HttpFilter_TypedConfig{TypedConfig: typedConfig}
+		}
+		return anypb.New(hcm)
+	case "envoy.tcp_proxy", "envoy.filters.network.tcp_proxy":
+		tcpProxy := &envoy_config_filter_network_tcp_proxy_v2.TcpProxy{}
+		if err := unmarshalStruct(config, tcpProxy); err != nil {
+			return nil, err
+		}
+		return anypb.New(tcpProxy)
+	default:
+		return anypb.New(config)
+	}
+}
+
+// upgradeV2HttpFilterConfig converts the config struct of a v2 HTTP filter to the typed config of the
+// filter by its well known name. The struct of an unknown filter is kept as is.
+func upgradeV2HttpFilterConfig(name string, config *structpb.Struct) (*anypb.Any, error) {
+	var m proto.Message
+	switch name {
+	case "envoy.router", "envoy.filters.http.router":
+		m = &envoy_config_filter_http_router_v2.Router{}
+	case "envoy.fault", "envoy.filters.http.fault":
+		m = &envoy_config_filter_http_fault_v2.HTTPFault{}
+	case "envoy.cors", "envoy.filters.http.cors":
+		m = &envoy_config_filter_http_cors_v2.Cors{}
+	default:
+		return anypb.New(config)
+	}
+	if err := unmarshalStruct(config, m); err != nil {
+		return nil, err
+	}
+	return anypb.New(m)
+}
+
+// unmarshalStruct unmarshals the json of a config struct to the message
+func unmarshalStruct(config *structpb.Struct, m proto.Message) error {
+	data, err := protojson.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return protojson.UnmarshalOptions{Resolver: &TypeResolver{}}.Unmarshal(data, m)
+}
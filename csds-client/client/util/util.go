@@ -21,6 +21,7 @@ import (
 	"github.com/awalterschulze/gographviz"
 	"github.com/emirpasic/gods/sets/treeset"
 	envoy_api_v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	envoy_api_v2_auth "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
 	envoy_config_accesslog_v2 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v2"
 	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
@@ -28,6 +29,7 @@ import (
 	envoy_config_filter_http_fault_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/fault/v2"
 	envoy_config_filter_http_router_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/router/v2"
 	envoy_config_filter_network_http_connection_manager_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	envoy_config_filter_network_tcp_proxy_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
 	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	envoy_extensions_accesslog_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
@@ -44,6 +46,7 @@ import (
 	"github.com/ghodss/yaml"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/credentials/oauth"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
@@ -79,6 +82,9 @@ func (r *TypeResolver) FindMessageByName(message protoreflect.FullName) (protore
 // TODO: If there's other message type can be passed in google.protobuf.Any, the typeUrl and messageType need to be added to this method to make sure it can be parsed and output correctly.
 func (r *TypeResolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
 	switch url {
+	case "type.googleapis.com/envoy.config.filter.network.tcp_proxy.v2.TcpProxy":
+		tcpProxy := envoy_config_filter_network_tcp_proxy_v2.TcpProxy{}
+		return tcpProxy.ProtoReflect().Type(), nil
 	case "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy":
 		tcpProxy := envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy{}
 		return tcpProxy.ProtoReflect().Type(), nil
@@ -151,6 +157,18 @@ func (r *TypeResolver) FindMessageByURL(url string) (protoreflect.MessageType, e
 	case "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext":
 		downstreamTlsContext := envoy_extensions_transport_sockets_tls_v3.DownstreamTlsContext{}
 		return downstreamTlsContext.ProtoReflect().Type(), nil
+	case "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.Secret":
+		secret := envoy_extensions_transport_sockets_tls_v3.Secret{}
+		return secret.ProtoReflect().Type(), nil
+	case "type.googleapis.com/envoy.api.v2.auth.UpstreamTlsContext":
+		upstreamTlsContext := envoy_api_v2_auth.UpstreamTlsContext{}
+		return upstreamTlsContext.ProtoReflect().Type(), nil
+	case "type.googleapis.com/envoy.api.v2.auth.DownstreamTlsContext":
+		downstreamTlsContext := envoy_api_v2_auth.DownstreamTlsContext{}
+		return downstreamTlsContext.ProtoReflect().Type(), nil
+	case "type.googleapis.com/envoy.api.v2.auth.Secret":
+		secret := envoy_api_v2_auth.Secret{}
+		return secret.ProtoReflect().Type(), nil
 	default:
 		dummy := anypb.Any{}
 		return dummy.ProtoReflect().Type(), nil
@@ -218,87 +236,114 @@ func ParseXdsRelationship(js []byte) (GraphData, error) {
 	rdsToCds := make(map[string]*treeset.Set)
 	cdsToEds := make(map[string]*treeset.Set)
 
-	for _, config := range data["config"].([]interface{}) {
-		configMap := config.(map[string]interface{})
-		for _, xds := range configMap["xdsConfig"].([]interface{}) {
-			for key, value := range xds.(map[string]interface{}) {
-				if key == "status" {
-					continue
+	// the add functions record a resource from its json, the type assertions are checked since
+	// listeners and routes do not always refer to routes and clusters (e.g. tcp_proxy, redirect)
+	addListener := func(idx int, detail map[string]interface{}) {
+		name, _ := detail["name"].(string)
+		lds[name] = "LDS" + strconv.Itoa(idx)
+		rdsSet := treeset.NewWithStringComparator()
+		for _, filterchain := range jsonList(detail["filterChains"]) {
+			for _, filter := range jsonList(jsonMap(filterchain)["filters"]) {
+				if rdsName, ok := jsonMap(jsonMap(jsonMap(filter)["typedConfig"])["rds"])["routeConfigName"].(string); ok {
+					rdsSet.Add(rdsName)
+				}
+			}
+		}
+		ldsToRds[name] = rdsSet
+	}
+	addRoute := func(idx int, routeConfig map[string]interface{}) {
+		name, _ := routeConfig["name"].(string)
+		rds[name] = "RDS" + strconv.Itoa(idx)
+		cdsSet := treeset.NewWithStringComparator()
+		for _, virtualHost := range jsonList(routeConfig["virtualHosts"]) {
+			for _, virtualRoutes := range jsonList(jsonMap(virtualHost)["routes"]) {
+				virtualRoute := jsonMap(jsonMap(virtualRoutes)["route"])
+				if weightedClusters, ok := virtualRoute["weightedClusters"]; ok {
+					for _, cluster := range jsonList(jsonMap(weightedClusters)["clusters"]) {
+						if cdsName, ok := jsonMap(cluster)["name"].(string); ok {
+							cdsSet.Add(cdsName)
+						}
+					}
+				} else if cdsName, ok := virtualRoute["cluster"].(string); ok {
+					cdsSet.Add(cdsName)
 				}
+			}
+		}
+		rdsToCds[name] = cdsSet
+	}
+	addCluster := func(idx int, cluster map[string]interface{}) {
+		name, _ := cluster["name"].(string)
+		cds[name] = "CDS" + strconv.Itoa(idx)
+	}
+	addEndpoint := func(idx int, endpoint map[string]interface{}) {
+		id := "EDS" + strconv.Itoa(idx)
+		eds[id] = id
+		clusterName, _ := endpoint["clusterName"].(string)
+		if cdsSet, ok := cdsToEds[clusterName]; ok {
+			cdsSet.Add(id)
+		} else {
+			cdsSet = treeset.NewWithStringComparator()
+			cdsSet.Add(id)
+			cdsToEds[clusterName] = cdsSet
+		}
+	}
+
+	for _, config := range jsonList(data["config"]) {
+		configMap := jsonMap(config)
+		for _, xds := range jsonList(configMap["xdsConfig"]) {
+			for key, value := range jsonMap(xds) {
 				switch key {
 				case "listenerConfig":
-					for _, listeners := range value.(map[string]interface{}) {
-						for idx, listener := range listeners.([]interface{}) {
-							detail := listener.(map[string]interface{})["activeState"].(map[string]interface{})["listener"].(map[string]interface{})
-							name := detail["name"].(string)
-							id := "LDS" + strconv.Itoa(idx)
-							lds[name] = id
-							rdsSet := treeset.NewWithStringComparator()
-
-							for _, filterchain := range detail["filterChains"].([]interface{}) {
-								if filters, ok := filterchain.(map[string]interface{})["filters"]; ok {
-									for _, filter := range filters.([]interface{}) {
-										rdsName := filter.(map[string]interface{})["typedConfig"].(map[string]interface{})["rds"].(map[string]interface{})["routeConfigName"].(string)
-										rdsSet.Add(rdsName)
-									}
-								}
-							}
-							ldsToRds[name] = rdsSet
+					for _, listeners := range jsonMap(value) {
+						for idx, listener := range jsonList(listeners) {
+							addListener(idx, jsonMap(jsonMap(jsonMap(listener)["activeState"])["listener"]))
 						}
 					}
 				case "routeConfig":
-					for _, routes := range value.(map[string]interface{}) {
-						for idx, route := range routes.([]interface{}) {
-							routeConfig := route.(map[string]interface{})["routeConfig"].(map[string]interface{})
-							name := routeConfig["name"].(string)
-							id := "RDS" + strconv.Itoa(idx)
-							rds[name] = id
-							cdsSet := treeset.NewWithStringComparator()
-
-							for _, virtualHost := range routeConfig["virtualHosts"].([]interface{}) {
-								for _, virtualRoutes := range virtualHost.(map[string]interface{})["routes"].([]interface{}) {
-									virtualRoute := virtualRoutes.(map[string]interface{})["route"].(map[string]interface{})
-									if weightedClusters, ok := virtualRoute["weightedClusters"]; ok {
-										for _, cluster := range weightedClusters.(map[string]interface{})["clusters"].([]interface{}) {
-											cdsName := cluster.(map[string]interface{})["name"].(string)
-											cdsSet.Add(cdsName)
-										}
-									} else {
-										cdsName := virtualRoute["cluster"].(string)
-										cdsSet.Add(cdsName)
-									}
-								}
-							}
-							rdsToCds[name] = cdsSet
+					for _, routes := range jsonMap(value) {
+						for idx, route := range jsonList(routes) {
+							addRoute(idx, jsonMap(jsonMap(route)["routeConfig"]))
 						}
 					}
 				case "clusterConfig":
-					for _, clusters := range value.(map[string]interface{}) {
-						for idx, cluster := range clusters.([]interface{}) {
-							name := cluster.(map[string]interface{})["cluster"].(map[string]interface{})["name"].(string)
-							id := "CDS" + strconv.Itoa(idx)
-							cds[name] = id
+					for _, clusters := range jsonMap(value) {
+						for idx, cluster := range jsonList(clusters) {
+							addCluster(idx, jsonMap(jsonMap(cluster)["cluster"]))
 						}
 					}
 				case "endpointConfig":
-					for _, endpoints := range value.(map[string]interface{}) {
-						for idx, endpoint := range endpoints.([]interface{}) {
-							id := "EDS" + strconv.Itoa(idx)
-							eds[id] = id
-
-							clusterName := endpoint.(map[string]interface{})["endpointConfig"].(map[string]interface{})["clusterName"].(string)
-							if cdsSet, ok := cdsToEds[clusterName]; ok {
-								cdsSet.Add(id)
-							} else {
-								cdsSet = treeset.NewWithStringComparator()
-								cdsSet.Add(id)
-								cdsToEds[clusterName] = cdsSet
-							}
+					for _, endpoints := range jsonMap(value) {
+						for idx, endpoint := range jsonList(endpoints) {
+							addEndpoint(idx, jsonMap(jsonMap(endpoint)["endpointConfig"]))
 						}
 					}
 				}
 			}
 		}
+
+		// resources in generic_xds_configs are numbered per xDS type
+		counts := make(map[string]int)
+		for _, xds := range jsonList(configMap["genericXdsConfigs"]) {
+			genericXdsConfig := jsonMap(xds)
+			typeUrl, _ := genericXdsConfig["typeUrl"].(string)
+			resource := jsonMap(genericXdsConfig["xdsConfig"])
+			if resource == nil {
+				continue
+			}
+			switch xdsName := XdsName(typeUrl); xdsName {
+			case "LDS":
+				addListener(counts[xdsName], resource)
+			case "RDS":
+				addRoute(counts[xdsName], resource)
+			case "CDS":
+				addCluster(counts[xdsName], resource)
+			case "EDS":
+				addEndpoint(counts[xdsName], resource)
+			default:
+				continue
+			}
+			counts[XdsName(typeUrl)]++
+		}
 	}
 
 	gData := GraphData{
@@ -309,6 +354,18 @@ func ParseXdsRelationship(js []byte) (GraphData, error) {
 	return gData, nil
 }
 
+// jsonMap returns the json value as an object, or nil if it is not an object
+func jsonMap(value interface{}) map[string]interface{} {
+	m, _ := value.(map[string]interface{})
+	return m
+}
+
+// jsonList returns the json value as an array, or nil if it is not an array
+func jsonList(value interface{}) []interface{} {
+	l, _ := value.([]interface{})
+	return l
+}
+
 // GenerateGraph generates dot string based on GraphData
 func GenerateGraph(data GraphData) (string, error) {
 	graphAst, err := gographviz.ParseString(`digraph G {}`)
@@ -369,6 +426,14 @@ func OpenBrowser(url string) error {
 
 // PrintDetailedConfig prints out the detailed xDS config and calls visualize() if it is enabled
 func PrintDetailedConfig(response proto.Message, opts client.ClientOptions) error {
+	// redact the sensitive fields unless it is disabled by -no_redact
+	if fields := RedactedFields(opts); fields != nil {
+		var err error
+		if response, err = Redact(response, fields); err != nil {
+			return err
+		}
+	}
+
 	// parse response to json
 	// format the json and resolve google.protobuf.Any types
 	m := protojson.MarshalOptions{Multiline: true, Indent: "  ", Resolver: &TypeResolver{}}
@@ -448,6 +513,11 @@ func ConnToGCPWithAuto(uri string) (*grpc.ClientConn, error) {
 	return clientConn, nil
 }
 
+// ConnInsecure connects to uri without TLS nor authentication
+func ConnInsecure(uri string) (*grpc.ClientConn, error) {
+	return grpc.Dial(uri, grpc.WithTransportCredentials(insecure.NewCredentials()))
+}
+
 // ParseYamlFileToMap parses yaml file to map
 func ParseYamlFileToMap(path string) (map[string]interface{}, error) {
 	// parse yaml to json
@@ -514,3 +584,14 @@ func FilterNodeId(id string, filterMode string, filterPattern string) (bool, err
 	}
 	return false, nil
 }
+
+// SplitList splits a comma separated value into a list, ignoring empty items
+func SplitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
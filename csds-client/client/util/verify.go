@@ -0,0 +1,168 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Divergence is a resource on which two configs disagree, e.g. the control plane and the proxy
+type Divergence struct {
+	Xds  string
+	Name string
+	// Reason is e.g. "missing in proxy", "missing in control plane", "version mismatch" or "content mismatch"
+	Reason string
+	// Left and Right are the versions, or the content hashes on a content mismatch
+	Left  string
+	Right string
+}
+
+// VerifyResources compares the resources that the control plane reports for a client with the
+// resources that the proxy has applied, matching them by xDS type and name. The resources of the
+// proxy without version_info come from its bootstrap rather than xDS, and are not compared. It
+// returns the divergences in order and the number of resources compared.
+func VerifyResources(controlPlane ClientConfig, proxy ClientConfig) ([]Divergence, int) {
+	var applied []Resource
+	for _, resource := range proxy.Resources {
+		if resource.Version != "" {
+			applied = append(applied, resource)
+		}
+	}
+	return compareResources(controlPlane.Resources, applied, "missing in proxy", "missing in control plane")
+}
+
+// DiffResources compares the resources of two clients, matching them by xDS type and name. It
+// returns the differences in order and the number of resources compared.
+func DiffResources(first ClientConfig, second ClientConfig) ([]Divergence, int) {
+	return compareResources(first.Resources, second.Resources, "missing in second", "missing in first")
+}
+
+// compareResources compares the left and the right resources by xDS type and name, the resources
+// on one side only are reported with the missingRight and missingLeft reasons
+func compareResources(left []Resource, right []Resource, missingRight string, missingLeft string) ([]Divergence, int) {
+	expected := make(map[string]Resource)
+	for _, resource := range left {
+		expected[resource.Xds+"/"+resource.Name] = resource
+	}
+	actual := make(map[string]Resource)
+	for _, resource := range right {
+		actual[resource.Xds+"/"+resource.Name] = resource
+	}
+
+	var divergences []Divergence
+	compared := make(map[string]bool)
+	for key, want := range expected {
+		compared[key] = true
+		got, ok := actual[key]
+		switch {
+		case !ok:
+			divergences = append(divergences, Divergence{Xds: want.Xds, Name: want.Name, Reason: missingRight, Left: want.Version})
+		case want.Version != got.Version:
+			divergences = append(divergences, Divergence{Xds: want.Xds, Name: want.Name, Reason: "version mismatch", Left: want.Version, Right: got.Version})
+		default:
+			// the content is only compared if both sides have it
+			wantHash, gotHash := ResourceHash(want.Config), ResourceHash(got.Config)
+			if wantHash != "" && gotHash != "" && wantHash != gotHash {
+				divergences = append(divergences, Divergence{Xds: want.Xds, Name: want.Name, Reason: "content mismatch", Left: wantHash, Right: gotHash})
+			}
+		}
+	}
+	for key, got := range actual {
+		if !compared[key] {
+			compared[key] = true
+			divergences = append(divergences, Divergence{Xds: got.Xds, Name: got.Name, Reason: missingLeft, Right: got.Version})
+		}
+	}
+
+	sort.Slice(divergences, func(i, j int) bool {
+		if divergences[i].Xds != divergences[j].Xds {
+			return divergences[i].Xds < divergences[j].Xds
+		}
+		return divergences[i].Name < divergences[j].Name
+	})
+	return divergences, len(compared)
+}
+
+// ResourceHash returns a short hash of the content of an xDS resource, which is the same for equal
+// resources regardless of their api version or field order, including the field order of the typed
+// configs nested in them. It returns "" if the resource cannot be decoded.
+func ResourceHash(config *anypb.Any) string {
+	if config == nil {
+		return ""
+	}
+	m, err := DecodeResource(config)
+	if err != nil {
+		return ""
+	}
+	if err := canonicalizeAny(m.ProtoReflect()); err != nil {
+		return ""
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// canonicalizeAny re-encodes the value of each google.protobuf.Any in the message deterministically,
+// after doing the same to the Anys nested in it, so that equal typed configs have equal bytes. The
+// types are resolved with AdminTypeResolver, and the Anys of types that are not linked into the
+// binary are kept as is.
+func canonicalizeAny(m protoreflect.Message) error {
+	if packed, ok := m.Interface().(*anypb.Any); ok {
+		mt, err := (&AdminTypeResolver{}).FindMessageByURL(packed.GetTypeUrl())
+		if err != nil || mt.Descriptor().FullName() == packed.ProtoReflect().Descriptor().FullName() {
+			return nil
+		}
+		nested := mt.New().Interface()
+		if err := proto.Unmarshal(packed.GetValue(), nested); err != nil {
+			return err
+		}
+		if err := canonicalizeAny(nested.ProtoReflect()); err != nil {
+			return err
+		}
+		value, err := proto.MarshalOptions{Deterministic: true}.Marshal(nested)
+		if err != nil {
+			return err
+		}
+		packed.Value = value
+		return nil
+	}
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			for i := 0; i < v.List().Len() && err == nil; i++ {
+				err = canonicalizeAny(v.List().Get(i).Message())
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, value protoreflect.Value) bool {
+				err = canonicalizeAny(value.Message())
+				return err == nil
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			err = canonicalizeAny(v.Message())
+		}
+		return err == nil
+	})
+	return err
+}
+
+// PrintDivergences prints out the divergences, with the left and the right titles as the headers of
+// the versions
+func PrintDivergences(divergences []Divergence, compared int, left string, right string) {
+	if len(divergences) > 0 {
+		fmt.Printf("%-10s %-50s %-25s %-30s %-30s \n", "xDS", "Resource", "Divergence", left, right)
+		for _, d := range divergences {
+			fmt.Printf("%-10s %-50s %-25s %-30s %-30s \n", d.Xds, d.Name, d.Reason, d.Left, d.Right)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Resources compared: %d, divergences: %d\n", compared, len(divergences))
+}
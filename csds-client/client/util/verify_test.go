@@ -0,0 +1,40 @@
+package util
+
+import (
+	"testing"
+
+	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// TestResourceHashNestedTypedConfig tests that the typed configs nested in a resource are hashed by
+// content, also when their type is only linked into the binary
+func TestResourceHashNestedTypedConfig(t *testing.T) {
+	var fields [][]byte
+	for key, value := range map[string]string{"a": "1", "b": "2"} {
+		b, err := proto.Marshal(&structpb.Struct{Fields: map[string]*structpb.Value{key: structpb.NewStringValue(value)}})
+		if err != nil {
+			t.Fatalf("Marshal struct error: %v", err)
+		}
+		fields = append(fields, b)
+	}
+	// the same struct with its fields encoded in both orders
+	hash := func(first, second []byte) string {
+		typedConfig := &anypb.Any{TypeUrl: "type.googleapis.com/google.protobuf.Struct", Value: append(append([]byte{}, first...), second...)}
+		listener, err := anypb.New(&envoy_config_listener_v3.Listener{
+			Name: "listener",
+			ListenerFilters: []*envoy_config_listener_v3.ListenerFilter{
+				{Name: "filter", ConfigType: &envoy_config_listener_v3.ListenerFilter_TypedConfig{TypedConfig: typedConfig}},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Pack listener error: %v", err)
+		}
+		return ResourceHash(listener)
+	}
+	if got, want := hash(fields[0], fields[1]), hash(fields[1], fields[0]); got == "" || got != want {
+		t.Errorf("ResourceHash() = %q and %q, want the same hash for the same typed config", got, want)
+	}
+}
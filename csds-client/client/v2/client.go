@@ -9,15 +9,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"time"
 
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	csdspb_v2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
 	envoy_type_matcher_v2 "github.com/envoyproxy/go-control-plane/envoy/type/matcher"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 // ClientV2 implements the Client interface
@@ -25,9 +28,22 @@ type ClientV2 struct {
 	clientConn *grpc.ClientConn
 	csdsClient csdspb_v2.ClientStatusDiscoveryServiceClient
 
+	// queries are sent separately, each in its own request
+	queries  []query
+	metadata metadata.MD
+	opts     client.ClientOptions
+
+	// history records the responses if -history_dir is set
+	history *clientutil.HistoryStore
+	// hooks are evaluated on the responses if -hooks_file is set
+	hooks *clientutil.Hooks
+}
+
+// query is the node_matchers at the top level of the request, or a query of the request by name
+type query struct {
+	// name is the name of the query in queries, or "" for the top level
+	name        string
 	nodeMatcher []*envoy_type_matcher_v2.NodeMatcher
-	metadata    metadata.MD
-	opts        client.ClientOptions
 }
 
 // Field keys that must be presented in the NodeMatcher
@@ -37,32 +53,96 @@ const (
 	gcpMeshScopeKey     string = "TRAFFICDIRECTOR_MESH_SCOPE_NAME"
 )
 
+// requestSchema is the schema that the request yaml is validated against, the node of the request
+// is not sent with v2
+var requestSchema = clientutil.RequestSchema{
+	NodeMatcher: (&envoy_type_matcher_v2.NodeMatcher{}).ProtoReflect().Descriptor(),
+}
+
+// ValidateRequestFile checks the request yaml file for unknown fields and values of the wrong type
+func ValidateRequestFile(path string) error {
+	_, err := clientutil.ReadRequestFile(path, requestSchema)
+	return err
+}
+
 // parseNodeMatcher parses the csds request yaml from -request_file and -request_yaml to nodematcher
 // if -request_file and -request_yaml are both set, the values in this yaml string will override and
-// merge with the request loaded from -request_file
+// merge with the request loaded from -request_file. The NodeMatcher of the -node_id, -metadata and
+// -gcp_* flags is then merged into the request. Without -request_name, the node_matchers at the top
+// level and each query by name are separate requests.
 func (c *ClientV2) parseNodeMatcher() error {
-	if c.opts.RequestFile == "" && c.opts.RequestYaml == "" {
+	flagMatcher, err := clientutil.NodeMatcherFromOptions(c.opts)
+	if err != nil {
+		return err
+	}
+	if c.opts.RequestFile == "" && c.opts.RequestYaml == "" && flagMatcher == nil {
 		return errors.New("missing request yaml")
 	}
 
-	var nodematchers []*envoy_type_matcher_v2.NodeMatcher
-	if err := parseYaml(c.opts.RequestFile, c.opts.RequestYaml, &nodematchers); err != nil {
-		return err
+	names := []string{c.opts.RequestName}
+	if c.opts.RequestName == "" {
+		if names, err = requestQueries(c.opts.RequestFile, c.opts.RequestYaml); err != nil {
+			return err
+		}
+	}
+	c.queries = nil
+	for _, name := range names {
+		q := query{name: name}
+		if err := parseYaml(c.opts.RequestFile, c.opts.RequestYaml, name, &q.nodeMatcher); err != nil {
+			return err
+		}
+		if flagMatcher != nil {
+			// the v2 NodeMatcher is wire compatible with v3
+			m := &envoy_type_matcher_v2.NodeMatcher{}
+			if err := clientutil.Upgrade(flagMatcher, m); err != nil {
+				return err
+			}
+			q.nodeMatcher = applyNodeMatcher(q.nodeMatcher, m)
+		}
+		if err := c.validateQuery(q); err != nil {
+			if name != "" {
+				return fmt.Errorf("query %v: %v", name, err)
+			}
+			return err
+		}
+		c.queries = append(c.queries, q)
 	}
 
-	c.nodeMatcher = nodematchers
+	if c.opts.FilterMode != "" && c.opts.FilterMode != "prefix" && c.opts.FilterMode != "suffix" && c.opts.FilterMode != "regex" {
+		return fmt.Errorf("%s filter mode is not supported, list of supported filter modes: prefix, suffix, regex", c.opts.FilterMode)
+	}
+
+	if c.opts.Filter != "" {
+		filter, err := clientutil.ParseFilter(c.opts.Filter)
+		if err != nil {
+			return err
+		}
+		// the node metadata that the filtered clients must have is also sent to the control plane
+		// in the NodeMatcher, so that the response can be narrowed down on the server side
+		addMetadataToNodeMatcher(c.nodeMatchers(), clientutil.FilterMetadataExacts(filter))
+	}
 
-	// check if required fields exist in NodeMatcher
+	if c.opts.Query != "" {
+		if _, err := clientutil.ParseJsonPath(c.opts.Query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateQuery checks that the required fields exist in the NodeMatcher of the query
+func (c *ClientV2) validateQuery(q query) error {
 	switch c.opts.Platform {
 	case "gcp":
 		// Project Number is necessary
-		if value := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpProjectNumberKey); value == "" {
+		if value := getValueByKeyFromNodeMatcher(q.nodeMatcher, gcpProjectNumberKey); value == "" {
 			return fmt.Errorf("missing field %v in NodeMatcher", gcpProjectNumberKey)
 		}
 
 		// Only one of these must be set.
-		networkNameValue := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpNetworkNameKey)
-		meshScopeValue := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpMeshScopeKey)
+		networkNameValue := getValueByKeyFromNodeMatcher(q.nodeMatcher, gcpNetworkNameKey)
+		meshScopeValue := getValueByKeyFromNodeMatcher(q.nodeMatcher, gcpMeshScopeKey)
 		if len(networkNameValue) == 0 && len(meshScopeValue) == 0 {
 			return fmt.Errorf("must set either %v or %v", gcpNetworkNameKey, gcpMeshScopeKey)
 		} else if len(networkNameValue) > 0 && len(meshScopeValue) > 0 {
@@ -71,47 +151,49 @@ func (c *ClientV2) parseNodeMatcher() error {
 	default:
 		return fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
 	}
+	return nil
+}
 
-	if c.opts.FilterMode != "" && c.opts.FilterMode != "prefix" && c.opts.FilterMode != "suffix" && c.opts.FilterMode != "regex" {
-		return fmt.Errorf("%s filter mode is not supported, list of supported filter modes: prefix, suffix, regex", c.opts.FilterMode)
+// nodeMatchers returns the NodeMatchers of all the queries
+func (c *ClientV2) nodeMatchers() []*envoy_type_matcher_v2.NodeMatcher {
+	var nms []*envoy_type_matcher_v2.NodeMatcher
+	for _, q := range c.queries {
+		nms = append(nms, q.nodeMatcher...)
 	}
-
-	return nil
+	return nms
 }
 
 // connWithAuth connects to uri with authentication
-func (c *ClientV2) connWithAuth() error {
-	var err error
+func (c *ClientV2) connWithAuth(uri string) (*grpc.ClientConn, error) {
 	switch c.opts.AuthnMode {
 	case "jwt":
 		switch c.opts.Platform {
 		case "gcp":
-			c.clientConn, err = clientutil.ConnToGCPWithJwt(c.opts.Jwt, c.opts.Uri)
-			if err != nil {
-				return err
-			}
-			return nil
+			return clientutil.ConnToGCPWithJwt(c.opts.Jwt, uri)
 		default:
-			return fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
+			return nil, fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
 		}
 
 	case "auto":
 		switch c.opts.Platform {
 		case "gcp":
 			// parse GCP project number as header for authentication
-			if projectNum := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpProjectNumberKey); projectNum != "" {
+			if projectNum := getValueByKeyFromNodeMatcher(c.nodeMatchers(), gcpProjectNumberKey); projectNum != "" {
 				c.metadata = metadata.Pairs("x-goog-user-project", projectNum)
 			}
-			c.clientConn, err = clientutil.ConnToGCPWithAuto(c.opts.Uri)
-			if err != nil {
-				return err
-			}
-			return nil
+			return clientutil.ConnToGCPWithAuto(uri)
 		default:
-			return errors.New("auto authentication mode for this platform is not supported. Please use jwt_file instead")
+			return nil, errors.New("auto authentication mode for this platform is not supported. Please use jwt_file instead")
+		}
+	case "none":
+		// connect without TLS nor credentials, e.g. to a local or test server, the project number
+		// header is still sent so that the server can tell the project of the request
+		if projectNum := getValueByKeyFromNodeMatcher(c.nodeMatchers(), gcpProjectNumberKey); projectNum != "" {
+			c.metadata = metadata.Pairs("x-goog-user-project", projectNum)
 		}
+		return clientutil.ConnInsecure(uri)
 	default:
-		return errors.New("invalid authn_mode")
+		return nil, errors.New("invalid authn_mode")
 	}
 }
 
@@ -123,17 +205,47 @@ func New(option client.ClientOptions) (*ClientV2, error) {
 	if c.opts.Platform != "gcp" {
 		return nil, fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
 	}
+	if c.opts.Source != "" && c.opts.Source != "csds" {
+		return nil, fmt.Errorf("%s source is only supported with -api_version v3", c.opts.Source)
+	}
 
 	if err := c.parseNodeMatcher(); err != nil {
 		return nil, err
 	}
 
+	if c.opts.HistoryDir != "" {
+		history, err := clientutil.OpenHistoryRecorder(c.opts.HistoryDir, clientutil.RedactedFields(c.opts))
+		if err != nil {
+			return nil, err
+		}
+		c.history = history
+	}
+	if c.opts.HooksFile != "" {
+		hooks, err := clientutil.LoadHooks(c.opts.HooksFile)
+		if err != nil {
+			return nil, err
+		}
+		c.hooks = hooks
+	}
+
 	return c, nil
 }
 
 // Run connects the client to the uri and calls doRequest
 func (c *ClientV2) Run() error {
-	if err := c.connWithAuth(); err != nil {
+	if c.history != nil {
+		// release the lock of the history directory
+		defer c.history.Close()
+	}
+	uris, err := clientutil.ServiceUris(c.opts)
+	if err != nil {
+		return err
+	}
+	if len(uris) > 1 {
+		return c.runFanOut(uris)
+	}
+
+	if c.clientConn, err = c.connWithAuth(uris[0]); err != nil {
 		return err
 	}
 	defer c.clientConn.Close()
@@ -177,26 +289,67 @@ func (c *ClientV2) Run() error {
 	}
 }
 
-// doRequest sends request and prints out the parsed response
+// request builds the csds request of the query
+func (c *ClientV2) request(q query) *csdspb_v2.ClientStatusRequest {
+	return &csdspb_v2.ClientStatusRequest{NodeMatchers: q.nodeMatcher}
+}
+
+// doRequest sends the request of each query and prints out the parsed responses
 func (c *ClientV2) doRequest(streamClientStatus csdspb_v2.ClientStatusDiscoveryService_StreamClientStatusClient) error {
+	responses := make([]*csdspb_v2.ClientStatusResponse, len(c.queries))
+	for i, q := range c.queries {
+		if err := streamClientStatus.Send(c.request(q)); err != nil {
+			return err
+		}
 
-	req := &csdspb_v2.ClientStatusRequest{NodeMatchers: c.nodeMatcher}
-	if err := streamClientStatus.Send(req); err != nil {
-		return err
+		resp, err := streamClientStatus.Recv()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		responses[i] = resp
 	}
+	return c.printQueries(responses)
+}
 
-	resp, err := streamClientStatus.Recv()
-	if err != nil && err != io.EOF {
+// printQueries observes the clients of the responses to the queries together, and prints out the
+// response to each query, under the name of the query if the request has more than one
+func (c *ClientV2) printQueries(responses []*csdspb_v2.ClientStatusResponse) error {
+	if err := c.observeResponse(mergeQueries(responses)); err != nil {
 		return err
 	}
-	// post process response
-	if err := printOutResponse(resp, c.opts); err != nil {
-		return err
+	for i, resp := range responses {
+		if len(c.queries) > 1 {
+			fmt.Printf("Query %v:\n", clientutil.QueryLabel(c.queries[i].name))
+		}
+		// post process response
+		if err := printOutResponse(resp, c.opts); err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
+// mergeQueries merges the responses to the queries into one response, in which a client that
+// matches more than one query is only once
+func mergeQueries(responses []*csdspb_v2.ClientStatusResponse) *csdspb_v2.ClientStatusResponse {
+	if len(responses) == 1 {
+		return responses[0]
+	}
+	merged := &csdspb_v2.ClientStatusResponse{}
+	seen := make(map[string]bool)
+	for _, response := range responses {
+		for _, config := range response.GetConfig() {
+			id := config.GetNode().GetId()
+			if seen[id] && id != "" {
+				continue
+			}
+			seen[id] = true
+			merged.Config = append(merged.Config, config)
+		}
+	}
+	return merged
+}
+
 // parseConfigStatus parses each xds config status to string
 func parseConfigStatus(xdsConfig []*csdspb_v2.PerXdsConfig) []string {
 	var configStatus []string
@@ -219,8 +372,158 @@ func parseConfigStatus(xdsConfig []*csdspb_v2.PerXdsConfig) []string {
 	return configStatus
 }
 
+// parseClientConfig parses a client in the response to the api version independent view
+func parseClientConfig(config *csdspb_v2.ClientConfig) (clientutil.ClientConfig, error) {
+	node := &envoy_config_core_v3.Node{}
+	if err := clientutil.Upgrade(config.GetNode(), node); err != nil {
+		return clientutil.ClientConfig{}, err
+	}
+	c := clientutil.NewClientConfig(node)
+	if c.BuildVersion == "" {
+		c.BuildVersion = config.GetNode().GetBuildVersion()
+	}
+
+	for _, perXdsConfig := range config.GetXdsConfig() {
+		status := perXdsConfig.GetStatus().String()
+		resource := func(xds string, name string, version string, config *anypb.Any) clientutil.Resource {
+			if config != nil {
+				name = clientutil.ResourceName(config)
+			}
+			return clientutil.Resource{Xds: xds, Name: name, Version: version, Status: status, Config: config}
+		}
+
+		var xds string
+		var resources []clientutil.Resource
+		if listenerConfig := perXdsConfig.GetListenerConfig(); listenerConfig != nil {
+			xds = "LDS"
+			for _, listener := range listenerConfig.GetStaticListeners() {
+				resources = append(resources, resource(xds, "", "", listener.GetListener()))
+			}
+			for _, listener := range listenerConfig.GetDynamicListeners() {
+				state := listener.GetActiveState()
+				if state == nil {
+					state = listener.GetWarmingState()
+				}
+				resources = append(resources, resource(xds, listener.GetName(), state.GetVersionInfo(), state.GetListener()))
+			}
+		} else if clusterConfig := perXdsConfig.GetClusterConfig(); clusterConfig != nil {
+			xds = "CDS"
+			for _, cluster := range clusterConfig.GetStaticClusters() {
+				resources = append(resources, resource(xds, "", "", cluster.GetCluster()))
+			}
+			for _, cluster := range clusterConfig.GetDynamicActiveClusters() {
+				resources = append(resources, resource(xds, "", cluster.GetVersionInfo(), cluster.GetCluster()))
+			}
+			for _, cluster := range clusterConfig.GetDynamicWarmingClusters() {
+				resources = append(resources, resource(xds, "", cluster.GetVersionInfo(), cluster.GetCluster()))
+			}
+		} else if routeConfig := perXdsConfig.GetRouteConfig(); routeConfig != nil {
+			xds = "RDS"
+			for _, route := range routeConfig.GetStaticRouteConfigs() {
+				resources = append(resources, resource(xds, "", "", route.GetRouteConfig()))
+			}
+			for _, route := range routeConfig.GetDynamicRouteConfigs() {
+				resources = append(resources, resource(xds, "", route.GetVersionInfo(), route.GetRouteConfig()))
+			}
+		} else if scopedRouteConfig := perXdsConfig.GetScopedRouteConfig(); scopedRouteConfig != nil {
+			xds = "SRDS"
+			for _, scopedRoutes := range scopedRouteConfig.GetInlineScopedRouteConfigs() {
+				for _, scopedRoute := range scopedRoutes.GetScopedRouteConfigs() {
+					resources = append(resources, resource(xds, "", "", scopedRoute))
+				}
+			}
+			for _, scopedRoutes := range scopedRouteConfig.GetDynamicScopedRouteConfigs() {
+				for _, scopedRoute := range scopedRoutes.GetScopedRouteConfigs() {
+					resources = append(resources, resource(xds, "", scopedRoutes.GetVersionInfo(), scopedRoute))
+				}
+			}
+		}
+		if xds != "" {
+			c.AddStatus(xds, status)
+			c.Resources = append(c.Resources, resources...)
+		}
+	}
+	return c, nil
+}
+
+// matchNodeId checks if the node id matches the node id filter, if there is one
+func matchNodeId(id string, opts client.ClientOptions) (bool, error) {
+	if opts.FilterPattern == "" {
+		return true, nil
+	}
+	return clientutil.FilterNodeId(id, opts.FilterMode, opts.FilterPattern)
+}
+
+// parseClientConfigs parses the clients in the response that match the node id filter
+func parseClientConfigs(response *csdspb_v2.ClientStatusResponse, opts client.ClientOptions) ([]clientutil.ClientConfig, error) {
+	var configs []clientutil.ClientConfig
+	for _, config := range response.GetConfig() {
+		matched, err := matchNodeId(config.GetNode().GetId(), opts)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+		c, err := parseClientConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+// filterResponse returns the response with only the clients that match the filter
+func filterResponse(response *csdspb_v2.ClientStatusResponse, filter clientutil.Filter) (*csdspb_v2.ClientStatusResponse, error) {
+	filtered := &csdspb_v2.ClientStatusResponse{}
+	for _, config := range response.GetConfig() {
+		c, err := parseClientConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Match(c) {
+			filtered.Config = append(filtered.Config, config)
+		}
+	}
+	return filtered, nil
+}
+
 // printOutResponse processes response and print
 func printOutResponse(response *csdspb_v2.ClientStatusResponse, opts client.ClientOptions) error {
+	if opts.Filter != "" {
+		filter, err := clientutil.ParseFilter(opts.Filter)
+		if err != nil {
+			return err
+		}
+		if response, err = filterResponse(response, filter); err != nil {
+			return err
+		}
+	}
+
+	if opts.Query != "" {
+		var configs []proto.Message
+		for _, config := range response.GetConfig() {
+			matched, err := matchNodeId(config.GetNode().GetId(), opts)
+			if err != nil {
+				return err
+			}
+			if matched {
+				configs = append(configs, config)
+			}
+		}
+		return clientutil.PrintQueryResult(configs, opts)
+	}
+
+	if opts.Summary {
+		configs, err := parseClientConfigs(response, opts)
+		if err != nil {
+			return err
+		}
+		clientutil.PrintSummary(clientutil.BuildSummary(configs, opts.GroupByMetadata))
+		return nil
+	}
+
 	if response.GetConfig() == nil || len(response.GetConfig()) == 0 {
 		fmt.Printf("No xDS clients connected.\n")
 		return nil
@@ -287,16 +590,43 @@ func printOutResponse(response *csdspb_v2.ClientStatusResponse, opts client.Clie
 	return nil
 }
 
-// parseYaml is a helper method for parsing csds request yaml to NodeMatchers
-func parseYaml(path string, yamlStr string, nms *[]*envoy_type_matcher_v2.NodeMatcher) error {
+// requestQueries returns the names of the queries that are sent separately without -request_name,
+// over the requests of -request_file and -request_yaml
+func requestQueries(path string, yamlStr string) ([]string, error) {
+	var requests []map[string]interface{}
+	if path != "" {
+		data, err := clientutil.ReadRequestFile(path, requestSchema)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, data)
+	}
+	if yamlStr != "" {
+		data, err := clientutil.ParseRequest([]byte(yamlStr), "-request_yaml", requestSchema)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, data)
+	}
+	return clientutil.RequestQueries(requests...), nil
+}
+
+// parseYaml is a helper method for parsing the query with the name, or the top level without a name,
+// of the csds request yaml to NodeMatchers
+func parseYaml(path string, yamlStr string, name string, nms *[]*envoy_type_matcher_v2.NodeMatcher) error {
+	found := name == ""
+	var names []string
 	if path != "" {
-		data, err := clientutil.ParseYamlFileToMap(path)
+		data, err := clientutil.ReadRequestFile(path, requestSchema)
 		if err != nil {
 			return err
 		}
 
-		// parse each json object to proto
-		for _, n := range data["node_matchers"].([]interface{}) {
+		// parse each json object of the selected queries to proto
+		matchers, ok := clientutil.SelectNodeMatchers(data, name)
+		found = found || ok
+		names = append(names, clientutil.QueryNames(data)...)
+		for _, n := range matchers {
 			x := &envoy_type_matcher_v2.NodeMatcher{}
 
 			jsonString, err := json.Marshal(n)
@@ -310,13 +640,16 @@ func parseYaml(path string, yamlStr string, nms *[]*envoy_type_matcher_v2.NodeMa
 		}
 	}
 	if yamlStr != "" {
-		data, err := clientutil.ParseYamlStrToMap(yamlStr)
+		data, err := clientutil.ParseRequest([]byte(yamlStr), "-request_yaml", requestSchema)
 		if err != nil {
 			return err
 		}
 
-		// parse each json object to proto
-		for i, n := range data["node_matchers"].([]interface{}) {
+		// parse each json object of the selected queries to proto
+		matchers, ok := clientutil.SelectNodeMatchers(data, name)
+		found = found || ok
+		names = append(names, clientutil.QueryNames(data)...)
+		for i, n := range matchers {
 			x := &envoy_type_matcher_v2.NodeMatcher{}
 
 			jsonString, err := json.Marshal(n)
@@ -327,7 +660,7 @@ func parseYaml(path string, yamlStr string, nms *[]*envoy_type_matcher_v2.NodeMa
 				return err
 			}
 
-			// merge the proto with existing proto from request_file
+			// merge the proto with existing proto of the same query from request_file
 			if i < len(*nms) {
 				proto.Merge((*nms)[i], x)
 			} else {
@@ -335,6 +668,9 @@ func parseYaml(path string, yamlStr string, nms *[]*envoy_type_matcher_v2.NodeMa
 			}
 		}
 	}
+	if !found {
+		return clientutil.QueryNotFoundError(name, names)
+	}
 	return nil
 }
 
@@ -351,3 +687,64 @@ func getValueByKeyFromNodeMatcher(nms []*envoy_type_matcher_v2.NodeMatcher, key
 	}
 	return ""
 }
+
+// applyNodeMatcher merges the NodeMatcher of the flags into each NodeMatcher of the request, or
+// returns it as the only NodeMatcher if the request has none. Its node id and metadata matchers
+// replace the matchers of the request on the same node id and metadata keys.
+func applyNodeMatcher(nms []*envoy_type_matcher_v2.NodeMatcher, m *envoy_type_matcher_v2.NodeMatcher) []*envoy_type_matcher_v2.NodeMatcher {
+	if len(nms) == 0 {
+		return []*envoy_type_matcher_v2.NodeMatcher{m}
+	}
+	keys := make(map[string]bool)
+	for _, mt := range m.NodeMetadatas {
+		for _, path := range mt.Path {
+			keys[path.GetKey()] = true
+		}
+	}
+	for _, nm := range nms {
+		if m.NodeId != nil {
+			nm.NodeId = proto.Clone(m.NodeId).(*envoy_type_matcher_v2.StringMatcher)
+		}
+		var metadatas []*envoy_type_matcher_v2.StructMatcher
+		for _, mt := range nm.NodeMetadatas {
+			if len(mt.Path) != 1 || !keys[mt.Path[0].GetKey()] {
+				metadatas = append(metadatas, mt)
+			}
+		}
+		for _, mt := range m.NodeMetadatas {
+			metadatas = append(metadatas, proto.Clone(mt).(*envoy_type_matcher_v2.StructMatcher))
+		}
+		nm.NodeMetadatas = metadatas
+	}
+	return nms
+}
+
+// addMetadataToNodeMatcher adds exact matchers on the node metadata to each NodeMatcher, unless the
+// NodeMatcher already matches the metadata key
+func addMetadataToNodeMatcher(nms []*envoy_type_matcher_v2.NodeMatcher, md map[string]string) {
+	var keys []string
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, nm := range nms {
+		for _, key := range keys {
+			if getValueByKeyFromNodeMatcher([]*envoy_type_matcher_v2.NodeMatcher{nm}, key) != "" {
+				continue
+			}
+			nm.NodeMetadatas = append(nm.NodeMetadatas, &envoy_type_matcher_v2.StructMatcher{
+				Path: []*envoy_type_matcher_v2.StructMatcher_PathSegment{
+					{Segment: &envoy_type_matcher_v2.StructMatcher_PathSegment_Key{Key: key}},
+				},
+				Value: &envoy_type_matcher_v2.ValueMatcher{
+					MatchPattern: &envoy_type_matcher_v2.ValueMatcher_StringMatch{
+						StringMatch: &envoy_type_matcher_v2.StringMatcher{
+							MatchPattern: &envoy_type_matcher_v2.StringMatcher_Exact{Exact: md[key]},
+						},
+					},
+				},
+			})
+		}
+	}
+}
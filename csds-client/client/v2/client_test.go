@@ -4,10 +4,15 @@ package client
 import (
 	"envoy-tools/csds-client/client"
 	clientUtil "envoy-tools/csds-client/client/util"
+	"envoy-tools/csds-client/fake"
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	csdspb_v2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
 	"google.golang.org/protobuf/encoding/protojson"
 )
@@ -23,11 +28,11 @@ func TestParseNodeMatcherWithFile(t *testing.T) {
 	if err := c.parseNodeMatcher(); err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
-	if c.nodeMatcher == nil {
+	if c.queries[0].nodeMatcher == nil {
 		t.Errorf("Parse NodeMatcher Failure!")
 	}
 	want := "{\"nodeId\":{\"exact\":\"fake_node_id\"},\"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}},{\"path\":[{\"key\":\"TRAFFICDIRECTOR_NETWORK_NAME\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_network_name\"}}}]}"
-	get, err := protojson.Marshal(c.nodeMatcher[0])
+	get, err := protojson.Marshal(c.queries[0].nodeMatcher[0])
 	if err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
@@ -49,11 +54,11 @@ func TestParseNodeMatcherWithString(t *testing.T) {
 	if err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
-	if c.nodeMatcher == nil {
+	if c.queries[0].nodeMatcher == nil {
 		t.Errorf("Parse NodeMatcher Failure!")
 	}
 	want := "{\"nodeId\":{\"exact\":\"fake_node_id\"}, \"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}], \"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}}, {\"path\":[{\"key\":\"TRAFFICDIRECTOR_NETWORK_NAME\"}], \"value\":{\"stringMatch\":{\"exact\":\"fake_network_name\"}}}]}"
-	get, err := protojson.Marshal(c.nodeMatcher[0])
+	get, err := protojson.Marshal(c.queries[0].nodeMatcher[0])
 	if err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
@@ -74,11 +79,11 @@ func TestParseNodeMatcherWithFileAndString(t *testing.T) {
 	if err := c.parseNodeMatcher(); err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
-	if c.nodeMatcher == nil {
+	if c.queries[0].nodeMatcher == nil {
 		t.Errorf("Parse NodeMatcher Failure!")
 	}
 	want := "{\"nodeId\":{\"exact\":\"fake_node_id_from_cli\"}, \"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}], \"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}}, {\"path\":[{\"key\":\"TRAFFICDIRECTOR_NETWORK_NAME\"}], \"value\":{\"stringMatch\":{\"exact\":\"fake_network_name\"}}}]}"
-	get, err := protojson.Marshal(c.nodeMatcher[0])
+	get, err := protojson.Marshal(c.queries[0].nodeMatcher[0])
 	if err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
@@ -87,6 +92,29 @@ func TestParseNodeMatcherWithFileAndString(t *testing.T) {
 	}
 }
 
+// TestParseNodeMatcherWithFlags tests building the NodeMatcher from the flags without a request yaml
+func TestParseNodeMatcherWithFlags(t *testing.T) {
+	c := ClientV2{
+		opts: client.ClientOptions{
+			Platform:   "gcp",
+			NodeId:     "fake_node_id",
+			GcpProject: "fake_project_number",
+			GcpNetwork: "fake_network_name",
+		},
+	}
+	if err := c.parseNodeMatcher(); err != nil {
+		t.Fatalf("Parse NodeMatcher Error: %v", err)
+	}
+	want := "{\"nodeId\":{\"exact\":\"fake_node_id\"}, \"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}], \"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}}, {\"path\":[{\"key\":\"TRAFFICDIRECTOR_NETWORK_NAME\"}], \"value\":{\"stringMatch\":{\"exact\":\"fake_network_name\"}}}]}"
+	get, err := protojson.Marshal(c.queries[0].nodeMatcher[0])
+	if err != nil {
+		t.Errorf("Parse NodeMatcher Error: %v", err)
+	}
+	if len(c.queries[0].nodeMatcher) != 1 || !clientUtil.ShouldEqualJSON(t, string(get), want) {
+		t.Errorf("NodeMatcher = \n%v\n, want: \n%v\n", string(get), want)
+	}
+}
+
 // TestParseResponseWithoutNodeId tests post processing response without node_id.
 func TestParseResponseWithoutNodeId(t *testing.T) {
 	c := ClientV2{
@@ -301,11 +329,11 @@ func TestParseNodeMatcherMeshScopeKeyWithFile(t *testing.T) {
 	if err := c.parseNodeMatcher(); err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
-	if c.nodeMatcher == nil {
+	if c.queries[0].nodeMatcher == nil {
 		t.Errorf("Parse NodeMatcher Failure!")
 	}
 	want := "{\"nodeId\":{\"exact\":\"fake_node_id\"},\"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}},{\"path\":[{\"key\":\"TRAFFICDIRECTOR_MESH_SCOPE_NAME\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_mesh_scope_name\"}}}]}"
-	get, err := protojson.Marshal(c.nodeMatcher[0])
+	get, err := protojson.Marshal(c.queries[0].nodeMatcher[0])
 	if err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
@@ -326,3 +354,191 @@ func TestParseNodeMatcherMeshScopeAndNetworkShouldFail(t *testing.T) {
 		t.Errorf("Parse NodeMatcher should fail since network name and meshScope are provided.")
 	}
 }
+
+// TestSummary tests aggregating the clients in the response to a fleet-wide summary
+func TestSummary(t *testing.T) {
+	c := ClientV2{
+		opts: client.ClientOptions{
+			Platform: "gcp",
+			Summary:  true,
+		},
+	}
+	filename, _ := filepath.Abs("./response_with_nodeid_test.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	var response csdspb_v2.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(&response, c.opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := `Total clients: 1
+
+xDS        Config Status        Clients    
+CDS        STALE                1          
+RDS        STALE                1          
+
+xDS stream type                Clients    
+test_stream_type1              1          
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
+
+// TestRunWithFakeServer tests running the client end to end against a fake CSDS server
+func TestRunWithFakeServer(t *testing.T) {
+	filename, _ := filepath.Abs("./response_with_nodeid_test.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	response := &csdspb_v2.ClientStatusResponse{}
+	if err = protojson.Unmarshal(responsejson, response); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	server, err := fake.NewServer(fake.Step{Response: response})
+	if err != nil {
+		t.Fatalf("Start fake server error: %v", err)
+	}
+	defer server.Stop()
+
+	c, err := New(client.ClientOptions{
+		Uri:         server.Addr(),
+		Platform:    "gcp",
+		AuthnMode:   "none",
+		RequestFile: "./test_request.yaml",
+	})
+	if err != nil {
+		t.Fatalf("Create client error: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	})
+	if !strings.Contains(out, "test_nodeid") {
+		t.Errorf("want the client test_nodeid in the output, got\n%v", out)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("want 1 request, got %v", len(requests))
+	}
+	if got := requests[0].Metadata.Get("x-goog-user-project"); len(got) != 1 || got[0] != "fake_project_number" {
+		t.Errorf("want x-goog-user-project fake_project_number, got %v", got)
+	}
+	if _, ok := requests[0].Request.(*csdspb_v2.ClientStatusRequest); !ok {
+		t.Errorf("want a v2 request, got %T", requests[0].Request)
+	}
+}
+
+// readResponse reads a CSDS response from a json file
+func readResponse(t *testing.T, path string) *csdspb_v2.ClientStatusResponse {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	response := &csdspb_v2.ClientStatusResponse{}
+	if err := protojson.Unmarshal(data, response); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	return response
+}
+
+// TestClusters tests that the deprecated tls_context and hosts of v2 clusters are carried over to v3
+func TestClusters(t *testing.T) {
+	clients, err := parseClientConfigs(readResponse(t, "./response_for_v2_fields.json"), client.ClientOptions{})
+	if err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	want := []clientUtil.ClusterSummary{
+		{Id: "test_node_1", Name: "legacy", Type: "STATIC", LbPolicy: "round_robin", ConnectTimeout: "1s", CircuitBreakers: "default", OutlierDetection: "off", Tls: "on sni=legacy.example.com", HealthChecks: "-"},
+		{Id: "test_node_1", Name: "plain", Type: "EDS", LbPolicy: "round_robin", ConnectTimeout: "5s", CircuitBreakers: "default", OutlierDetection: "off", Tls: "off", HealthChecks: "-"},
+	}
+	if got := clientUtil.Clusters(clients[0]); !reflect.DeepEqual(got, want) {
+		t.Errorf("Clusters() = %+v, want %+v", got, want)
+	}
+
+	for _, r := range clients[0].Resources {
+		if r.Name != "legacy" {
+			continue
+		}
+		m, err := clientUtil.DecodeResource(r.Config)
+		if err != nil {
+			t.Fatalf("DecodeResource() error = %v", err)
+		}
+		endpoints := m.(*envoy_config_cluster_v3.Cluster).GetLoadAssignment().GetEndpoints()
+		if len(endpoints) != 1 || len(endpoints[0].GetLbEndpoints()) != 1 ||
+			endpoints[0].GetLbEndpoints()[0].GetEndpoint().GetAddress().GetSocketAddress().GetAddress() != "10.0.0.1" {
+			t.Errorf("the hosts of the v2 cluster are not upgraded to its load assignment: %v", endpoints)
+		}
+	}
+}
+
+// TestListeners tests that the deprecated tls_context and the config structs of the filters of the
+// filter chains of v2 listeners are carried over to v3
+func TestListeners(t *testing.T) {
+	clients, err := parseClientConfigs(readResponse(t, "./response_for_v2_fields.json"), client.ClientOptions{})
+	if err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	want := []clientUtil.ListenerSummary{
+		{
+			Id:      "test_node_1",
+			Name:    "legacy_ingress",
+			Address: "0.0.0.0:443",
+			FilterChains: []clientUtil.FilterChainSummary{
+				{
+					Name:  "#0",
+					Match: "server_names=legacy.example.com",
+					Tls:   true,
+					Filters: []clientUtil.FilterSummary{
+						{
+							Name:   "envoy.http_connection_manager",
+							Detail: "rds=legacy_routes",
+							HttpFilters: []clientUtil.FilterSummary{
+								{Name: "envoy.fault", Detail: "abort=503 at 10%"},
+								{Name: "envoy.router", Detail: "suppress_envoy_headers"},
+							},
+						},
+					},
+				},
+				{
+					Name:    "#1",
+					Match:   "any",
+					Filters: []clientUtil.FilterSummary{{Name: "envoy.tcp_proxy", Detail: "cluster=passthrough"}},
+				},
+			},
+		},
+	}
+	if got := clientUtil.Listeners(clients[0]); !reflect.DeepEqual(got, want) {
+		t.Errorf("Listeners() = %+v, want %+v", got, want)
+	}
+}
+
+// TestTlsContexts tests that the TLS contexts of the deprecated tls_context of v2 clusters and filter
+// chains are listed
+func TestTlsContexts(t *testing.T) {
+	clients, err := parseClientConfigs(readResponse(t, "./response_for_v2_fields.json"), client.ClientOptions{})
+	if err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	want := []clientUtil.TlsContextSummary{
+		{Id: "test_node_1", Resource: "cluster legacy", Direction: "upstream", Sni: "legacy.example.com", SanMatchers: []string{"exact=legacy.example.com"}},
+		{
+			Id:           "test_node_1",
+			Resource:     "listener legacy_ingress chain #0",
+			Direction:    "downstream",
+			Certificates: []clientUtil.CertificateSummary{{Source: "certificate_chain", File: "/etc/certs/legacy.pem"}},
+		},
+	}
+	if got := clientUtil.TlsContexts(clients[0], time.Now(), 30*24*time.Hour); !reflect.DeepEqual(got, want) {
+		t.Errorf("TlsContexts() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	clientutil "envoy-tools/csds-client/client/util"
+	"envoy-tools/csds-client/tui"
+	"fmt"
+
+	csdspb_v2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
+)
+
+// RunTui runs the interactive terminal UI on the clients, which are fetched again on -monitor_interval
+func (c *ClientV2) RunTui() error {
+	uris, err := clientutil.ServiceUris(c.opts)
+	if err != nil {
+		return err
+	}
+	targets, err := c.connectTargets(uris)
+	defer closeTargets(targets)
+	if err != nil {
+		return err
+	}
+	ctx := c.outgoingContext()
+	return tui.Run(func() ([]clientutil.ClientConfig, error) {
+		// nothing is printed while the UI is running, the control planes that fail are only
+		// reported if all of them fail
+		response, _, errs := c.fetchMerged(ctx, targets, c.queries)
+		var failed int
+		for _, err := range errs {
+			if err != nil {
+				failed++
+			}
+		}
+		if failed == len(targets) {
+			return nil, fmt.Errorf("failed to query all the %d control planes: %v", len(targets), errs[0])
+		}
+		return c.tuiConfigs(response)
+	}, c.opts.MonitorInterval, clientutil.RedactedFields(c.opts))
+}
+
+// tuiConfigs returns the clients in the response that match -filter and the node id filter
+func (c *ClientV2) tuiConfigs(response *csdspb_v2.ClientStatusResponse) ([]clientutil.ClientConfig, error) {
+	response, err := c.selectResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	return parseClientConfigs(response, c.opts)
+}
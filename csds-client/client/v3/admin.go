@@ -0,0 +1,256 @@
+package client
+
+import (
+	"encoding/json"
+	clientutil "envoy-tools/csds-client/client/util"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	envoy_admin_v3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// runEnvoyAdmin fetches the config dump from the Envoy admin address and prints it out as a CSDS
+// response, with the Envoy as the only client
+func (c *ClientV3) runEnvoyAdmin() error {
+	// run once or run with monitor mode
+	for {
+		response, err := fetchEnvoyAdminResponse(c.opts.AdminUri)
+		if err != nil {
+			return err
+		}
+		if err := printOutResponse(response, c.opts); err != nil {
+			return err
+		}
+		if c.opts.MonitorInterval == 0 {
+			return nil
+		}
+		time.Sleep(c.opts.MonitorInterval)
+	}
+}
+
+// fetchEnvoyAdminResponse fetches the config dump from the Envoy admin address and converts it to a
+// CSDS response
+func fetchEnvoyAdminResponse(adminUri string) (*csdspb_v3.ClientStatusResponse, error) {
+	configDump, err := clientutil.FetchConfigDump(adminUri)
+	if err != nil {
+		return nil, err
+	}
+	return configDumpToResponse(configDump)
+}
+
+// configDumpToResponse converts the listener, route, scoped route, cluster and endpoint sections of
+// the /config_dump of an Envoy admin to a CSDS response with the Envoy as the only client, so that
+// all the views on CSDS responses work on it. The node of the client is the node in the bootstrap.
+// A resource with a type that cannot be resolved is kept without its config.
+func configDumpToResponse(js []byte) (*csdspb_v3.ClientStatusResponse, error) {
+	var configDump struct {
+		Configs []map[string]interface{} `json:"configs"`
+	}
+	if err := json.Unmarshal(js, &configDump); err != nil {
+		return nil, fmt.Errorf("invalid config dump: %v", err)
+	}
+
+	config := &csdspb_v3.ClientConfig{Node: &envoy_config_core_v3.Node{}}
+	d := configDumpConverter{config: config}
+	for _, section := range configDump.Configs {
+		typeUrl, _ := section["@type"].(string)
+		switch strings.TrimPrefix(typeUrl, "type.googleapis.com/") {
+		case "envoy.admin.v3.BootstrapConfigDump":
+			if err := d.node(jsonField(jsonObject(section, "bootstrap"), "node")); err != nil {
+				return nil, err
+			}
+		case "envoy.admin.v3.ListenersConfigDump":
+			for _, listener := range jsonArray(section, "static_listeners") {
+				d.add("", "", jsonField(listener, "listener"), nil, "")
+			}
+			for _, listener := range jsonArray(section, "dynamic_listeners") {
+				state, status := jsonObject(listener, "active_state"), csdspb_v3.ConfigStatus_SYNCED
+				if state == nil {
+					state, status = jsonObject(listener, "warming_state"), csdspb_v3.ConfigStatus_STALE
+				}
+				errorState := jsonObject(listener, "error_state")
+				if errorState != nil {
+					status = csdspb_v3.ConfigStatus_ERROR
+				}
+				name, _ := jsonField(listener, "name").(string)
+				genericXdsConfig := d.add(name, jsonString(state, "version_info"), jsonField(state, "listener"), &status, jsonString(listener, "client_status"))
+				if genericXdsConfig == nil && errorState != nil {
+					// a listener that is rejected before any of its updates is applied only has its error
+					genericXdsConfig = d.addWithoutConfig("type.googleapis.com/envoy.config.listener.v3.Listener", name, status, jsonString(listener, "client_status"))
+				}
+				if genericXdsConfig != nil && errorState != nil {
+					genericXdsConfig.ErrorState = &envoy_admin_v3.UpdateFailureState{
+						Details:     jsonString(errorState, "details"),
+						VersionInfo: jsonString(errorState, "version_info"),
+					}
+				}
+			}
+		case "envoy.admin.v3.ClustersConfigDump":
+			for _, cluster := range jsonArray(section, "static_clusters") {
+				d.add("", "", jsonField(cluster, "cluster"), nil, "")
+			}
+			for _, cluster := range jsonArray(section, "dynamic_active_clusters") {
+				d.add("", jsonString(cluster, "version_info"), jsonField(cluster, "cluster"), nil, jsonString(cluster, "client_status"))
+			}
+			for _, cluster := range jsonArray(section, "dynamic_warming_clusters") {
+				status := csdspb_v3.ConfigStatus_STALE
+				d.add("", jsonString(cluster, "version_info"), jsonField(cluster, "cluster"), &status, jsonString(cluster, "client_status"))
+			}
+		case "envoy.admin.v3.RoutesConfigDump":
+			for _, route := range jsonArray(section, "static_route_configs") {
+				d.add("", "", jsonField(route, "route_config"), nil, "")
+			}
+			for _, route := range jsonArray(section, "dynamic_route_configs") {
+				d.add("", jsonString(route, "version_info"), jsonField(route, "route_config"), nil, jsonString(route, "client_status"))
+			}
+		case "envoy.admin.v3.ScopedRoutesConfigDump":
+			for _, scopedRoutes := range jsonArray(section, "inline_scoped_route_configs") {
+				for _, scopedRoute := range jsonArray(scopedRoutes, "scoped_route_configs") {
+					d.add("", "", scopedRoute, nil, "")
+				}
+			}
+			for _, scopedRoutes := range jsonArray(section, "dynamic_scoped_route_configs") {
+				for _, scopedRoute := range jsonArray(scopedRoutes, "scoped_route_configs") {
+					d.add("", jsonString(scopedRoutes, "version_info"), scopedRoute, nil, jsonString(scopedRoutes, "client_status"))
+				}
+			}
+		case "envoy.admin.v3.EndpointsConfigDump":
+			for _, endpoint := range jsonArray(section, "static_endpoint_configs") {
+				d.add("", "", jsonField(endpoint, "endpoint_config"), nil, "")
+			}
+			for _, endpoint := range jsonArray(section, "dynamic_endpoint_configs") {
+				d.add("", jsonString(endpoint, "version_info"), jsonField(endpoint, "endpoint_config"), nil, jsonString(endpoint, "client_status"))
+			}
+		}
+	}
+	return &csdspb_v3.ClientStatusResponse{Config: []*csdspb_v3.ClientConfig{config}}, nil
+}
+
+// configDumpConverter adds the resources in a config dump to the client config
+type configDumpConverter struct {
+	config *csdspb_v3.ClientConfig
+}
+
+// node sets the node of the client from the json of the bootstrap node
+func (d configDumpConverter) node(js interface{}) error {
+	if js == nil {
+		return nil
+	}
+	b, err := json.Marshal(js)
+	if err != nil {
+		return err
+	}
+	m := protojson.UnmarshalOptions{DiscardUnknown: true, Resolver: &clientutil.AdminTypeResolver{}}
+	if err := m.Unmarshal(b, d.config.Node); err != nil {
+		return fmt.Errorf("invalid node in config dump: %v", err)
+	}
+	return nil
+}
+
+// add adds a resource from its json and returns it, or nil if there is no resource. If the config
+// status is not set, it is derived from the client status, or is SYNCED for a resource that the
+// Envoy has applied.
+func (d configDumpConverter) add(name string, version string, js interface{}, status *csdspb_v3.ConfigStatus, clientStatus string) *csdspb_v3.ClientConfig_GenericXdsConfig {
+	resource, ok := js.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	typeUrl, _ := resource["@type"].(string)
+	genericXdsConfig := &csdspb_v3.ClientConfig_GenericXdsConfig{
+		TypeUrl:      typeUrl,
+		Name:         name,
+		VersionInfo:  version,
+		ConfigStatus: csdspb_v3.ConfigStatus_SYNCED,
+		ClientStatus: envoy_admin_v3.ClientResourceStatus(envoy_admin_v3.ClientResourceStatus_value[clientStatus]),
+	}
+	switch {
+	case status != nil:
+		genericXdsConfig.ConfigStatus = *status
+	case genericXdsConfig.ClientStatus == envoy_admin_v3.ClientResourceStatus_NACKED:
+		genericXdsConfig.ConfigStatus = csdspb_v3.ConfigStatus_ERROR
+	case genericXdsConfig.ClientStatus == envoy_admin_v3.ClientResourceStatus_REQUESTED:
+		genericXdsConfig.ConfigStatus = csdspb_v3.ConfigStatus_STALE
+	case genericXdsConfig.ClientStatus == envoy_admin_v3.ClientResourceStatus_DOES_NOT_EXIST:
+		genericXdsConfig.ConfigStatus = csdspb_v3.ConfigStatus_NOT_SENT
+	}
+
+	config := &anypb.Any{}
+	b, err := json.Marshal(resource)
+	if err == nil {
+		m := protojson.UnmarshalOptions{DiscardUnknown: true, Resolver: &clientutil.AdminTypeResolver{}}
+		err = m.Unmarshal(b, config)
+	}
+	if err != nil {
+		if name == "" {
+			name, _ = resource["name"].(string)
+		}
+		fmt.Fprintf(os.Stderr, "Unable to decode %v %v in config dump: %v\n", typeUrl, name, err)
+		config = nil
+	} else {
+		genericXdsConfig.XdsConfig = config
+		if resourceName := clientutil.ResourceName(config); resourceName != "" {
+			name = resourceName
+		}
+	}
+	genericXdsConfig.Name = name
+	d.config.GenericXdsConfigs = append(d.config.GenericXdsConfigs, genericXdsConfig)
+	return genericXdsConfig
+}
+
+// addWithoutConfig adds a resource of which the config dump only has the name and returns it
+func (d configDumpConverter) addWithoutConfig(typeUrl string, name string, status csdspb_v3.ConfigStatus, clientStatus string) *csdspb_v3.ClientConfig_GenericXdsConfig {
+	genericXdsConfig := &csdspb_v3.ClientConfig_GenericXdsConfig{
+		TypeUrl:      typeUrl,
+		Name:         name,
+		ConfigStatus: status,
+		ClientStatus: envoy_admin_v3.ClientResourceStatus(envoy_admin_v3.ClientResourceStatus_value[clientStatus]),
+	}
+	d.config.GenericXdsConfigs = append(d.config.GenericXdsConfigs, genericXdsConfig)
+	return genericXdsConfig
+}
+
+// jsonField gets a field of a json object by its proto field name or its json name, since the
+// config dump may use either of them
+func jsonField(js interface{}, name string) interface{} {
+	m, ok := js.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if v, ok := m[name]; ok {
+		return v
+	}
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return m[strings.Join(parts, "")]
+}
+
+func jsonObject(js interface{}, name string) map[string]interface{} {
+	m, _ := jsonField(js, name).(map[string]interface{})
+	return m
+}
+
+func jsonArray(js interface{}, name string) []map[string]interface{} {
+	var objects []map[string]interface{}
+	array, _ := jsonField(js, name).([]interface{})
+	for _, v := range array {
+		if m, ok := v.(map[string]interface{}); ok {
+			objects = append(objects, m)
+		}
+	}
+	return objects
+}
+
+func jsonString(js interface{}, name string) string {
+	s, _ := jsonField(js, name).(string)
+	return s
+}
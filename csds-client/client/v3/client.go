@@ -9,17 +9,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"time"
 
 	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
 	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/google/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
-	"github.com/google/uuid"
 )
 
 // ClientV3 implements the Client interface
@@ -27,10 +28,23 @@ type ClientV3 struct {
 	clientConn *grpc.ClientConn
 	csdsClient csdspb_v3.ClientStatusDiscoveryServiceClient
 
+	// queries are sent separately, each in its own request
+	queries  []query
+	metadata metadata.MD
+	opts     client.ClientOptions
+
+	// history records the responses if -history_dir is set
+	history *clientutil.HistoryStore
+	// hooks are evaluated on the responses if -hooks_file is set
+	hooks *clientutil.Hooks
+}
+
+// query is the node_matchers at the top level of the request, or a query of the request by name
+type query struct {
+	// name is the name of the query in queries, or "" for the top level
+	name        string
 	nodeMatcher []*envoy_type_matcher_v3.NodeMatcher
-	node envoy_config_core_v3.Node
-	metadata    metadata.MD
-	opts        client.ClientOptions
+	node        *envoy_config_core_v3.Node
 }
 
 // Field keys that must be presented in the NodeMatcher
@@ -40,35 +54,73 @@ const (
 	gcpMeshScopeKey     string = "TRAFFICDIRECTOR_MESH_SCOPE_NAME"
 )
 
+// requestSchema is the schema that the request yaml is validated against
+var requestSchema = clientutil.RequestSchema{
+	NodeMatcher: (&envoy_type_matcher_v3.NodeMatcher{}).ProtoReflect().Descriptor(),
+	Node:        (&envoy_config_core_v3.Node{}).ProtoReflect().Descriptor(),
+}
+
+// ValidateRequestFile checks the request yaml file for unknown fields and values of the wrong type
+func ValidateRequestFile(path string) error {
+	_, err := clientutil.ReadRequestFile(path, requestSchema)
+	return err
+}
+
 // parseNodeMatcher parses the csds request yaml from -request_file and -request_yaml to nodematcher
 // if -request_file and -request_yaml are both set, the values in this yaml string will override and
-// merge with the request loaded from -request_file
+// merge with the request loaded from -request_file. The NodeMatcher of the -node_id, -metadata and
+// -gcp_* flags is then merged into the request. Without -request_name, the node_matchers at the top
+// level and each query by name are separate requests.
 func (c *ClientV3) parseNodeMatcher() error {
-	if c.opts.RequestFile == "" && c.opts.RequestYaml == "" {
+	flagMatcher, err := clientutil.NodeMatcherFromOptions(c.opts)
+	if err != nil {
+		return err
+	}
+	if c.opts.RequestFile == "" && c.opts.RequestYaml == "" && flagMatcher == nil {
 		return errors.New("missing request yaml")
 	}
 
-	var nodematchers []*envoy_type_matcher_v3.NodeMatcher
-	var node envoy_config_core_v3.Node
-	if err := parseYaml(c.opts.RequestFile, c.opts.RequestYaml, &nodematchers, &node); err != nil {
-		return err
+	names := []string{c.opts.RequestName}
+	if c.opts.RequestName == "" {
+		if names, err = requestQueries(c.opts.RequestFile, c.opts.RequestYaml); err != nil {
+			return err
+		}
+	}
+	c.queries = nil
+	for _, name := range names {
+		q := query{name: name, node: &envoy_config_core_v3.Node{}}
+		if err := parseYaml(c.opts.RequestFile, c.opts.RequestYaml, name, &q.nodeMatcher, q.node); err != nil {
+			return err
+		}
+		if flagMatcher != nil {
+			q.nodeMatcher = applyNodeMatcher(q.nodeMatcher, proto.Clone(flagMatcher).(*envoy_type_matcher_v3.NodeMatcher))
+		}
+		if err := c.validateQuery(q); err != nil {
+			if name != "" {
+				return fmt.Errorf("query %v: %v", name, err)
+			}
+			return err
+		}
+		c.queries = append(c.queries, q)
 	}
 
-	c.nodeMatcher = nodematchers
-	c.node = node
+	return c.validateOptions()
+}
 
-	// check if required fields exist in NodeMatcher
+// validateQuery checks that the required fields exist in the NodeMatcher of the query, and sets the
+// node id of the query
+func (c *ClientV3) validateQuery(q query) error {
 	switch c.opts.Platform {
 	case "gcp":
 		// Project Number is necessary
-		projectNumber := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpProjectNumberKey)
+		projectNumber := getValueByKeyFromNodeMatcher(q.nodeMatcher, gcpProjectNumberKey)
 		if projectNumber == "" {
 			return fmt.Errorf("missing field %v in NodeMatcher", gcpProjectNumberKey)
 		}
 
 		// Only one of these must be set.
-		networkNameValue := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpNetworkNameKey)
-		meshScopeValue := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpMeshScopeKey)
+		networkNameValue := getValueByKeyFromNodeMatcher(q.nodeMatcher, gcpNetworkNameKey)
+		meshScopeValue := getValueByKeyFromNodeMatcher(q.nodeMatcher, gcpMeshScopeKey)
 		if len(networkNameValue) == 0 && len(meshScopeValue) == 0 {
 			return fmt.Errorf("must set either %v or %v", gcpNetworkNameKey, gcpMeshScopeKey)
 		} else if len(networkNameValue) > 0 && len(meshScopeValue) > 0 {
@@ -82,51 +134,78 @@ func (c *ClientV3) parseNodeMatcher() error {
 		// node.id is expected to be in the format projects/<project_id>/networks/<mesh/network_name>/nodes/<node_id>
 		// for IAM permissions. For CSDS V3 requests node_id part is randomly generated since the users aren't expected
 		// to pass a node_id.
-		c.node.Id = fmt.Sprintf("projects/%s/networks/%s/nodes/%s",projectNumber, meshOrNetworkName, uuid.New())
+		q.node.Id = fmt.Sprintf("projects/%s/networks/%s/nodes/%s", projectNumber, meshOrNetworkName, uuid.New())
 	default:
 		return fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
 	}
+	return nil
+}
+
+// nodeMatchers returns the NodeMatchers of all the queries
+func (c *ClientV3) nodeMatchers() []*envoy_type_matcher_v3.NodeMatcher {
+	var nms []*envoy_type_matcher_v3.NodeMatcher
+	for _, q := range c.queries {
+		nms = append(nms, q.nodeMatcher...)
+	}
+	return nms
+}
 
+// validateOptions checks the options on the output, and pushes the metadata in -filter down to the NodeMatcher
+func (c *ClientV3) validateOptions() error {
 	if c.opts.FilterMode != "" && c.opts.FilterMode != "prefix" && c.opts.FilterMode != "suffix" && c.opts.FilterMode != "regex" {
 		return fmt.Errorf("%s filter mode is not supported, list of supported filter modes: prefix, suffix, regex", c.opts.FilterMode)
 	}
 
+	if c.opts.Filter != "" {
+		filter, err := clientutil.ParseFilter(c.opts.Filter)
+		if err != nil {
+			return err
+		}
+		// the node metadata that the filtered clients must have is also sent to the control plane
+		// in the NodeMatcher, so that the response can be narrowed down on the server side
+		addMetadataToNodeMatcher(c.nodeMatchers(), clientutil.FilterMetadataExacts(filter))
+	}
+
+	if c.opts.Query != "" {
+		if _, err := clientutil.ParseJsonPath(c.opts.Query); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 // connWithAuth connects to uri with authentication
-func (c *ClientV3) connWithAuth() error {
-	var err error
+func (c *ClientV3) connWithAuth(uri string) (*grpc.ClientConn, error) {
 	switch c.opts.AuthnMode {
 	case "jwt":
 		switch c.opts.Platform {
 		case "gcp":
-			c.clientConn, err = clientutil.ConnToGCPWithJwt(c.opts.Jwt, c.opts.Uri)
-			if err != nil {
-				return err
-			}
-			return nil
+			return clientutil.ConnToGCPWithJwt(c.opts.Jwt, uri)
 		default:
-			return fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
+			return nil, fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
 		}
 
 	case "auto":
 		switch c.opts.Platform {
 		case "gcp":
 			// parse GCP project number as header for authentication
-			if projectNum := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpProjectNumberKey); projectNum != "" {
+			if projectNum := getValueByKeyFromNodeMatcher(c.nodeMatchers(), gcpProjectNumberKey); projectNum != "" {
 				c.metadata = metadata.Pairs("x-goog-user-project", projectNum)
 			}
-			c.clientConn, err = clientutil.ConnToGCPWithAuto(c.opts.Uri)
-			if err != nil {
-				return err
-			}
-			return nil
+			return clientutil.ConnToGCPWithAuto(uri)
 		default:
-			return errors.New("auto authentication mode for this platform is not supported. Please use jwt_file instead")
+			return nil, errors.New("auto authentication mode for this platform is not supported. Please use jwt_file instead")
+		}
+	case "none":
+		// connect without TLS nor credentials, e.g. to a local or test server, the project number
+		// header is still sent so that the server can tell the project of the request
+		if projectNum := getValueByKeyFromNodeMatcher(c.nodeMatchers(), gcpProjectNumberKey); projectNum != "" {
+			c.metadata = metadata.Pairs("x-goog-user-project", projectNum)
 		}
+		return clientutil.ConnInsecure(uri)
 	default:
-		return errors.New("invalid authn_mode")
+		return nil, errors.New("invalid authn_mode")
 	}
 }
 
@@ -139,8 +218,33 @@ func New(option client.ClientOptions) (*ClientV3, error) {
 		return nil, fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
 	}
 
-	if err := c.parseNodeMatcher(); err != nil {
-		return nil, err
+	switch c.opts.Source {
+	case "", "csds":
+		if err := c.parseNodeMatcher(); err != nil {
+			return nil, err
+		}
+	case "envoy_admin":
+		// the config of the Envoy is fetched from its admin address, so no request is needed
+		if err := c.validateOptions(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s source is not supported, list of supported sources: csds, envoy_admin", c.opts.Source)
+	}
+
+	if c.opts.HistoryDir != "" {
+		history, err := clientutil.OpenHistoryRecorder(c.opts.HistoryDir, clientutil.RedactedFields(c.opts))
+		if err != nil {
+			return nil, err
+		}
+		c.history = history
+	}
+	if c.opts.HooksFile != "" {
+		hooks, err := clientutil.LoadHooks(c.opts.HooksFile)
+		if err != nil {
+			return nil, err
+		}
+		c.hooks = hooks
 	}
 
 	return c, nil
@@ -148,7 +252,23 @@ func New(option client.ClientOptions) (*ClientV3, error) {
 
 // Run connects the client to the uri and calls doRequest
 func (c *ClientV3) Run() error {
-	if err := c.connWithAuth(); err != nil {
+	if c.history != nil {
+		// release the lock of the history directory
+		defer c.history.Close()
+	}
+	if c.opts.Source == "envoy_admin" {
+		return c.runEnvoyAdmin()
+	}
+
+	uris, err := clientutil.ServiceUris(c.opts)
+	if err != nil {
+		return err
+	}
+	if len(uris) > 1 {
+		return c.runFanOut(uris)
+	}
+
+	if c.clientConn, err = c.connWithAuth(uris[0]); err != nil {
 		return err
 	}
 	defer c.clientConn.Close()
@@ -192,26 +312,67 @@ func (c *ClientV3) Run() error {
 	}
 }
 
-// doRequest sends request and prints out the parsed response
+// request builds the csds request of the query
+func (c *ClientV3) request(q query) *csdspb_v3.ClientStatusRequest {
+	return &csdspb_v3.ClientStatusRequest{NodeMatchers: q.nodeMatcher, Node: &envoy_config_core_v3.Node{Id: q.node.Id}}
+}
+
+// doRequest sends the request of each query and prints out the parsed responses
 func (c *ClientV3) doRequest(streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient) error {
+	responses := make([]*csdspb_v3.ClientStatusResponse, len(c.queries))
+	for i, q := range c.queries {
+		if err := streamClientStatus.Send(c.request(q)); err != nil {
+			return err
+		}
 
-	req := &csdspb_v3.ClientStatusRequest{NodeMatchers: c.nodeMatcher, Node: &envoy_config_core_v3.Node{Id: c.node.Id}}
-	if err := streamClientStatus.Send(req); err != nil {
-		return err
+		resp, err := streamClientStatus.Recv()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		responses[i] = resp
 	}
+	return c.printQueries(responses)
+}
 
-	resp, err := streamClientStatus.Recv()
-	if err != nil && err != io.EOF {
+// printQueries observes the clients of the responses to the queries together, and prints out the
+// response to each query, under the name of the query if the request has more than one
+func (c *ClientV3) printQueries(responses []*csdspb_v3.ClientStatusResponse) error {
+	if err := c.observeResponse(mergeQueries(responses)); err != nil {
 		return err
 	}
-	// post process response
-	if err := printOutResponse(resp, c.opts); err != nil {
-		return err
+	for i, resp := range responses {
+		if len(c.queries) > 1 {
+			fmt.Printf("Query %v:\n", clientutil.QueryLabel(c.queries[i].name))
+		}
+		// post process response
+		if err := printOutResponse(resp, c.opts); err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
+// mergeQueries merges the responses to the queries into one response, in which a client that
+// matches more than one query is only once
+func mergeQueries(responses []*csdspb_v3.ClientStatusResponse) *csdspb_v3.ClientStatusResponse {
+	if len(responses) == 1 {
+		return responses[0]
+	}
+	merged := &csdspb_v3.ClientStatusResponse{}
+	seen := make(map[string]bool)
+	for _, response := range responses {
+		for _, config := range response.GetConfig() {
+			id := config.GetNode().GetId()
+			if seen[id] && id != "" {
+				continue
+			}
+			seen[id] = true
+			merged.Config = append(merged.Config, config)
+		}
+	}
+	return merged
+}
+
 // parseConfigStatus parses each xds config status to string
 func parseConfigStatus(xdsConfig []*csdspb_v3.ClientConfig_GenericXdsConfig) ([]string, error) {
 	var configStatus []string
@@ -239,8 +400,97 @@ func parseConfigStatus(xdsConfig []*csdspb_v3.ClientConfig_GenericXdsConfig) ([]
 	return configStatus, nil
 }
 
+// parseClientConfig parses a client in the response to the api version independent view
+func parseClientConfig(config *csdspb_v3.ClientConfig) clientutil.ClientConfig {
+	c := clientutil.NewClientConfig(config.GetNode())
+	for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+		xds := clientutil.XdsName(genericXdsConfig.GetTypeUrl())
+		if xds == "" {
+			xds = genericXdsConfig.GetTypeUrl()
+		}
+		status := genericXdsConfig.GetConfigStatus().String()
+		c.AddStatus(xds, status)
+		c.Resources = append(c.Resources, clientutil.Resource{
+			Xds:          xds,
+			Name:         genericXdsConfig.GetName(),
+			Version:      genericXdsConfig.GetVersionInfo(),
+			Status:       status,
+			ClientStatus: genericXdsConfig.GetClientStatus().String(),
+			Config:       genericXdsConfig.GetXdsConfig(),
+			Error:        genericXdsConfig.GetErrorState().GetDetails(),
+		})
+	}
+	return c
+}
+
+// matchNodeId checks if the node id matches the node id filter, if there is one
+func matchNodeId(id string, opts client.ClientOptions) (bool, error) {
+	if opts.FilterPattern == "" {
+		return true, nil
+	}
+	return clientutil.FilterNodeId(id, opts.FilterMode, opts.FilterPattern)
+}
+
+// parseClientConfigs parses the clients in the response that match the node id filter
+func parseClientConfigs(response *csdspb_v3.ClientStatusResponse, opts client.ClientOptions) ([]clientutil.ClientConfig, error) {
+	var configs []clientutil.ClientConfig
+	for _, config := range response.GetConfig() {
+		matched, err := matchNodeId(config.GetNode().GetId(), opts)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+		configs = append(configs, parseClientConfig(config))
+	}
+	return configs, nil
+}
+
+// filterResponse returns the response with only the clients that match the filter
+func filterResponse(response *csdspb_v3.ClientStatusResponse, filter clientutil.Filter) *csdspb_v3.ClientStatusResponse {
+	filtered := &csdspb_v3.ClientStatusResponse{}
+	for _, config := range response.GetConfig() {
+		if filter.Match(parseClientConfig(config)) {
+			filtered.Config = append(filtered.Config, config)
+		}
+	}
+	return filtered
+}
+
 // printOutResponse processes response and print
 func printOutResponse(response *csdspb_v3.ClientStatusResponse, opts client.ClientOptions) error {
+	if opts.Filter != "" {
+		filter, err := clientutil.ParseFilter(opts.Filter)
+		if err != nil {
+			return err
+		}
+		response = filterResponse(response, filter)
+	}
+
+	if opts.Query != "" {
+		var configs []proto.Message
+		for _, config := range response.GetConfig() {
+			matched, err := matchNodeId(config.GetNode().GetId(), opts)
+			if err != nil {
+				return err
+			}
+			if matched {
+				configs = append(configs, config)
+			}
+		}
+		return clientutil.PrintQueryResult(configs, opts)
+	}
+
+	if opts.Summary {
+		configs, err := parseClientConfigs(response, opts)
+		if err != nil {
+			return err
+		}
+		clientutil.PrintSummary(clientutil.BuildSummary(configs, opts.GroupByMetadata))
+		return nil
+	}
+
 	if response.GetConfig() == nil || len(response.GetConfig()) == 0 {
 		fmt.Printf("No xDS clients connected.\n")
 		return nil
@@ -310,16 +560,43 @@ func printOutResponse(response *csdspb_v3.ClientStatusResponse, opts client.Clie
 	return nil
 }
 
-// parseYaml is a helper method for parsing csds request yaml to NodeMatchers
-func parseYaml(path string, yamlStr string, nms *[]*envoy_type_matcher_v3.NodeMatcher, node *envoy_config_core_v3.Node) error {
+// requestQueries returns the names of the queries that are sent separately without -request_name,
+// over the requests of -request_file and -request_yaml
+func requestQueries(path string, yamlStr string) ([]string, error) {
+	var requests []map[string]interface{}
 	if path != "" {
-		data, err := clientutil.ParseYamlFileToMap(path)
+		data, err := clientutil.ReadRequestFile(path, requestSchema)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, data)
+	}
+	if yamlStr != "" {
+		data, err := clientutil.ParseRequest([]byte(yamlStr), "-request_yaml", requestSchema)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, data)
+	}
+	return clientutil.RequestQueries(requests...), nil
+}
+
+// parseYaml is a helper method for parsing the query with the name, or the top level without a name,
+// of the csds request yaml to NodeMatchers
+func parseYaml(path string, yamlStr string, name string, nms *[]*envoy_type_matcher_v3.NodeMatcher, node *envoy_config_core_v3.Node) error {
+	found := name == ""
+	var names []string
+	if path != "" {
+		data, err := clientutil.ReadRequestFile(path, requestSchema)
 		if err != nil {
 			return err
 		}
 
-		// parse each json object to proto
-		for _, n := range data["node_matchers"].([]interface{}) {
+		// parse each json object of the selected queries to proto
+		matchers, ok := clientutil.SelectNodeMatchers(data, name)
+		found = found || ok
+		names = append(names, clientutil.QueryNames(data)...)
+		for _, n := range matchers {
 			x := &envoy_type_matcher_v3.NodeMatcher{}
 
 			jsonString, err := json.Marshal(n)
@@ -342,17 +619,20 @@ func parseYaml(path string, yamlStr string, nms *[]*envoy_type_matcher_v3.NodeMa
 			if err = protojson.Unmarshal(jsonString, n); err != nil {
 				return err
 			}
-			*node = *n
+			proto.Merge(node, n)
 		}
 	}
 	if yamlStr != "" {
-		data, err := clientutil.ParseYamlStrToMap(yamlStr)
+		data, err := clientutil.ParseRequest([]byte(yamlStr), "-request_yaml", requestSchema)
 		if err != nil {
 			return err
 		}
 
-		// parse each json object to proto
-		for i, n := range data["node_matchers"].([]interface{}) {
+		// parse each json object of the selected queries to proto
+		matchers, ok := clientutil.SelectNodeMatchers(data, name)
+		found = found || ok
+		names = append(names, clientutil.QueryNames(data)...)
+		for i, n := range matchers {
 			x := &envoy_type_matcher_v3.NodeMatcher{}
 
 			jsonString, err := json.Marshal(n)
@@ -363,7 +643,7 @@ func parseYaml(path string, yamlStr string, nms *[]*envoy_type_matcher_v3.NodeMa
 				return err
 			}
 
-			// merge the proto with existing proto from request_file
+			// merge the proto with existing proto of the same query from request_file
 			if i < len(*nms) {
 				proto.Merge((*nms)[i], x)
 			} else {
@@ -371,6 +651,9 @@ func parseYaml(path string, yamlStr string, nms *[]*envoy_type_matcher_v3.NodeMa
 			}
 		}
 	}
+	if !found {
+		return clientutil.QueryNotFoundError(name, names)
+	}
 	return nil
 }
 
@@ -387,3 +670,64 @@ func getValueByKeyFromNodeMatcher(nms []*envoy_type_matcher_v3.NodeMatcher, key
 	}
 	return ""
 }
+
+// applyNodeMatcher merges the NodeMatcher of the flags into each NodeMatcher of the request, or
+// returns it as the only NodeMatcher if the request has none. Its node id and metadata matchers
+// replace the matchers of the request on the same node id and metadata keys.
+func applyNodeMatcher(nms []*envoy_type_matcher_v3.NodeMatcher, m *envoy_type_matcher_v3.NodeMatcher) []*envoy_type_matcher_v3.NodeMatcher {
+	if len(nms) == 0 {
+		return []*envoy_type_matcher_v3.NodeMatcher{m}
+	}
+	keys := make(map[string]bool)
+	for _, mt := range m.NodeMetadatas {
+		for _, path := range mt.Path {
+			keys[path.GetKey()] = true
+		}
+	}
+	for _, nm := range nms {
+		if m.NodeId != nil {
+			nm.NodeId = proto.Clone(m.NodeId).(*envoy_type_matcher_v3.StringMatcher)
+		}
+		var metadatas []*envoy_type_matcher_v3.StructMatcher
+		for _, mt := range nm.NodeMetadatas {
+			if len(mt.Path) != 1 || !keys[mt.Path[0].GetKey()] {
+				metadatas = append(metadatas, mt)
+			}
+		}
+		for _, mt := range m.NodeMetadatas {
+			metadatas = append(metadatas, proto.Clone(mt).(*envoy_type_matcher_v3.StructMatcher))
+		}
+		nm.NodeMetadatas = metadatas
+	}
+	return nms
+}
+
+// addMetadataToNodeMatcher adds exact matchers on the node metadata to each NodeMatcher, unless the
+// NodeMatcher already matches the metadata key
+func addMetadataToNodeMatcher(nms []*envoy_type_matcher_v3.NodeMatcher, md map[string]string) {
+	var keys []string
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, nm := range nms {
+		for _, key := range keys {
+			if getValueByKeyFromNodeMatcher([]*envoy_type_matcher_v3.NodeMatcher{nm}, key) != "" {
+				continue
+			}
+			nm.NodeMetadatas = append(nm.NodeMetadatas, &envoy_type_matcher_v3.StructMatcher{
+				Path: []*envoy_type_matcher_v3.StructMatcher_PathSegment{
+					{Segment: &envoy_type_matcher_v3.StructMatcher_PathSegment_Key{Key: key}},
+				},
+				Value: &envoy_type_matcher_v3.ValueMatcher{
+					MatchPattern: &envoy_type_matcher_v3.ValueMatcher_StringMatch{
+						StringMatch: &envoy_type_matcher_v3.StringMatcher{
+							MatchPattern: &envoy_type_matcher_v3.StringMatcher_Exact{Exact: md[key]},
+						},
+					},
+				},
+			})
+		}
+	}
+}
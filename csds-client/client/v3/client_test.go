@@ -2,16 +2,39 @@
 package client
 
 import (
+	"context"
+	"encoding/json"
 	"envoy-tools/csds-client/client"
 	clientUtil "envoy-tools/csds-client/client/util"
+	"envoy-tools/csds-client/fake"
+	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"path/filepath"
-	"testing"
+	"reflect"
+	"sort"
 	"strings"
+	"testing"
+	"time"
 
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoy_extensions_filters_network_http_connection_manager_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	csdspb_v2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
 	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
-	"google.golang.org/protobuf/encoding/protojson"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
 	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 // TestParseNodeMatcherWithFile tests parsing -request_file to nodematcher.
@@ -25,11 +48,11 @@ func TestParseNodeMatcherWithFile(t *testing.T) {
 	if err := c.parseNodeMatcher(); err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
-	if c.nodeMatcher == nil {
+	if c.queries[0].nodeMatcher == nil {
 		t.Errorf("Parse NodeMatcher Failure!")
 	}
 	want := "{\"nodeId\":{\"exact\":\"fake_node_id\"},\"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}},{\"path\":[{\"key\":\"TRAFFICDIRECTOR_NETWORK_NAME\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_network_name\"}}}]}"
-	get, err := protojson.Marshal(c.nodeMatcher[0])
+	get, err := protojson.Marshal(c.queries[0].nodeMatcher[0])
 	if err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
@@ -39,7 +62,7 @@ func TestParseNodeMatcherWithFile(t *testing.T) {
 	}
 
 	wantNodeIdPrefix := "projects/fake_project_number/networks/fake_network_name/nodes"
-	nodeSlice := strings.Split(c.node.Id, "/")
+	nodeSlice := strings.Split(c.queries[0].node.Id, "/")
 	gotNodeIdPrefix := strings.Join(nodeSlice[:len(nodeSlice)-1], "/")
 	if wantNodeIdPrefix != gotNodeIdPrefix {
 		t.Errorf("node.id prefix = %v, want = %v", gotNodeIdPrefix, wantNodeIdPrefix)
@@ -62,11 +85,11 @@ func TestParseNodeMatcherWithString(t *testing.T) {
 	if err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
-	if c.nodeMatcher == nil {
+	if c.queries[0].nodeMatcher == nil {
 		t.Errorf("Parse NodeMatcher Failure!")
 	}
 	want := "{\"nodeId\":{\"exact\":\"fake_node_id\"}, \"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}], \"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}}, {\"path\":[{\"key\":\"TRAFFICDIRECTOR_NETWORK_NAME\"}], \"value\":{\"stringMatch\":{\"exact\":\"fake_network_name\"}}}]}"
-	get, err := protojson.Marshal(c.nodeMatcher[0])
+	get, err := protojson.Marshal(c.queries[0].nodeMatcher[0])
 	if err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
@@ -87,11 +110,11 @@ func TestParseNodeMatcherWithFileAndString(t *testing.T) {
 	if err := c.parseNodeMatcher(); err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
-	if c.nodeMatcher == nil {
+	if c.queries[0].nodeMatcher == nil {
 		t.Errorf("Parse NodeMatcher Failure!")
 	}
 	want := "{\"nodeId\":{\"exact\":\"fake_node_id_from_cli\"}, \"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}], \"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}}, {\"path\":[{\"key\":\"TRAFFICDIRECTOR_NETWORK_NAME\"}], \"value\":{\"stringMatch\":{\"exact\":\"fake_network_name\"}}}]}"
-	get, err := protojson.Marshal(c.nodeMatcher[0])
+	get, err := protojson.Marshal(c.queries[0].nodeMatcher[0])
 	if err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
@@ -100,6 +123,160 @@ func TestParseNodeMatcherWithFileAndString(t *testing.T) {
 	}
 }
 
+// TestParseNodeMatcherWithFlags tests building the NodeMatcher from the flags, alone and merged
+// into the request in -request_file
+func TestParseNodeMatcherWithFlags(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:   "gcp",
+			NodeId:     "fake_node_id",
+			Metadata:   []string{"zone=us-east1-b"},
+			GcpProject: "fake_project_number",
+			GcpNetwork: "fake_network_name",
+		},
+	}
+	if err := c.parseNodeMatcher(); err != nil {
+		t.Fatalf("Parse NodeMatcher Error: %v", err)
+	}
+	want := "{\"nodeId\":{\"exact\":\"fake_node_id\"},\"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}},{\"path\":[{\"key\":\"TRAFFICDIRECTOR_NETWORK_NAME\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_network_name\"}}},{\"path\":[{\"key\":\"zone\"}],\"value\":{\"stringMatch\":{\"exact\":\"us-east1-b\"}}}]}"
+	get, err := protojson.Marshal(c.queries[0].nodeMatcher[0])
+	if err != nil {
+		t.Errorf("Parse NodeMatcher Error: %v", err)
+	}
+	if len(c.queries[0].nodeMatcher) != 1 || !clientUtil.ShouldEqualJSON(t, string(get), want) {
+		t.Errorf("NodeMatcher = \n%v\n, want: \n%v\n", string(get), want)
+	}
+
+	// the flags replace the node id and the network of the request file
+	c = ClientV3{
+		opts: client.ClientOptions{
+			Platform:     "gcp",
+			RequestFile:  "./test_request.yaml",
+			NodeIdPrefix: "fake_",
+			GcpNetwork:   "other_network_name",
+		},
+	}
+	if err := c.parseNodeMatcher(); err != nil {
+		t.Fatalf("Parse NodeMatcher Error: %v", err)
+	}
+	want = "{\"nodeId\":{\"prefix\":\"fake_\"},\"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}},{\"path\":[{\"key\":\"TRAFFICDIRECTOR_NETWORK_NAME\"}],\"value\":{\"stringMatch\":{\"exact\":\"other_network_name\"}}}]}"
+	if get, err = protojson.Marshal(c.queries[0].nodeMatcher[0]); err != nil {
+		t.Errorf("Parse NodeMatcher Error: %v", err)
+	}
+	if !clientUtil.ShouldEqualJSON(t, string(get), want) {
+		t.Errorf("NodeMatcher = \n%v\n, want: \n%v\n", string(get), want)
+	}
+
+	for _, opts := range []client.ClientOptions{
+		{Platform: "gcp", NodeId: "fake_node_id", NodeIdPrefix: "fake_"},
+		{Platform: "gcp", GcpProject: "fake_project_number", Metadata: []string{"zone"}},
+		{Platform: "gcp", GcpProject: "fake_project_number", GcpNetwork: "fake_network_name", GcpMesh: "fake_mesh"},
+	} {
+		c := ClientV3{opts: opts}
+		if err := c.parseNodeMatcher(); err == nil {
+			t.Errorf("want an error on %+v", opts)
+		}
+	}
+}
+
+// TestParseNodeMatcherWithQueries tests the named queries of a request file with templating
+func TestParseNodeMatcherWithQueries(t *testing.T) {
+	os.Setenv("CSDS_TEST_PROJECT", "fake_project_number")
+	os.Setenv("CSDS_TEST_NETWORK", "fake_network_name")
+	defer os.Unsetenv("CSDS_TEST_PROJECT")
+	defer os.Unsetenv("CSDS_TEST_NETWORK")
+
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:    "gcp",
+			RequestFile: "./test_request_queries.yaml",
+			RequestName: "staging",
+		},
+	}
+	if err := c.parseNodeMatcher(); err != nil {
+		t.Fatalf("Parse NodeMatcher Error: %v", err)
+	}
+	want := "{\"nodeId\":{\"prefix\":\"staging_\"},\"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}},{\"path\":[{\"key\":\"TRAFFICDIRECTOR_NETWORK_NAME\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_network_name\"}}}]}"
+	get, err := protojson.Marshal(c.queries[0].nodeMatcher[0])
+	if err != nil {
+		t.Errorf("Parse NodeMatcher Error: %v", err)
+	}
+	if len(c.queries[0].nodeMatcher) != 1 || !clientUtil.ShouldEqualJSON(t, string(get), want) {
+		t.Errorf("NodeMatcher = \n%v\n, want: \n%v\n", string(get), want)
+	}
+
+	// each query is sent separately without -request_name, and -request_yaml is merged by query
+	c.opts.RequestName = ""
+	c.opts.RequestYaml = "queries:\n  staging:\n    node_matchers:\n    - node_id:\n        prefix: staging_2_"
+	if err := c.parseNodeMatcher(); err != nil {
+		t.Fatalf("Parse NodeMatcher Error: %v", err)
+	}
+	if len(c.queries) != 2 || c.queries[0].name != "prod" || c.queries[1].name != "staging" {
+		t.Fatalf("Queries = %v, want the queries prod and staging", c.queries)
+	}
+	for i, prefix := range []string{"prod_", "staging_2_"} {
+		q := c.queries[i]
+		if len(q.nodeMatcher) != 1 || q.nodeMatcher[0].GetNodeId().GetPrefix() != prefix || len(q.nodeMatcher[0].GetNodeMetadatas()) != 2 {
+			t.Errorf("NodeMatcher of query %v = %v, want the prefix %v and the metadata of the file", q.name, q.nodeMatcher, prefix)
+		}
+		if !strings.HasPrefix(q.node.GetId(), "projects/fake_project_number/networks/fake_network_name/nodes/") {
+			t.Errorf("Node id of query %v = %v, want the project and the network of the query", q.name, q.node.GetId())
+		}
+	}
+	c.opts.RequestYaml = ""
+
+	c.opts.RequestName = "stagign"
+	if err := c.parseNodeMatcher(); err == nil || err.Error() != "query stagign not found, did you mean staging?" {
+		t.Errorf("Parse NodeMatcher Error = %v, want query stagign not found", err)
+	}
+
+	os.Unsetenv("CSDS_TEST_PROJECT")
+	want = `./test_request_queries.yaml:12:24: environment variables not set: CSDS_TEST_PROJECT
+./test_request_queries.yaml:27:24: environment variables not set: CSDS_TEST_PROJECT`
+	if err := ValidateRequestFile("./test_request_queries.yaml"); err == nil || err.Error() != want {
+		t.Errorf("Validation Error = \n%v\n, want: \n%v\n", err, want)
+	}
+	c.opts.RequestFile = ""
+	c.opts.RequestYaml = "node_matchers:\n- node_id:\n    exact: {{ .Env.CSDS_TEST_NETWORK }}"
+	if err := c.parseNodeMatcher(); err == nil || err.Error() != "-request_yaml:3:12: a value that starts with {{ must be quoted" {
+		t.Errorf("Parse NodeMatcher Error = %v, want the template to be quoted", err)
+	}
+}
+
+// TestValidateRequest tests that the request yaml is validated with the positions of the problems
+func TestValidateRequest(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			RequestYaml: "node_matcher:\n- node_id:\n    exct: fake_node_id\nnode_matchers:\n- node_id:\n    exct: fake_node_id\n  node_metadatas:\n  - path: TRAFFICDIRECTOR_NETWORK_NAME\nnode:\n  locality:\n    zone: [us-east1-b]",
+		},
+	}
+	err := c.parseNodeMatcher()
+	if err == nil {
+		t.Fatal("Parse NodeMatcher Error: want an error for the invalid request")
+	}
+	want := `-request_yaml:1:1: unknown field "node_matcher" in the request, did you mean "node_matchers"?
+-request_yaml:6:5: unknown field "exct" in node_matchers[0].node_id (StringMatcher), did you mean "exact"?
+-request_yaml:8:11: node_matchers[0].node_metadatas[0].path: expected a list, got "TRAFFICDIRECTOR_NETWORK_NAME"
+-request_yaml:11:11: node.locality.zone: expected a string, got a list`
+	if err.Error() != want {
+		t.Errorf("Validation Error = \n%v\n, want: \n%v\n", err, want)
+	}
+
+	// a request without node_matchers is valid
+	var nms []*envoy_type_matcher_v3.NodeMatcher
+	node := &envoy_config_core_v3.Node{}
+	if err := parseYaml("", "node:\n  id: fake_node_id", "", &nms, node); err != nil {
+		t.Errorf("Parse Yaml Error: %v", err)
+	}
+	if len(nms) != 0 {
+		t.Errorf("NodeMatcher = %v, want no NodeMatcher", nms)
+	}
+
+	if err := ValidateRequestFile("./test_request.yaml"); err != nil {
+		t.Errorf("Validation Error: %v", err)
+	}
+}
+
 // TestParseResponseWithoutNodeId tests post processing response without node_id.
 func TestParseResponseWithoutNodeId(t *testing.T) {
 	c := ClientV3{
@@ -316,11 +493,11 @@ func TestParseNodeMatcherMeshScopeKeyWithFile(t *testing.T) {
 	if err := c.parseNodeMatcher(); err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
-	if c.nodeMatcher == nil {
+	if c.queries[0].nodeMatcher == nil {
 		t.Errorf("Parse NodeMatcher Failure!")
 	}
 	want := "{\"nodeId\":{\"exact\":\"fake_node_id\"},\"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}},{\"path\":[{\"key\":\"TRAFFICDIRECTOR_MESH_SCOPE_NAME\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_mesh_scope_name\"}}}]}"
-	get, err := protojson.Marshal(c.nodeMatcher[0])
+	get, err := protojson.Marshal(c.queries[0].nodeMatcher[0])
 	if err != nil {
 		t.Errorf("Parse NodeMatcher Error: %v", err)
 	}
@@ -341,3 +518,984 @@ func TestParseNodeMatcherMeshScopeAndNetworkShouldFail(t *testing.T) {
 		t.Errorf("Parse NodeMatcher should fail since network name and meshScope are provided.")
 	}
 }
+
+// TestParseNodeMatcherWithFilter tests sending the node metadata in the filter in the NodeMatcher,
+// except for the values that may be of another type than string, e.g. 1.9 and true
+func TestParseNodeMatcherWithFilter(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:    "gcp",
+			RequestFile: "./test_request.yaml",
+			Filter:      "metadata.TRAFFICDIRECTOR_NETWORK_NAME=other_network_name and metadata.INSTANCE_IP=10.0.0.1 and metadata.ISTIO_VERSION=1.9 and metadata.ENABLED=true and CDS=STALE",
+		},
+	}
+	if err := c.parseNodeMatcher(); err != nil {
+		t.Errorf("Parse NodeMatcher Error: %v", err)
+	}
+	want := "{\"nodeId\":{\"exact\":\"fake_node_id\"},\"nodeMetadatas\":[{\"path\":[{\"key\":\"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_project_number\"}}},{\"path\":[{\"key\":\"TRAFFICDIRECTOR_NETWORK_NAME\"}],\"value\":{\"stringMatch\":{\"exact\":\"fake_network_name\"}}},{\"path\":[{\"key\":\"INSTANCE_IP\"}],\"value\":{\"stringMatch\":{\"exact\":\"10.0.0.1\"}}}]}"
+	get, err := protojson.Marshal(c.queries[0].nodeMatcher[0])
+	if err != nil {
+		t.Errorf("Parse NodeMatcher Error: %v", err)
+	}
+	if !clientUtil.ShouldEqualJSON(t, string(get), want) {
+		t.Errorf("NodeMatcher = \n%v\n, want: \n%v\n", string(get), want)
+	}
+}
+
+// TestMergeResponses tests merging the responses of several control planes, and of a single one
+func TestMergeResponses(t *testing.T) {
+	responses := make([]*csdspb_v3.ClientStatusResponse, 2)
+	for i, js := range []string{
+		`{"config": [{"node": {"id": "test_node_1"}}, {"node": {"id": "test_node_2", "metadata": {"XDS_STREAM_TYPE": "ADS"}}}]}`,
+		`{"config": [{"node": {"id": "test_node_2"}}, {"node": {"id": "test_node_3"}}]}`,
+	} {
+		responses[i] = &csdspb_v3.ClientStatusResponse{}
+		if err := protojson.Unmarshal([]byte(js), responses[i]); err != nil {
+			t.Fatalf("Parse response error: %v", err)
+		}
+	}
+
+	merged, duplicates := mergeResponses([]string{"cp-b:443", "cp-a:443"}, responses)
+	want := `{"config": [
+		{"node": {"id": "test_node_1", "metadata": {"CSDS_CONTROL_PLANE": "cp-b:443"}}},
+		{"node": {"id": "test_node_2", "metadata": {"XDS_STREAM_TYPE": "ADS", "CSDS_CONTROL_PLANE": "cp-a:443,cp-b:443"}}},
+		{"node": {"id": "test_node_3", "metadata": {"CSDS_CONTROL_PLANE": "cp-a:443"}}}
+	]}`
+	get, err := protojson.Marshal(merged)
+	if err != nil {
+		t.Errorf("Marshal response error: %v", err)
+	}
+	if !clientUtil.ShouldEqualJSON(t, string(get), want) {
+		t.Errorf("merged response = \n%v\n, want: \n%v\n", string(get), want)
+	}
+	if len(duplicates) != 1 || duplicates["test_node_2"] != "cp-a:443,cp-b:443" {
+		t.Errorf("duplicates = %v, want test_node_2 reported by cp-a:443,cp-b:443", duplicates)
+	}
+
+	// the responses of a single control plane, e.g. to several queries, are not tagged
+	for i, js := range []string{
+		`{"config": [{"node": {"id": "test_node_1"}}, {"node": {"id": "test_node_2"}}]}`,
+		`{"config": [{"node": {"id": "test_node_2"}}]}`,
+	} {
+		responses[i] = &csdspb_v3.ClientStatusResponse{}
+		if err := protojson.Unmarshal([]byte(js), responses[i]); err != nil {
+			t.Fatalf("Parse response error: %v", err)
+		}
+	}
+	merged, duplicates = mergeResponses([]string{"cp-a:443", "cp-a:443"}, responses)
+	want = `{"config": [{"node": {"id": "test_node_1"}}, {"node": {"id": "test_node_2"}}]}`
+	if get, err = protojson.Marshal(merged); err != nil {
+		t.Errorf("Marshal response error: %v", err)
+	}
+	if !clientUtil.ShouldEqualJSON(t, string(get), want) || len(duplicates) != 0 {
+		t.Errorf("merged response = \n%v\n, duplicates = %v, want: \n%v\n", string(get), duplicates, want)
+	}
+
+	uris, err := clientUtil.ServiceUris(client.ClientOptions{Uri: "cp-a:443, cp-b:443,,cp-a:443"})
+	if err != nil {
+		t.Errorf("Parse service uris error: %v", err)
+	}
+	if strings.Join(uris, ",") != "cp-a:443,cp-b:443" {
+		t.Errorf("service uris = %v, want [cp-a:443 cp-b:443]", uris)
+	}
+}
+
+// TestEnvoyAdminSource tests getting the config of an Envoy from the config dump of its admin
+func TestEnvoyAdminSource(t *testing.T) {
+	filename, _ := filepath.Abs("./config_dump_for_admin.json")
+	configDump, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config_dump" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(configDump)
+	}))
+	defer server.Close()
+
+	c, err := New(client.ClientOptions{
+		Platform: "gcp",
+		Source:   "envoy_admin",
+		AdminUri: strings.TrimPrefix(server.URL, "http://"),
+		Summary:  true,
+	})
+	if err != nil {
+		t.Fatalf("Create client error: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	})
+	want := `Total clients: 1
+
+xDS        Config Status        Clients    
+CDS        STALE                1          
+CDS        SYNCED               1          
+LDS        SYNCED               1          
+RDS        ERROR                1          
+
+xDS stream type                Clients    
+N/A                            1          
+
+xDS        Resource                                           Version                        Clients    
+CDS        fake_cluster                                       fake_cluster_version1          1 (100.0%) 
+CDS        warming_cluster                                    fake_cluster_version2          1 (100.0%) 
+CDS        xds_cluster                                                                       1 (100.0%) 
+LDS        fake_listener                                      fake_listener_version1         1 (100.0%) 
+RDS        fake_route                                         fake_route_version1            1 (100.0%) 
+
+Resources with version skew: 0
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	response, err := configDumpToResponse(configDump)
+	if err != nil {
+		t.Fatalf("Convert config dump error: %v", err)
+	}
+	if got := response.GetConfig()[0].GetNode().GetId(); got != "fake_envoy" {
+		t.Errorf("want node id fake_envoy, got %v", got)
+	}
+	js, err := protojson.MarshalOptions{Resolver: &clientUtil.AdminTypeResolver{}}.Marshal(response)
+	if err != nil {
+		t.Fatalf("Marshal response error: %v", err)
+	}
+	graphData, err := clientUtil.ParseXdsRelationship(js)
+	if err != nil {
+		t.Fatalf("Parse xDS relationship error: %v", err)
+	}
+	dot, err := clientUtil.GenerateGraph(graphData)
+	if err != nil {
+		t.Fatalf("Generate graph error: %v", err)
+	}
+	for _, edge := range []string{`"fake_listener"->"fake_route"`, `"fake_route"->"fake_cluster"`} {
+		if !strings.Contains(dot, edge) {
+			t.Errorf("want edge %v in graph\n%v", edge, dot)
+		}
+	}
+
+	// a listener that is rejected before any of its updates is applied is reported with its error
+	response, err = configDumpToResponse([]byte(`{"configs": [{
+		"@type": "type.googleapis.com/envoy.admin.v3.ListenersConfigDump",
+		"dynamic_listeners": [{
+			"name": "rejected_listener",
+			"client_status": "NACKED",
+			"error_state": {"version_info": "v2", "details": "duplicate listener address"}
+		}]
+	}]}`))
+	if err != nil {
+		t.Fatalf("Convert config dump error: %v", err)
+	}
+	wantResources := []clientUtil.Resource{{Xds: "LDS", Name: "rejected_listener", Status: "ERROR", ClientStatus: "NACKED", Error: "duplicate listener address"}}
+	if got := parseClientConfig(response.GetConfig()[0]).Resources; !reflect.DeepEqual(got, wantResources) {
+		t.Errorf("Resources = %+v, want %+v", got, wantResources)
+	}
+}
+
+// TestVerify tests comparing the resources reported by the control plane with the config dump
+func TestVerify(t *testing.T) {
+	filename, _ := filepath.Abs("./config_dump_for_admin.json")
+	configDump, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	response, err := configDumpToResponse(configDump)
+	if err != nil {
+		t.Fatalf("Convert config dump error: %v", err)
+	}
+	proxy := parseClientConfig(response.GetConfig()[0])
+
+	// the control plane has a newer version of the listener, a different route with the same
+	// version, does not have the warming cluster and has a cluster that the proxy does not have.
+	// The static cluster of the proxy is not reported by the control plane.
+	controlPlaneConfig := proto.Clone(response.GetConfig()[0]).(*csdspb_v3.ClientConfig)
+	var genericXdsConfigs []*csdspb_v3.ClientConfig_GenericXdsConfig
+	for _, genericXdsConfig := range controlPlaneConfig.GetGenericXdsConfigs() {
+		switch genericXdsConfig.GetName() {
+		case "fake_listener":
+			genericXdsConfig.VersionInfo = "fake_listener_version2"
+		case "fake_route":
+			route := &envoy_config_route_v3.RouteConfiguration{}
+			if err := genericXdsConfig.GetXdsConfig().UnmarshalTo(route); err != nil {
+				t.Fatalf("Unmarshal route error: %v", err)
+			}
+			route.VirtualHosts[0].Domains = []string{"example.com"}
+			genericXdsConfig.XdsConfig, _ = anypb.New(route)
+		case "warming_cluster", "xds_cluster":
+			continue
+		case "fake_cluster":
+			genericXdsConfigs = append(genericXdsConfigs, &csdspb_v3.ClientConfig_GenericXdsConfig{
+				TypeUrl:     genericXdsConfig.GetTypeUrl(),
+				Name:        "other_cluster",
+				VersionInfo: "fake_cluster_version1",
+			})
+		}
+		genericXdsConfigs = append(genericXdsConfigs, genericXdsConfig)
+	}
+	controlPlaneConfig.GenericXdsConfigs = genericXdsConfigs
+
+	divergences, compared := clientUtil.VerifyResources(parseClientConfig(controlPlaneConfig), proxy)
+	if compared != 5 {
+		t.Errorf("want 5 resources compared, got %v", compared)
+	}
+	want := []string{
+		"CDS other_cluster missing in proxy",
+		"CDS warming_cluster missing in control plane",
+		"LDS fake_listener version mismatch",
+		"RDS fake_route content mismatch",
+	}
+	var got []string
+	for _, d := range divergences {
+		got = append(got, d.Xds+" "+d.Name+" "+d.Reason)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want\n%v\ngot\n%v", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	controlPlane := parseClientConfig(controlPlaneConfig)
+	if divergences, _ := clientUtil.VerifyResources(controlPlane, controlPlane); len(divergences) != 0 {
+		t.Errorf("want no divergences for the same config, got %v", divergences)
+	}
+
+	// the typed configs nested in the resources are compared by content, not by their encoding
+	rds, _ := proto.Marshal(&envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager{
+		RouteSpecifier: &envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager_Rds{
+			Rds: &envoy_extensions_filters_network_http_connection_manager_v3.Rds{RouteConfigName: "fake_route"},
+		},
+	})
+	statPrefix, _ := proto.Marshal(&envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager{StatPrefix: "ingress"})
+	listener := func(hcm []byte) *anypb.Any {
+		config, _ := anypb.New(&envoy_config_listener_v3.Listener{
+			Name: "fake_listener",
+			FilterChains: []*envoy_config_listener_v3.FilterChain{{
+				Filters: []*envoy_config_listener_v3.Filter{{
+					Name: "envoy.filters.network.http_connection_manager",
+					ConfigType: &envoy_config_listener_v3.Filter_TypedConfig{TypedConfig: &anypb.Any{
+						TypeUrl: "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+						Value:   hcm,
+					}},
+				}},
+			}},
+		})
+		return config
+	}
+	if left, right := clientUtil.ResourceHash(listener(append(rds, statPrefix...))), clientUtil.ResourceHash(listener(append(statPrefix, rds...))); left == "" || left != right {
+		t.Errorf("want the same hash for the same listener, got %v and %v", left, right)
+	}
+}
+
+// readResponse reads a ClientStatusResponse from a json file
+func readResponse(t *testing.T, path string) *csdspb_v3.ClientStatusResponse {
+	filename, _ := filepath.Abs(path)
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err = protojson.Unmarshal(responsejson, response); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	return response
+}
+
+// TestRunWithFakeServer tests running the client end to end against a fake CSDS server, which
+// first fails the stream with an RpcSecurityPolicy error so that the client reconnects
+func TestRunWithFakeServer(t *testing.T) {
+	server, err := fake.NewServer(
+		fake.Step{Err: status.Error(codes.PermissionDenied, "RpcSecurityPolicy check failed")},
+		fake.Step{Response: readResponse(t, "./response_for_summary.json"), Delay: 10 * time.Millisecond},
+	)
+	if err != nil {
+		t.Fatalf("Start fake server error: %v", err)
+	}
+	defer server.Stop()
+
+	c, err := New(client.ClientOptions{
+		Uri:         server.Addr(),
+		Platform:    "gcp",
+		AuthnMode:   "none",
+		RequestFile: "./test_request.yaml",
+		Summary:     true,
+	})
+	if err != nil {
+		t.Fatalf("Create client error: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	})
+	if !strings.HasPrefix(out, "Total clients: 3\n") {
+		t.Errorf("want the summary of 3 clients, got\n%v", out)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("want 2 requests, got %v", len(requests))
+	}
+	for _, request := range requests {
+		if got := request.Metadata.Get("x-goog-user-project"); len(got) != 1 || got[0] != "fake_project_number" {
+			t.Errorf("want x-goog-user-project fake_project_number, got %v", got)
+		}
+		r, ok := request.Request.(*csdspb_v3.ClientStatusRequest)
+		if !ok {
+			t.Fatalf("want a v3 request, got %T", request.Request)
+		}
+		if got := r.GetNodeMatchers()[0].GetNodeId().GetExact(); got != "fake_node_id" {
+			t.Errorf("want node matcher on fake_node_id, got %v", got)
+		}
+	}
+}
+
+// TestRunMonitorWithFakeServer tests the monitor mode of the client end to end against a fake CSDS
+// server, which replies with a different response on each request and then fails the stream so
+// that the client stops
+func TestRunMonitorWithFakeServer(t *testing.T) {
+	response := readResponse(t, "./response_for_summary.json")
+	server, err := fake.NewServer(
+		fake.Step{Response: &csdspb_v3.ClientStatusResponse{Config: response.GetConfig()[:1]}},
+		fake.Step{Response: response},
+		fake.Step{Err: status.Error(codes.Unavailable, "fake server is stopping")},
+	)
+	if err != nil {
+		t.Fatalf("Start fake server error: %v", err)
+	}
+	defer server.Stop()
+
+	c, err := New(client.ClientOptions{
+		Uri:             server.Addr(),
+		Platform:        "gcp",
+		AuthnMode:       "none",
+		RequestFile:     "./test_request.yaml",
+		Summary:         true,
+		MonitorInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Create client error: %v", err)
+	}
+	var runErr error
+	out := clientUtil.CaptureOutput(func() {
+		runErr = c.Run()
+	})
+	if status.Code(runErr) != codes.Unavailable {
+		t.Errorf("Run error = %v, want the error of the last step", runErr)
+	}
+	first, second := strings.Index(out, "Total clients: 1\n"), strings.Index(out, "Total clients: 3\n")
+	if first == -1 || second == -1 || first > second {
+		t.Errorf("want the summary of 1 client and then of 3 clients, got\n%v", out)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("want 3 requests, got %v", len(requests))
+	}
+	// the node id is the same on every request of the stream
+	id := requests[0].Request.(*csdspb_v3.ClientStatusRequest).GetNode().GetId()
+	for _, request := range requests[1:] {
+		if got := request.Request.(*csdspb_v3.ClientStatusRequest).GetNode().GetId(); got != id {
+			t.Errorf("want the node id %v on every request, got %v", id, got)
+		}
+	}
+}
+
+// TestRunQueriesWithFakeServer tests that the queries of a request are sent separately, and that
+// their responses are printed out under their names
+func TestRunQueriesWithFakeServer(t *testing.T) {
+	os.Setenv("CSDS_TEST_PROJECT", "fake_project_number")
+	os.Setenv("CSDS_TEST_NETWORK", "fake_network_name")
+	defer os.Unsetenv("CSDS_TEST_PROJECT")
+	defer os.Unsetenv("CSDS_TEST_NETWORK")
+	server, err := fake.NewServer(fake.Step{Response: readResponse(t, "./response_for_summary.json")})
+	if err != nil {
+		t.Fatalf("Start fake server error: %v", err)
+	}
+	defer server.Stop()
+
+	c, err := New(client.ClientOptions{
+		Uri:         server.Addr(),
+		Platform:    "gcp",
+		AuthnMode:   "none",
+		RequestFile: "./test_request_queries.yaml",
+		Summary:     true,
+	})
+	if err != nil {
+		t.Fatalf("Create client error: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	})
+	if !strings.HasPrefix(out, "Query prod:\nTotal clients: 3\n") || !strings.Contains(out, "Query staging:\nTotal clients: 3\n") {
+		t.Errorf("want the summary of each query, got\n%v", out)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("want 2 requests, got %v", len(requests))
+	}
+	for i, prefix := range []string{"prod_", "staging_"} {
+		r := requests[i].Request.(*csdspb_v3.ClientStatusRequest)
+		if len(r.GetNodeMatchers()) != 1 || r.GetNodeMatchers()[0].GetNodeId().GetPrefix() != prefix {
+			t.Errorf("want the node matcher of the query on %v, got %v", prefix, r.GetNodeMatchers())
+		}
+	}
+}
+
+// TestRunFanOutWithFakeServers tests fanning out the request to several fake CSDS servers, of
+// which one fails
+func TestRunFanOutWithFakeServers(t *testing.T) {
+	response := readResponse(t, "./response_for_summary.json")
+	var uris []string
+	for _, step := range []fake.Step{
+		{Response: &csdspb_v3.ClientStatusResponse{Config: response.GetConfig()[:2]}},
+		{Response: &csdspb_v3.ClientStatusResponse{Config: response.GetConfig()[1:]}},
+		{Err: status.Error(codes.Unavailable, "fake error")},
+	} {
+		server, err := fake.NewServer(step)
+		if err != nil {
+			t.Fatalf("Start fake server error: %v", err)
+		}
+		defer server.Stop()
+		uris = append(uris, server.Addr())
+	}
+
+	c, err := New(client.ClientOptions{
+		Uri:         strings.Join(uris, ","),
+		Platform:    "gcp",
+		AuthnMode:   "none",
+		RequestFile: "./test_request.yaml",
+		Summary:     true,
+	})
+	if err != nil {
+		t.Fatalf("Create client error: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	})
+	if !strings.Contains(out, "is reported by more than one control plane") {
+		t.Errorf("want a warning on the duplicate client, got\n%v", out)
+	}
+	if !strings.Contains(out, "Total clients: 3\n") {
+		t.Errorf("want the summary of 3 clients, got\n%v", out)
+	}
+}
+
+// TestRunFanOutFilterByControlPlane tests filtering the clients by the control plane that reported
+// them, against fake CSDS servers that apply the NodeMatchers of the request
+func TestRunFanOutFilterByControlPlane(t *testing.T) {
+	var uris []string
+	for _, ids := range [][]string{{"test_node_1", "test_node_2"}, {"test_node_3"}} {
+		response := &csdspb_v3.ClientStatusResponse{}
+		for _, id := range ids {
+			md, err := structpb.NewStruct(map[string]interface{}{
+				"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER": "fake_project_number",
+				"TRAFFICDIRECTOR_NETWORK_NAME":       "fake_network_name",
+			})
+			if err != nil {
+				t.Fatalf("Create metadata error: %v", err)
+			}
+			response.Config = append(response.Config, &csdspb_v3.ClientConfig{Node: &envoy_config_core_v3.Node{Id: id, Metadata: md}})
+		}
+		server, err := fake.NewServer(fake.Step{Response: response, ApplyNodeMatchers: true})
+		if err != nil {
+			t.Fatalf("Start fake server error: %v", err)
+		}
+		defer server.Stop()
+		uris = append(uris, server.Addr())
+	}
+
+	c, err := New(client.ClientOptions{
+		Uri:         strings.Join(uris, ","),
+		Platform:    "gcp",
+		AuthnMode:   "none",
+		RequestYaml: "node_matchers:\n- node_metadatas:\n  - path: [{key: TRAFFICDIRECTOR_GCP_PROJECT_NUMBER}]\n    value: {string_match: {exact: fake_project_number}}\n  - path: [{key: TRAFFICDIRECTOR_NETWORK_NAME}]\n    value: {string_match: {exact: fake_network_name}}",
+		Filter:      "metadata.CSDS_CONTROL_PLANE=" + uris[0],
+		Summary:     true,
+	})
+	if err != nil {
+		t.Fatalf("Create client error: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	})
+	if !strings.HasPrefix(out, "Total clients: 2\n") {
+		t.Errorf("want the summary of the 2 clients of %v, got\n%v", uris[0], out)
+	}
+	// the control plane key is only added by the client, so it is not sent to the control planes
+	for _, nm := range c.queries[0].nodeMatcher {
+		if getValueByKeyFromNodeMatcher([]*envoy_type_matcher_v3.NodeMatcher{nm}, clientUtil.ControlPlaneKey) != "" {
+			t.Errorf("NodeMatcher = %v, want no %v", nm, clientUtil.ControlPlaneKey)
+		}
+	}
+}
+
+// closedStream is a stream to a control plane that closed it
+type closedStream struct {
+	csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient
+}
+
+func (closedStream) Send(*csdspb_v3.ClientStatusRequest) error {
+	return nil
+}
+
+func (closedStream) Recv() (*csdspb_v3.ClientStatusResponse, error) {
+	return nil, io.EOF
+}
+
+// TestFetchClosedStream tests that a stream closed by the control plane is reported as a failure,
+// and recreated on the next request
+func TestFetchClosedStream(t *testing.T) {
+	c := &ClientV3{}
+	q := query{node: &envoy_config_core_v3.Node{Id: "fake_node_id"}}
+	target := &target{uri: "fake_uri", stream: closedStream{}}
+	if resp, err := c.fetch(context.Background(), target, q); err == nil || resp != nil {
+		t.Errorf("fetch() = %v, %v, want an error", resp, err)
+	}
+	if target.stream != nil {
+		t.Errorf("want the closed stream to be reset")
+	}
+}
+
+// TestFetchStalledTarget tests that a control plane that does not answer is reported as failed
+// after the timeout, without blocking the other control planes nor the next requests
+func TestFetchStalledTarget(t *testing.T) {
+	defer func(timeout time.Duration) { fetchTimeout = timeout }(fetchTimeout)
+	fetchTimeout = 50 * time.Millisecond
+
+	response := readResponse(t, "./response_for_summary.json")
+	var uris []string
+	for _, step := range []fake.Step{{Response: response}, {Response: response, Delay: time.Minute}} {
+		server, err := fake.NewServer(step)
+		if err != nil {
+			t.Fatalf("Start fake server error: %v", err)
+		}
+		defer server.Stop()
+		uris = append(uris, server.Addr())
+	}
+	c := &ClientV3{
+		opts:    client.ClientOptions{Platform: "gcp", AuthnMode: "none"},
+		queries: []query{{node: &envoy_config_core_v3.Node{Id: "fake_node_id"}}},
+	}
+	targets, err := c.connectTargets(uris)
+	defer closeTargets(targets)
+	if err != nil {
+		t.Fatalf("Connect targets error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		merged, _, errs := c.fetchMerged(context.Background(), targets, c.queries)
+		if errs[0] != nil || errs[1] == nil || !strings.Contains(errs[1].Error(), "no response within 50ms") {
+			t.Errorf("fetchMerged() errors = %v, want only the stalled control plane to fail", errs)
+		}
+		if len(merged.GetConfig()) != 3 {
+			t.Errorf("fetchMerged() = %v clients, want the 3 clients of the other control plane", len(merged.GetConfig()))
+		}
+	}
+
+	// a request that is canceled fails before the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.fetch(ctx, targets[1], c.queries[0]); err != context.Canceled {
+		t.Errorf("fetch() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+// TestServe tests serving a directory of CSDS responses and config dumps, which is reloaded when
+// the files change
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds-serve")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for src, dst := range map[string]string{
+		"./response_for_summary.json":  "clients.json",
+		"./config_dump_for_admin.json": "envoy.json",
+	} {
+		b, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Fatalf("Read From File Failure: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, dst), b, 0644); err != nil {
+			t.Fatalf("Write file error: %v", err)
+		}
+	}
+	store := &configStore{path: dir}
+	if _, err := store.reload(); err != nil {
+		t.Fatalf("Load configs error: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	server := newCsdsServer(store.source)
+	go server.Serve(listener)
+	defer server.Stop()
+	conn, err := clientUtil.ConnInsecure(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	defer conn.Close()
+	csdsClient := csdspb_v3.NewClientStatusDiscoveryServiceClient(conn)
+
+	fetch := func(matchers string) []string {
+		request := &csdspb_v3.ClientStatusRequest{}
+		if err := protojson.Unmarshal([]byte(`{"node_matchers": `+matchers+`}`), request); err != nil {
+			t.Fatalf("Parse request error: %v", err)
+		}
+		response, err := csdsClient.FetchClientStatus(context.Background(), request)
+		if err != nil {
+			t.Fatalf("Fetch client status error: %v", err)
+		}
+		var ids []string
+		for _, config := range response.GetConfig() {
+			ids = append(ids, config.GetNode().GetId())
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	if got := fetch(`[]`); len(got) != 4 {
+		t.Errorf("want all the 4 clients, got %v", got)
+	}
+	got := fetch(`[{"node_metadatas": [{"path": [{"key": "TRAFFICDIRECTOR_NETWORK_NAME"}], "value": {"string_match": {"exact": "fake_network_name"}}}]}]`)
+	if strings.Join(got, ",") != "fake_envoy,test_node_1,test_node_2" {
+		t.Errorf("want the clients on fake_network_name, got %v", got)
+	}
+
+	// the v2 api is served as well
+	csdsClientV2 := csdspb_v2.NewClientStatusDiscoveryServiceClient(conn)
+	responseV2, err := csdsClientV2.FetchClientStatus(context.Background(), &csdspb_v2.ClientStatusRequest{})
+	if err != nil {
+		t.Fatalf("Fetch v2 client status error: %v", err)
+	}
+	if len(responseV2.GetConfig()) != 4 {
+		t.Errorf("want all the 4 clients with v2, got %v", len(responseV2.GetConfig()))
+	}
+	// the generic xds configs of the config dump are sent as per xds configs, which the v2 api has
+	for _, config := range responseV2.GetConfig() {
+		if config.GetNode().GetId() != "fake_envoy" {
+			continue
+		}
+		xds := make(map[string]int)
+		for _, perXdsConfig := range config.GetXdsConfig() {
+			xds["LDS"] += len(perXdsConfig.GetListenerConfig().GetDynamicListeners()) + len(perXdsConfig.GetListenerConfig().GetStaticListeners())
+			xds["CDS"] += len(perXdsConfig.GetClusterConfig().GetDynamicActiveClusters()) + len(perXdsConfig.GetClusterConfig().GetStaticClusters())
+			xds["RDS"] += len(perXdsConfig.GetRouteConfig().GetDynamicRouteConfigs()) + len(perXdsConfig.GetRouteConfig().GetStaticRouteConfigs())
+		}
+		if xds["LDS"] == 0 || xds["CDS"] == 0 || xds["RDS"] == 0 {
+			t.Errorf("want the listeners, clusters and routes of fake_envoy with v2, got %v", xds)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(dir, "clients.json")); err != nil {
+		t.Fatalf("Remove file error: %v", err)
+	}
+	if reloaded, err := store.reload(); err != nil || !reloaded {
+		t.Fatalf("want the configs reloaded, got %v, %v", reloaded, err)
+	}
+	if got := fetch(`[]`); strings.Join(got, ",") != "fake_envoy" {
+		t.Errorf("want only fake_envoy after reload, got %v", got)
+	}
+}
+
+// TestServeProxy tests the aggregation proxy, which merges the responses of the fake CSDS servers
+// and applies the NodeMatchers of the incoming request
+func TestServeProxy(t *testing.T) {
+	response := readResponse(t, "./response_for_summary.json")
+	var uris []string
+	var servers []*fake.Server
+	for _, config := range [][]*csdspb_v3.ClientConfig{response.GetConfig()[:1], response.GetConfig()[1:]} {
+		server, err := fake.NewServer(fake.Step{Response: &csdspb_v3.ClientStatusResponse{Config: config}})
+		if err != nil {
+			t.Fatalf("Start fake server error: %v", err)
+		}
+		defer server.Stop()
+		servers = append(servers, server)
+		uris = append(uris, server.Addr())
+	}
+
+	c, err := New(client.ClientOptions{
+		Uri:         strings.Join(uris, ","),
+		Platform:    "gcp",
+		AuthnMode:   "none",
+		RequestFile: "./test_request.yaml",
+	})
+	if err != nil {
+		t.Fatalf("Create client error: %v", err)
+	}
+	targets, err := c.connectTargets(uris)
+	defer closeTargets(targets)
+	if err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	proxy := newCsdsServer(c.proxySource(targets))
+	go proxy.Serve(listener)
+	defer proxy.Stop()
+
+	conn, err := clientUtil.ConnInsecure(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	defer conn.Close()
+	request := &csdspb_v3.ClientStatusRequest{}
+	if err := protojson.Unmarshal([]byte(`{"node_matchers": [{"node_id": {"suffix": "_3"}}, {"node_id": {"exact": "test_node_1"}}, {"node_metadatas": [{"path": [{"key": "CSDS_CONTROL_PLANE"}], "value": {"string_match": {"exact": "none"}}}]}]}`), request); err != nil {
+		t.Fatalf("Parse request error: %v", err)
+	}
+	got, err := csdspb_v3.NewClientStatusDiscoveryServiceClient(conn).FetchClientStatus(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Fetch client status error: %v", err)
+	}
+	var ids []string
+	for _, config := range got.GetConfig() {
+		ids = append(ids, config.GetNode().GetId())
+	}
+	if strings.Join(ids, ",") != "test_node_1,test_node_3" {
+		t.Errorf("want test_node_1 and test_node_3 from both control planes, got %v", ids)
+	}
+
+	// the control planes receive the request of the proxy narrowed down with the incoming request,
+	// without the matches on the control plane tag
+	for _, server := range servers {
+		requests := server.Requests()
+		if len(requests) != 1 {
+			t.Fatalf("want 1 request, got %v", len(requests))
+		}
+		matchers := requests[0].Request.(*csdspb_v3.ClientStatusRequest).GetNodeMatchers()
+		if len(matchers) != 3 {
+			t.Fatalf("want a node matcher for each incoming node matcher, got %v", matchers)
+		}
+		for _, matcher := range matchers {
+			if got := matcher.GetNodeId().GetExact(); got != "fake_node_id" || len(matcher.GetNodeMetadatas()) != 2 {
+				t.Errorf("want the node matcher of the proxy, got %v", matcher)
+			}
+		}
+	}
+
+	// a canceled incoming request cancels the requests to a stalled control plane
+	stalled, err := fake.NewServer(fake.Step{Response: response, Delay: time.Minute})
+	if err != nil {
+		t.Fatalf("Start fake server error: %v", err)
+	}
+	defer stalled.Stop()
+	stalledTargets, err := c.connectTargets([]string{stalled.Addr()})
+	defer closeTargets(stalledTargets)
+	if err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.proxySource(stalledTargets)(ctx, request); err == nil {
+		t.Errorf("want an error from the stalled control plane")
+	}
+	if elapsed := time.Since(start); elapsed > fetchTimeout/2 {
+		t.Errorf("want the request to be canceled with the incoming request, took %v", elapsed)
+	}
+}
+
+// TestGateway tests the endpoints of the HTTP/JSON gateway against a fake CSDS server
+func TestGateway(t *testing.T) {
+	server, err := fake.NewServer(fake.Step{Response: readResponse(t, "./response_for_summary.json")})
+	if err != nil {
+		t.Fatalf("Start fake server error: %v", err)
+	}
+	defer server.Stop()
+	c, err := New(client.ClientOptions{
+		Uri:         server.Addr(),
+		Platform:    "gcp",
+		AuthnMode:   "none",
+		RequestFile: "./test_request.yaml",
+	})
+	if err != nil {
+		t.Fatalf("Create client error: %v", err)
+	}
+	targets, err := c.connectTargets([]string{server.Addr()})
+	defer closeTargets(targets)
+	if err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	gateway := httptest.NewServer(c.gatewayHandler(targets))
+	defer gateway.Close()
+
+	get := func(path string, wantCode int) []byte {
+		resp, err := http.Get(gateway.URL + path)
+		if err != nil {
+			t.Fatalf("GET %v error: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != wantCode {
+			t.Errorf("GET %v: want status %v, got %v %s", path, wantCode, resp.StatusCode, body)
+		}
+		return body
+	}
+
+	var clients []gatewayClient
+	if err := json.Unmarshal(get("/v1/clients?filter="+url.QueryEscape("zone=us-east1-b"), http.StatusOK), &clients); err != nil {
+		t.Fatalf("Parse clients error: %v", err)
+	}
+	if len(clients) != 1 || clients[0].Id != "test_node_2" {
+		t.Errorf("want test_node_2 in us-east1-b, got %v", clients)
+	}
+
+	var summary clientUtil.Summary
+	if err := json.Unmarshal(get("/v1/summary?group_by_metadata=TRAFFICDIRECTOR_NETWORK_NAME", http.StatusOK), &summary); err != nil {
+		t.Fatalf("Parse summary error: %v", err)
+	}
+	if summary.Clients != 3 || summary.Metadata["TRAFFICDIRECTOR_NETWORK_NAME"]["fake_network_name"] != 2 {
+		t.Errorf("want 3 clients of which 2 on fake_network_name, got %+v", summary)
+	}
+
+	if config := get("/v1/config?id=test_node_1", http.StatusOK); !strings.Contains(string(config), `"id":"test_node_1"`) {
+		t.Errorf("want the config of test_node_1, got %s", config)
+	}
+	get("/v1/graph?id=test_node_1", http.StatusOK)
+	// the id is sent to the control plane in place of a node id that matches it
+	get("/v1/config?id=test_node_1&request_yaml="+url.QueryEscape("node_matchers: [{node_id: {prefix: test_node_}}]"), http.StatusOK)
+	requests := server.Requests()
+	if got := requests[len(requests)-1].Request.(*csdspb_v3.ClientStatusRequest).GetNodeMatchers()[0].GetNodeId().GetExact(); got != "test_node_1" {
+		t.Errorf("want the id as the node id of the request, got %v", got)
+	}
+	get("/v1/config?id=unknown", http.StatusNotFound)
+	get("/v1/config", http.StatusBadRequest)
+	get("/v1/clients?request_yaml="+url.QueryEscape("node_matchers: [{unknown_field: foo}]"), http.StatusBadRequest)
+	get("/v1/clients?filter="+url.QueryEscape("unknown=1"), http.StatusBadRequest)
+}
+
+// TestFetch tests fetching the clients once, and describing and comparing them
+func TestFetch(t *testing.T) {
+	server, err := fake.NewServer(fake.Step{Response: readResponse(t, "./response_for_summary.json")})
+	if err != nil {
+		t.Fatalf("Start fake server error: %v", err)
+	}
+	defer server.Stop()
+
+	c, err := New(client.ClientOptions{
+		Uri:           server.Addr(),
+		Platform:      "gcp",
+		AuthnMode:     "none",
+		RequestFile:   "./test_request.yaml",
+		FilterMode:    "prefix",
+		FilterPattern: "test_node_",
+		Filter:        "stream_type=ADS",
+	})
+	if err != nil {
+		t.Fatalf("Create client error: %v", err)
+	}
+	snapshot, err := c.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch error: %v", err)
+	}
+	if len(snapshot.Clients) != 2 || len(snapshot.Response.(*csdspb_v3.ClientStatusResponse).GetConfig()) != 2 {
+		t.Fatalf("want the 2 ADS clients, got %v", snapshot.Clients)
+	}
+
+	first, err := clientUtil.FindClient(snapshot.Clients, "node_1")
+	if err != nil || first.Id != "test_node_1" {
+		t.Fatalf("want test_node_1, got %v, %v", first.Id, err)
+	}
+	if _, err := clientUtil.FindClient(snapshot.Clients, "test_node"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("want an ambiguous node error, got %v", err)
+	}
+	if _, err := clientUtil.FindClient(snapshot.Clients, "test_node_3"); err == nil {
+		t.Errorf("want an error on the filtered out node")
+	}
+	second, _ := clientUtil.FindClient(snapshot.Clients, "test_node_2")
+
+	out := clientUtil.CaptureOutput(func() {
+		clientUtil.PrintDescribe(first)
+	})
+	for _, want := range []string{
+		"Client ID:         test_node_1\n",
+		"xDS stream type:   ADS\n",
+		"  TRAFFICDIRECTOR_NETWORK_NAME: fake_network_name\n",
+		"  CDS      fake_cluster ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q in the description, got\n%v", want, out)
+		}
+	}
+
+	divergences, compared := clientUtil.DiffResources(first, second)
+	if compared != 2 || len(divergences) != 1 || divergences[0].Name != "fake_cluster" || divergences[0].Reason != "version mismatch" ||
+		divergences[0].Left != "fake_cluster_version2" || divergences[0].Right != "fake_cluster_version1" {
+		t.Errorf("want a version mismatch of fake_cluster in 2 resources, got %v in %v", divergences, compared)
+	}
+
+	dir, err := ioutil.TempDir("", "csds-graph")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "graph.dot")
+	clientUtil.CaptureOutput(func() {
+		if err := clientUtil.PrintGraph(snapshot.Response, path); err != nil {
+			t.Errorf("Print graph error: %v", err)
+		}
+	})
+	if dot, err := ioutil.ReadFile(path); err != nil || !strings.HasPrefix(string(dot), "digraph G {") {
+		t.Errorf("want the graph in dot, got %s, %v", dot, err)
+	}
+}
+
+// TestHistory tests that the responses are recorded in the history store with -history_dir
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds_history")
+	if err != nil {
+		t.Fatalf("Create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := readResponse(t, "./response_for_summary.json")
+	second := readResponse(t, "./response_for_summary.json")
+	// test_node_1 drops its listener and test_node_3 disconnects
+	second.Config[0].GenericXdsConfigs = second.Config[0].GenericXdsConfigs[:1]
+	second.Config = second.Config[:2]
+	for _, response := range []*csdspb_v3.ClientStatusResponse{first, second} {
+		server, err := fake.NewServer(fake.Step{Response: response})
+		if err != nil {
+			t.Fatalf("Start fake server error: %v", err)
+		}
+		c, err := New(client.ClientOptions{
+			Uri:         server.Addr(),
+			Platform:    "gcp",
+			AuthnMode:   "none",
+			RequestFile: "./test_request.yaml",
+			HistoryDir:  dir,
+		})
+		if err != nil {
+			t.Fatalf("Create client error: %v", err)
+		}
+		clientUtil.CaptureOutput(func() {
+			if err := c.Run(); err != nil {
+				t.Errorf("Run error: %v", err)
+			}
+		})
+		server.Stop()
+	}
+
+	h, err := clientUtil.OpenHistory(dir, nil)
+	if err != nil {
+		t.Fatalf("Open history error: %v", err)
+	}
+	// the 3 nodes and 5 resources are added, then the config statuses of test_node_1 change, its
+	// listener is removed and test_node_3 is removed
+	if changes := h.Changes(time.Time{}, time.Time{}, ""); len(changes) != 11 {
+		t.Errorf("want 11 changes, got %v", changes)
+	}
+}
@@ -0,0 +1,251 @@
+package client
+
+import (
+	"context"
+	clientutil "envoy-tools/csds-client/client/util"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// fetchTimeout is how long a control plane has to answer a request before it is reported as failed
+var fetchTimeout = 30 * time.Second
+
+// target is a control plane that the requests are fanned out to
+type target struct {
+	// mu serializes the requests on the stream
+	mu     sync.Mutex
+	uri    string
+	conn   *grpc.ClientConn
+	client csdspb_v3.ClientStatusDiscoveryServiceClient
+	// ctx is the context of the streams, with the metadata of the authentication. The streams
+	// outlive the requests, so it is never canceled.
+	ctx    context.Context
+	stream csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient
+	// cancel cancels the stream
+	cancel context.CancelFunc
+}
+
+// runFanOut connects the client to each of the uris, sends the request of each query to all of them
+// concurrently and prints out the merged response to each query
+func (c *ClientV3) runFanOut(uris []string) error {
+	targets, err := c.connectTargets(uris)
+	defer closeTargets(targets)
+	if err != nil {
+		return err
+	}
+	ctx := c.outgoingContext()
+
+	// run once or run with monitor mode
+	for {
+		responses := make([]*csdspb_v3.ClientStatusResponse, len(c.queries))
+		for i, q := range c.queries {
+			merged, err := c.fetchAll(ctx, targets, []query{q})
+			if err != nil {
+				return err
+			}
+			responses[i] = merged
+		}
+		if err := c.printQueries(responses); err != nil {
+			return err
+		}
+
+		if c.opts.MonitorInterval != 0 {
+			time.Sleep(c.opts.MonitorInterval)
+		} else {
+			for _, t := range targets {
+				if t.stream != nil {
+					t.stream.CloseSend()
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// connectTargets connects the client to each of the uris. The targets that are connected are
+// returned even on error, so that they can be closed.
+func (c *ClientV3) connectTargets(uris []string) ([]*target, error) {
+	var targets []*target
+	for _, uri := range uris {
+		conn, err := c.connWithAuth(uri)
+		if err != nil {
+			return targets, fmt.Errorf("failed to connect to %v: %v", uri, err)
+		}
+		targets = append(targets, &target{uri: uri, conn: conn, client: csdspb_v3.NewClientStatusDiscoveryServiceClient(conn), ctx: c.outgoingContext()})
+	}
+	return targets, nil
+}
+
+// outgoingContext returns the context of the streams to the targets, with the metadata of the
+// authentication. The streams outlive the requests, so it is never canceled.
+func (c *ClientV3) outgoingContext() context.Context {
+	if c.metadata != nil {
+		return metadata.NewOutgoingContext(context.Background(), c.metadata)
+	}
+	return context.Background()
+}
+
+// closeTargets closes the connections to the targets
+func closeTargets(targets []*target) {
+	for _, t := range targets {
+		t.conn.Close()
+	}
+}
+
+// fetchAll sends the requests of the queries to all the targets concurrently and merges their
+// responses. The targets that fail are reported, and an error is returned only if all of them fail.
+func (c *ClientV3) fetchAll(ctx context.Context, targets []*target, queries []query) (*csdspb_v3.ClientStatusResponse, error) {
+	merged, duplicates, errs := c.fetchMerged(ctx, targets, queries)
+	var failed int
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "Failed to query %v: %v\n", targets[i].uri, err)
+		}
+	}
+	if failed == len(targets) {
+		return nil, fmt.Errorf("failed to query all the %d control planes", len(targets))
+	}
+	clientutil.PrintDuplicateClients(duplicates)
+	return merged, nil
+}
+
+// fetchMerged sends the requests of the queries to all the targets concurrently and merges their
+// responses, without printing anything. It returns the merged response, the duplicate clients as
+// mergeResponses, and the error of each target. The queries are sent one after the other to each
+// target, and the first query that fails is the error of the target.
+func (c *ClientV3) fetchMerged(ctx context.Context, targets []*target, queries []query) (*csdspb_v3.ClientStatusResponse, map[string]string, []error) {
+	uris := make([]string, len(targets)*len(queries))
+	responses := make([]*csdspb_v3.ClientStatusResponse, len(targets)*len(queries))
+	errs := make([]error, len(targets))
+	clientutil.FanOut(len(targets), c.opts.Parallelism, func(i int) {
+		for j := range queries {
+			uris[i*len(queries)+j] = targets[i].uri
+		}
+		// the responses of a target are only merged if all its queries succeed
+		fetched := make([]*csdspb_v3.ClientStatusResponse, len(queries))
+		for j, q := range queries {
+			if fetched[j], errs[i] = c.fetch(ctx, targets[i], q); errs[i] != nil {
+				return
+			}
+		}
+		for j := range queries {
+			responses[i*len(queries)+j] = fetched[j]
+		}
+	})
+	// a client that matches more than one query of a target is tagged with the target once, so it
+	// is not a duplicate
+	merged, duplicates := mergeResponses(uris, responses)
+	return merged, duplicates, errs
+}
+
+// fetch sends the request of the query to the target and receives the response. The stream to the
+// target is created on the first request, and recreated on the next request after an error or after
+// the control plane closed it. A request that is not answered within fetchTimeout, or before ctx is
+// done, fails and cancels the stream, so that a stalled control plane does not block the target.
+func (c *ClientV3) fetch(ctx context.Context, t *target, q query) (*csdspb_v3.ClientStatusResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stream == nil {
+		streamCtx, cancel := context.WithCancel(t.ctx)
+		stream, err := t.client.StreamClientStatus(streamCtx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		t.stream, t.cancel = stream, cancel
+	}
+
+	type result struct {
+		resp *csdspb_v3.ClientStatusResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	stream := t.stream
+	go func() {
+		if err := stream.Send(c.request(q)); err != nil {
+			done <- result{err: err}
+			return
+		}
+		resp, err := stream.Recv()
+		done <- result{resp, err}
+	}()
+
+	timer := time.NewTimer(fetchTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			// the stream is recreated on the next request, also when the control plane closed it
+			t.resetStream()
+			if r.err == io.EOF {
+				r.err = fmt.Errorf("the stream is closed by the control plane")
+			}
+			return nil, r.err
+		}
+		return r.resp, nil
+	case <-timer.C:
+		t.resetStream()
+		return nil, fmt.Errorf("no response within %v", fetchTimeout)
+	case <-ctx.Done():
+		t.resetStream()
+		return nil, ctx.Err()
+	}
+}
+
+// resetStream cancels the stream, which ends a pending request on it, so that it is recreated on
+// the next request
+func (t *target) resetStream() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.stream, t.cancel = nil, nil
+}
+
+// mergeResponses merges the responses of the control planes into one response, in which each
+// client is tagged with the control planes that reported it. The clients reported by more than one
+// control plane are deduplicated, and returned with the control planes that reported them. The
+// clients are only tagged if more than one control plane is queried.
+func mergeResponses(uris []string, responses []*csdspb_v3.ClientStatusResponse) (*csdspb_v3.ClientStatusResponse, map[string]string) {
+	merged := &csdspb_v3.ClientStatusResponse{}
+	clients := make(map[string]*csdspb_v3.ClientConfig)
+	duplicates := make(map[string]string)
+	controlPlanes := make(map[string]bool)
+	for _, uri := range uris {
+		controlPlanes[uri] = true
+	}
+	tag := len(controlPlanes) > 1
+	for i, response := range responses {
+		for _, config := range response.GetConfig() {
+			id := config.GetNode().GetId()
+			if existing, ok := clients[id]; ok && id != "" {
+				if !tag {
+					continue
+				}
+				md, n := clientutil.TagControlPlane(existing.GetNode().GetMetadata(), uris[i])
+				existing.Node.Metadata = md
+				if n > 1 {
+					duplicates[id] = md.Fields[clientutil.ControlPlaneKey].GetStringValue()
+				}
+				continue
+			}
+			if tag {
+				if config.Node == nil {
+					config.Node = &envoy_config_core_v3.Node{}
+				}
+				config.Node.Metadata, _ = clientutil.TagControlPlane(config.GetNode().GetMetadata(), uris[i])
+			}
+			clients[id] = config
+			merged.Config = append(merged.Config, config)
+		}
+	}
+	return merged, duplicates
+}
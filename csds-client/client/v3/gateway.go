@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	clientutil "envoy-tools/csds-client/client/util"
+	"fmt"
+	"log"
+	"net/http"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// gatewayClient is a client in the list of clients of the gateway
+type gatewayClient struct {
+	Id         string                 `json:"id"`
+	StreamType string                 `json:"streamType,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Statuses   []gatewayClientStatus  `json:"statuses"`
+}
+
+// gatewayClientStatus is the config status of one xDS type of a client
+type gatewayClientStatus struct {
+	Xds    string `json:"xds"`
+	Status string `json:"status"`
+}
+
+// ServeGateway serves an HTTP/JSON gateway for CSDS queries on -listen_address. Each HTTP call is
+// translated into a CSDS request to the control planes, which is built from the request of this
+// client and the request_yaml query parameter. The endpoints are:
+//
+//	GET /v1/clients             the clients with their config statuses
+//	GET /v1/config?id=<id>      the config of a client
+//	GET /v1/graph?id=<id>       the graph of the xDS resources of a client in dot
+//	GET /v1/summary             the fleet-wide summary, grouped by the group_by_metadata parameter
+//
+// All the endpoints also take the filter query parameter, which is a -filter expression.
+func (c *ClientV3) ServeGateway() error {
+	uris, err := clientutil.ServiceUris(c.opts)
+	if err != nil {
+		return err
+	}
+	targets, err := c.connectTargets(uris)
+	defer closeTargets(targets)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving HTTP gateway on %v\n", c.opts.ListenAddress)
+	return http.ListenAndServe(c.opts.ListenAddress, c.gatewayHandler(targets))
+}
+
+// gatewayHandler returns the handler of the gateway endpoints, which query the targets
+func (c *ClientV3) gatewayHandler(targets []*target) http.Handler {
+	ctx := c.outgoingContext()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/clients", func(w http.ResponseWriter, r *http.Request) {
+		response, ok := c.gatewayFetch(ctx, targets, w, r, nil)
+		if !ok {
+			return
+		}
+		clients := []gatewayClient{}
+		for _, config := range response.GetConfig() {
+			parsed := parseClientConfig(config)
+			client := gatewayClient{Id: parsed.Id, StreamType: parsed.StreamType, Metadata: parsed.Metadata, Statuses: []gatewayClientStatus{}}
+			for _, status := range parsed.Statuses {
+				client.Statuses = append(client.Statuses, gatewayClientStatus{Xds: status.Xds, Status: status.Status})
+			}
+			clients = append(clients, client)
+		}
+		writeJson(w, clients)
+	})
+	mux.HandleFunc("/v1/config", func(w http.ResponseWriter, r *http.Request) {
+		config, ok := c.gatewayFetchClient(ctx, targets, w, r)
+		if !ok {
+			return
+		}
+		js, err := marshalConfig(config, clientutil.RedactedFields(c.opts))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJson(w, json.RawMessage(js))
+	})
+	mux.HandleFunc("/v1/graph", func(w http.ResponseWriter, r *http.Request) {
+		config, ok := c.gatewayFetchClient(ctx, targets, w, r)
+		if !ok {
+			return
+		}
+		js, err := marshalConfig(&csdspb_v3.ClientStatusResponse{Config: []*csdspb_v3.ClientConfig{config}}, nil)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		graphData, err := clientutil.ParseXdsRelationship(js)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		dot, err := clientutil.GenerateGraph(graphData)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.Write([]byte(dot))
+	})
+	mux.HandleFunc("/v1/summary", func(w http.ResponseWriter, r *http.Request) {
+		response, ok := c.gatewayFetch(ctx, targets, w, r, nil)
+		if !ok {
+			return
+		}
+		var configs []clientutil.ClientConfig
+		for _, config := range response.GetConfig() {
+			configs = append(configs, parseClientConfig(config))
+		}
+		writeJson(w, clientutil.BuildSummary(configs, clientutil.SplitList(r.URL.Query().Get("group_by_metadata"))))
+	})
+	return mux
+}
+
+// gatewayFetch translates the HTTP call into a CSDS request to the targets and returns the response
+// filtered by the filter parameter. The request is validated as -request_yaml by parseNodeMatcher,
+// and its NodeMatchers are narrowed down with the matchers if there are any. On error, the error is
+// written to the HTTP response and false is returned.
+func (c *ClientV3) gatewayFetch(ctx context.Context, targets []*target, w http.ResponseWriter, r *http.Request, matchers []*envoy_type_matcher_v3.NodeMatcher) (*csdspb_v3.ClientStatusResponse, bool) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v is not allowed", r.Method))
+		return nil, false
+	}
+	opts := c.opts
+	if requestYaml := r.URL.Query().Get("request_yaml"); requestYaml != "" {
+		opts.RequestYaml = requestYaml
+	}
+	opts.Filter = r.URL.Query().Get("filter")
+	request := &ClientV3{opts: opts, metadata: c.metadata}
+	if err := request.parseNodeMatcher(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	for i, q := range request.queries {
+		request.queries[i].nodeMatcher = clientutil.NarrowNodeMatchers(q.nodeMatcher, matchers)
+	}
+
+	response, err := request.fetchServed(ctx, targets, request.queries)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return nil, false
+	}
+	if opts.Filter != "" {
+		filter, err := clientutil.ParseFilter(opts.Filter)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return nil, false
+		}
+		response = filterResponse(response, filter)
+	}
+	return response, true
+}
+
+// gatewayFetchClient fetches the client with the id parameter. The id is sent to the control planes
+// as an exact match on the node id, so that they do not send all the clients.
+func (c *ClientV3) gatewayFetchClient(ctx context.Context, targets []*target, w http.ResponseWriter, r *http.Request) (*csdspb_v3.ClientConfig, bool) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing id"))
+		return nil, false
+	}
+	matcher := &envoy_type_matcher_v3.NodeMatcher{
+		NodeId: &envoy_type_matcher_v3.StringMatcher{MatchPattern: &envoy_type_matcher_v3.StringMatcher_Exact{Exact: id}},
+	}
+	response, ok := c.gatewayFetch(ctx, targets, w, r, []*envoy_type_matcher_v3.NodeMatcher{matcher})
+	if !ok {
+		return nil, false
+	}
+	for _, config := range response.GetConfig() {
+		if config.GetNode().GetId() == id {
+			return config, true
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("client %v not found", id))
+	return nil, false
+}
+
+// marshalConfig redacts the fields and marshals the message to json with the Any types resolved
+func marshalConfig(m proto.Message, redactedFields []string) ([]byte, error) {
+	if redactedFields != nil {
+		var err error
+		if m, err = clientutil.Redact(m, redactedFields); err != nil {
+			return nil, err
+		}
+	}
+	return protojson.MarshalOptions{Resolver: &clientutil.TypeResolver{}}.Marshal(m)
+}
+
+// writeJson writes the value as the json response. The configs are marshaled by marshalConfig and
+// written as json.RawMessage, so that the json of all the endpoints is written the same way. The
+// status and part of the body may already be written when the encoding fails, so the error is only
+// logged.
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write the json response: %v", err)
+	}
+}
+
+// writeError writes the error as the json response with the status code
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"envoy-tools/csds-client/client"
+	"time"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// observeResponse records all the clients of the response in the history store if -history_dir is
+// set, and evaluates the hooks on them if -hooks_file is set
+func (c *ClientV3) observeResponse(response *csdspb_v3.ClientStatusResponse) error {
+	if c.history == nil && c.hooks == nil {
+		return nil
+	}
+	configs, err := parseClientConfigs(response, client.ClientOptions{})
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if c.history != nil {
+		if _, err := c.history.Record(now, configs); err != nil {
+			return err
+		}
+	}
+	if c.hooks != nil {
+		c.hooks.Run(now, configs)
+		if c.opts.MonitorInterval == 0 {
+			// the process exits after a single request
+			c.hooks.Wait()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	clientutil "envoy-tools/csds-client/client/util"
+	"fmt"
+	"log"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"google.golang.org/grpc/metadata"
+)
+
+// ServeProxy serves CSDS on -listen_address as an aggregation proxy of the control planes. Each
+// request is fanned out to all the control planes with the request of this client, and is answered
+// with the merged clients that match the NodeMatchers of the incoming request.
+func (c *ClientV3) ServeProxy() error {
+	uris, err := clientutil.ServiceUris(c.opts)
+	if err != nil {
+		return err
+	}
+	targets, err := c.connectTargets(uris)
+	defer closeTargets(targets)
+	if err != nil {
+		return err
+	}
+	return serveCsds(c.opts.ListenAddress, c.proxySource(targets))
+}
+
+// proxySource answers the requests with the merged responses of the targets. The queries are
+// narrowed down with the NodeMatchers of the incoming request, and are canceled with it. The streams
+// to the targets outlive the incoming requests, so they keep the context of the targets.
+func (c *ClientV3) proxySource(targets []*target) configSource {
+	return func(ctx context.Context, request *csdspb_v3.ClientStatusRequest) (*csdspb_v3.ClientStatusResponse, error) {
+		if c.metadata != nil {
+			ctx = metadata.NewOutgoingContext(ctx, c.metadata)
+		}
+		queries := make([]query, len(c.queries))
+		for i, q := range c.queries {
+			q.nodeMatcher = clientutil.NarrowNodeMatchers(q.nodeMatcher, request.GetNodeMatchers())
+			queries[i] = q
+		}
+		return c.fetchServed(ctx, targets, queries)
+	}
+}
+
+// fetchServed sends the requests of the queries to all the targets concurrently and merges their
+// responses for a call served by the proxy or the gateway. The targets that fail and the duplicate
+// clients are logged, and an error is returned only if all the targets fail.
+func (c *ClientV3) fetchServed(ctx context.Context, targets []*target, queries []query) (*csdspb_v3.ClientStatusResponse, error) {
+	merged, duplicates, errs := c.fetchMerged(ctx, targets, queries)
+	var failed int
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			log.Printf("Failed to query %v: %v", targets[i].uri, err)
+		}
+	}
+	if failed == len(targets) {
+		return nil, fmt.Errorf("failed to query all the %d control planes", len(targets))
+	}
+	for id, uris := range duplicates {
+		log.Printf("Warning: client %v is reported by more than one control plane: %v", id, uris)
+	}
+	return merged, nil
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"envoy-tools/csds-client/client"
+	clientutil "envoy-tools/csds-client/client/util"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"github.com/ghodss/yaml"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// reloadInterval is how often the served files are checked for changes
+const reloadInterval = time.Second
+
+// Serve serves the client configs in the files at -serve_path over CSDS on -listen_address. The
+// path is either a file or a directory of files, each of which is a ClientStatusResponse or the
+// config dump of an Envoy admin, in json or yaml. The files are reloaded when they change.
+func Serve(opts client.ClientOptions) error {
+	if opts.ServePath == "" {
+		return errors.New("missing serve path")
+	}
+	store := &configStore{path: opts.ServePath}
+	if _, err := store.reload(); err != nil {
+		return err
+	}
+	go func() {
+		for range time.Tick(reloadInterval) {
+			if reloaded, err := store.reload(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to reload %v, still serving the previous configs: %v\n", store.path, err)
+			} else if reloaded {
+				fmt.Printf("Reloaded %v\n", store.path)
+			}
+		}
+	}()
+	return serveCsds(opts.ListenAddress, store.source)
+}
+
+// configStore holds the client configs loaded from the files at path
+type configStore struct {
+	path string
+
+	mu      sync.RWMutex
+	configs []*csdspb_v3.ClientConfig
+	// stamp identifies the files that the configs are loaded from by their names, sizes and
+	// modification times, so that the files are only reloaded when they change
+	stamp string
+}
+
+// source answers a CSDS request with all the loaded client configs
+func (s *configStore) source(ctx context.Context, request *csdspb_v3.ClientStatusRequest) (*csdspb_v3.ClientStatusResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &csdspb_v3.ClientStatusResponse{Config: s.configs}, nil
+}
+
+// reload loads the files again if they have changed since the last load, and reports whether they
+// are reloaded. The loaded configs are kept if any file fails to load.
+func (s *configStore) reload() (bool, error) {
+	files, stamp, err := configFiles(s.path)
+	if err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	unchanged := stamp == s.stamp
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	var configs []*csdspb_v3.ClientConfig
+	for _, file := range files {
+		response, err := loadConfigFile(file)
+		if err != nil {
+			return false, fmt.Errorf("failed to load %v: %v", file, err)
+		}
+		configs = append(configs, response.GetConfig()...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configs = configs
+	s.stamp = stamp
+	return true, nil
+}
+
+// configFiles lists the json and yaml files at the path in order, which is either a file or a
+// directory, and returns them with their stamp
+func configFiles(path string) ([]string, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", err
+	}
+	infos := []os.FileInfo{info}
+	dir := filepath.Dir(path)
+	if info.IsDir() {
+		if infos, err = ioutil.ReadDir(path); err != nil {
+			return nil, "", err
+		}
+		dir = path
+	}
+
+	var files []string
+	var stamp strings.Builder
+	for _, info := range infos {
+		switch strings.ToLower(filepath.Ext(info.Name())) {
+		case ".json", ".yaml", ".yml":
+			if info.IsDir() {
+				continue
+			}
+			files = append(files, filepath.Join(dir, info.Name()))
+			fmt.Fprintf(&stamp, "%v %v %v\n", info.Name(), info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	sort.Strings(files)
+	return files, stamp.String(), nil
+}
+
+// loadConfigFile loads a ClientStatusResponse or an Envoy config dump from a json or yaml file. The
+// node id of an Envoy without one in its bootstrap is the name of the file.
+func loadConfigFile(file string) (*csdspb_v3.ClientStatusResponse, error) {
+	js, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if ext := strings.ToLower(filepath.Ext(file)); ext == ".yaml" || ext == ".yml" {
+		if js, err = yaml.YAMLToJSON(js); err != nil {
+			return nil, err
+		}
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(js, &fields); err != nil {
+		return nil, err
+	}
+	if _, ok := fields["configs"]; ok {
+		response, err := configDumpToResponse(js)
+		if err != nil {
+			return nil, err
+		}
+		if node := response.GetConfig()[0].GetNode(); node.GetId() == "" {
+			node.Id = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
+		return response, nil
+	}
+
+	response := &csdspb_v3.ClientStatusResponse{}
+	m := protojson.UnmarshalOptions{Resolver: &clientutil.AdminTypeResolver{}}
+	if err := m.Unmarshal(js, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	clientutil "envoy-tools/csds-client/client/util"
+	"fmt"
+	"io"
+	"net"
+
+	envoy_admin_v3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	csdspb_v2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// configSource returns the client configs to answer a CSDS request with
+type configSource func(ctx context.Context, request *csdspb_v3.ClientStatusRequest) (*csdspb_v3.ClientStatusResponse, error)
+
+// csdsServer is a CSDS server that answers each request with the client configs from the source
+// that match the NodeMatchers of the request
+type csdsServer struct {
+	source configSource
+}
+
+// newCsdsServer creates a grpc server that serves the configs from the source over both the v2 and
+// v3 CSDS api. The v2 requests and responses are converted from and to v3, since the v2 and v3 CSDS
+// api are wire compatible.
+func newCsdsServer(source configSource) *grpc.Server {
+	s := &csdsServer{source: source}
+	grpcServer := grpc.NewServer()
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(grpcServer, s)
+	csdspb_v2.RegisterClientStatusDiscoveryServiceServer(grpcServer, &csdsServerV2{s})
+	return grpcServer
+}
+
+// serveCsds serves the configs from the source over CSDS on the address until the server fails
+func serveCsds(address string, source configSource) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving CSDS on %v\n", listener.Addr())
+	return newCsdsServer(source).Serve(listener)
+}
+
+func (s *csdsServer) FetchClientStatus(ctx context.Context, request *csdspb_v3.ClientStatusRequest) (*csdspb_v3.ClientStatusResponse, error) {
+	response, err := s.source(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return filterByNodeMatchers(response, request.GetNodeMatchers()), nil
+}
+
+func (s *csdsServer) StreamClientStatus(stream csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusServer) error {
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		response, err := s.FetchClientStatus(stream.Context(), request)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
+
+// filterByNodeMatchers returns the response with only the clients that match the NodeMatchers
+func filterByNodeMatchers(response *csdspb_v3.ClientStatusResponse, matchers []*envoy_type_matcher_v3.NodeMatcher) *csdspb_v3.ClientStatusResponse {
+	filtered := &csdspb_v3.ClientStatusResponse{}
+	for _, config := range response.GetConfig() {
+		if clientutil.MatchNode(matchers, config.GetNode()) {
+			filtered.Config = append(filtered.Config, config)
+		}
+	}
+	return filtered
+}
+
+// csdsServerV2 serves the v2 CSDS api with the v3 server
+type csdsServerV2 struct {
+	s *csdsServer
+}
+
+func (v *csdsServerV2) FetchClientStatus(ctx context.Context, request *csdspb_v2.ClientStatusRequest) (*csdspb_v2.ClientStatusResponse, error) {
+	requestV3 := &csdspb_v3.ClientStatusRequest{}
+	if err := clientutil.Upgrade(request, requestV3); err != nil {
+		return nil, err
+	}
+	responseV3, err := v.s.FetchClientStatus(ctx, requestV3)
+	if err != nil {
+		return nil, err
+	}
+	response := &csdspb_v2.ClientStatusResponse{}
+	// the v2 api has no generic xds configs, so they are sent as per xds configs
+	for _, config := range responseV3.GetConfig() {
+		if len(config.GetGenericXdsConfigs()) > 0 {
+			config = proto.Clone(config).(*csdspb_v3.ClientConfig)
+			config.XdsConfig = append(config.XdsConfig, perXdsConfigs(config.GetGenericXdsConfigs())...)
+			config.GenericXdsConfigs = nil
+		}
+		b, err := proto.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		configV2 := &csdspb_v2.ClientConfig{}
+		if err := proto.Unmarshal(b, configV2); err != nil {
+			return nil, err
+		}
+		response.Config = append(response.Config, configV2)
+	}
+	return response, nil
+}
+
+// perXdsConfigs converts the generic xds configs to a per xds config of each xDS type, with the
+// most severe config status of its resources. The endpoints are left out, since the v2 api has no
+// per xds config for them.
+func perXdsConfigs(genericXdsConfigs []*csdspb_v3.ClientConfig_GenericXdsConfig) []*csdspb_v3.PerXdsConfig {
+	listeners := &envoy_admin_v3.ListenersConfigDump{}
+	clusters := &envoy_admin_v3.ClustersConfigDump{}
+	routes := &envoy_admin_v3.RoutesConfigDump{}
+	scopedRoutes := &envoy_admin_v3.ScopedRoutesConfigDump{}
+	statuses := make(map[string]csdspb_v3.ConfigStatus)
+	for _, c := range genericXdsConfigs {
+		xds := clientutil.XdsName(c.GetTypeUrl())
+		switch xds {
+		case "LDS":
+			if c.GetIsStaticResource() {
+				listeners.StaticListeners = append(listeners.StaticListeners, &envoy_admin_v3.ListenersConfigDump_StaticListener{Listener: c.GetXdsConfig(), LastUpdated: c.GetLastUpdated()})
+				break
+			}
+			listener := &envoy_admin_v3.ListenersConfigDump_DynamicListener{Name: c.GetName(), ErrorState: c.GetErrorState(), ClientStatus: c.GetClientStatus()}
+			if c.GetXdsConfig() != nil {
+				listener.ActiveState = &envoy_admin_v3.ListenersConfigDump_DynamicListenerState{VersionInfo: c.GetVersionInfo(), Listener: c.GetXdsConfig(), LastUpdated: c.GetLastUpdated()}
+			}
+			listeners.DynamicListeners = append(listeners.DynamicListeners, listener)
+		case "CDS":
+			if c.GetIsStaticResource() {
+				clusters.StaticClusters = append(clusters.StaticClusters, &envoy_admin_v3.ClustersConfigDump_StaticCluster{Cluster: c.GetXdsConfig(), LastUpdated: c.GetLastUpdated()})
+				break
+			}
+			clusters.DynamicActiveClusters = append(clusters.DynamicActiveClusters, &envoy_admin_v3.ClustersConfigDump_DynamicCluster{VersionInfo: c.GetVersionInfo(), Cluster: c.GetXdsConfig(), LastUpdated: c.GetLastUpdated(), ErrorState: c.GetErrorState(), ClientStatus: c.GetClientStatus()})
+		case "RDS":
+			if c.GetIsStaticResource() {
+				routes.StaticRouteConfigs = append(routes.StaticRouteConfigs, &envoy_admin_v3.RoutesConfigDump_StaticRouteConfig{RouteConfig: c.GetXdsConfig(), LastUpdated: c.GetLastUpdated()})
+				break
+			}
+			routes.DynamicRouteConfigs = append(routes.DynamicRouteConfigs, &envoy_admin_v3.RoutesConfigDump_DynamicRouteConfig{VersionInfo: c.GetVersionInfo(), RouteConfig: c.GetXdsConfig(), LastUpdated: c.GetLastUpdated(), ErrorState: c.GetErrorState(), ClientStatus: c.GetClientStatus()})
+		case "SRDS":
+			scopedRoutes.DynamicScopedRouteConfigs = append(scopedRoutes.DynamicScopedRouteConfigs, &envoy_admin_v3.ScopedRoutesConfigDump_DynamicScopedRouteConfigs{Name: c.GetName(), VersionInfo: c.GetVersionInfo(), ScopedRouteConfigs: []*anypb.Any{c.GetXdsConfig()}, LastUpdated: c.GetLastUpdated()})
+		default:
+			continue
+		}
+		if status, ok := statuses[xds]; !ok || c.GetConfigStatus() > status {
+			// the config statuses are in order of severity: SYNCED, NOT_SENT, STALE, ERROR
+			statuses[xds] = c.GetConfigStatus()
+		}
+	}
+
+	var configs []*csdspb_v3.PerXdsConfig
+	if status, ok := statuses["LDS"]; ok {
+		configs = append(configs, &csdspb_v3.PerXdsConfig{Status: status, PerXdsConfig: &csdspb_v3.PerXdsConfig_ListenerConfig{ListenerConfig: listeners}})
+	}
+	if status, ok := statuses["RDS"]; ok {
+		configs = append(configs, &csdspb_v3.PerXdsConfig{Status: status, PerXdsConfig: &csdspb_v3.PerXdsConfig_RouteConfig{RouteConfig: routes}})
+	}
+	if status, ok := statuses["SRDS"]; ok {
+		configs = append(configs, &csdspb_v3.PerXdsConfig{Status: status, PerXdsConfig: &csdspb_v3.PerXdsConfig_ScopedRouteConfig{ScopedRouteConfig: scopedRoutes}})
+	}
+	if status, ok := statuses["CDS"]; ok {
+		configs = append(configs, &csdspb_v3.PerXdsConfig{Status: status, PerXdsConfig: &csdspb_v3.PerXdsConfig_ClusterConfig{ClusterConfig: clusters}})
+	}
+	return configs
+}
+
+func (v *csdsServerV2) StreamClientStatus(stream csdspb_v2.ClientStatusDiscoveryService_StreamClientStatusServer) error {
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		response, err := v.FetchClientStatus(stream.Context(), request)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
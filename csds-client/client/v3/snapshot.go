@@ -0,0 +1,72 @@
+package client
+
+import (
+	clientutil "envoy-tools/csds-client/client/util"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// Fetch fetches the clients once from the control planes, or from the Envoy admin with -source
+// envoy_admin, and returns the clients that match -filter and the node id filter
+func (c *ClientV3) Fetch() (clientutil.Snapshot, error) {
+	var response *csdspb_v3.ClientStatusResponse
+	var err error
+	if c.opts.Source == "envoy_admin" {
+		response, err = fetchEnvoyAdminResponse(c.opts.AdminUri)
+	} else {
+		response, err = c.fetchOnce()
+	}
+	if err != nil {
+		return clientutil.Snapshot{}, err
+	}
+	if response, err = c.selectResponse(response); err != nil {
+		return clientutil.Snapshot{}, err
+	}
+	configs, err := parseClientConfigs(response, c.opts)
+	if err != nil {
+		return clientutil.Snapshot{}, err
+	}
+	return clientutil.Snapshot{Response: response, Clients: configs}, nil
+}
+
+// fetchOnce connects to the control planes and fetches the merged response to all the queries once
+func (c *ClientV3) fetchOnce() (*csdspb_v3.ClientStatusResponse, error) {
+	uris, err := clientutil.ServiceUris(c.opts)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := c.connectTargets(uris)
+	defer closeTargets(targets)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.fetchAll(c.outgoingContext(), targets, c.queries)
+	for _, t := range targets {
+		if t.stream != nil {
+			t.stream.CloseSend()
+		}
+	}
+	return response, err
+}
+
+// selectResponse returns the response with only the clients that match -filter and the node id filter
+func (c *ClientV3) selectResponse(response *csdspb_v3.ClientStatusResponse) (*csdspb_v3.ClientStatusResponse, error) {
+	if c.opts.Filter != "" {
+		filter, err := clientutil.ParseFilter(c.opts.Filter)
+		if err != nil {
+			return nil, err
+		}
+		response = filterResponse(response, filter)
+	}
+	selected := &csdspb_v3.ClientStatusResponse{}
+	for _, config := range response.GetConfig() {
+		matched, err := matchNodeId(config.GetNode().GetId(), c.opts)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			selected.Config = append(selected.Config, config)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	clientutil "envoy-tools/csds-client/client/util"
+	"fmt"
+)
+
+// Verify compares the config that the control planes report for the Envoy at -admin_uri with the
+// config that the Envoy has applied according to its config dump, and prints out the divergences.
+// The Envoy is matched to the client in the CSDS response by its node id. An error is returned if
+// any divergence is found.
+func (c *ClientV3) Verify() error {
+	proxyResponse, err := fetchEnvoyAdminResponse(c.opts.AdminUri)
+	if err != nil {
+		return err
+	}
+	proxy := parseClientConfig(proxyResponse.GetConfig()[0])
+	if proxy.Id == "" {
+		return fmt.Errorf("missing node id in the config dump of %v", c.opts.AdminUri)
+	}
+
+	response, err := c.fetchOnce()
+	if err != nil {
+		return err
+	}
+
+	for _, config := range response.GetConfig() {
+		if config.GetNode().GetId() == proxy.Id {
+			fmt.Printf("Node: %v\n\n", proxy.Id)
+			divergences, compared := clientutil.VerifyResources(parseClientConfig(config), proxy)
+			clientutil.PrintDivergences(divergences, compared, "Control Plane", "Proxy")
+			if len(divergences) > 0 {
+				return fmt.Errorf("found %d divergences between the control plane and the proxy", len(divergences))
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("node %v is not reported by the control plane", proxy.Id)
+}
@@ -0,0 +1,718 @@
+package main
+
+import (
+	clientutil "envoy-tools/csds-client/client/util"
+	client_v2 "envoy-tools/csds-client/client/v2"
+	client_v3 "envoy-tools/csds-client/client/v3"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// version is the version of csds-client, which is set at build time with
+// -ldflags "-X main.version=<version>"
+var version = "dev"
+
+// watchIntervalDefault is the interval of watch if -monitor_interval is not set
+const watchIntervalDefault = 5 * time.Second
+
+// the groups of the flags that the commands share
+var (
+	connectionFlags = []string{"service_uri", "service_uri_file", "parallelism", "platform", "authn_mode", "api_version", "request_file", "request_yaml", "request_name", "node_id", "node_id_prefix", "metadata", "gcp_project", "gcp_network", "gcp_mesh", "jwt_file", "context", "contexts_file"}
+	sourceFlags     = []string{"source", "admin_uri"}
+	selectFlags     = []string{"filter_mode", "filter_pattern", "filter"}
+	redactFlags     = []string{"no_redact", "redact_fields"}
+	outputFlags     = []string{"output_file", "query", "summary", "group_by_metadata", "visualization", "monitor_interval", "history_dir", "hooks_file"}
+)
+
+// command is a subcommand of csds-client with its own flags
+type command struct {
+	name string
+	// args describes the positional arguments in the usage, e.g. "<node>"
+	args    string
+	summary string
+	// flags are the names of the flags of the command, which are bound to the same variables for
+	// all the commands
+	flags []string
+	// run runs the command with the positional arguments
+	run func(args []string) error
+}
+
+// commands are the subcommands in the order of the usage. They are set in init since completion
+// refers to them.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:    "get",
+			summary: "print the xDS config of the clients, the default command",
+			flags:   flagGroups(connectionFlags, sourceFlags, selectFlags, redactFlags, outputFlags),
+			run:     noArgs(runGet),
+		},
+		{
+			name:    "watch",
+			summary: "print the xDS config of the clients on every -monitor_interval (5s by default)",
+			flags:   flagGroups(connectionFlags, sourceFlags, selectFlags, redactFlags, outputFlags),
+			run:     noArgs(runWatch),
+		},
+		{
+			name:    "graph",
+			summary: "print the graph of the xDS resources of the clients in dot",
+			flags:   flagGroups(connectionFlags, sourceFlags, selectFlags, []string{"output_file"}),
+			run:     noArgs(runGraph),
+		},
+		{
+			name:    "describe",
+			args:    "<node>",
+			summary: "print the node, config status and resources of a client",
+			flags:   flagGroups(connectionFlags, sourceFlags),
+			run:     runDescribe,
+		},
+		{
+			name:    "diff",
+			args:    "[<node> <node>]",
+			summary: "compare the resources of two clients, or of the client of -node at two -at times of -history_dir",
+			flags:   flagGroups(connectionFlags, sourceFlags, []string{"history_dir", "node", "at"}),
+			run:     runDiff,
+		},
+		{
+			name:    "lint",
+			summary: "check the xDS config of the clients for rejected resources and dangling references",
+			flags:   flagGroups(connectionFlags, sourceFlags, selectFlags),
+			run:     noArgs(runLint),
+		},
+		{
+			name:    "endpoints",
+			args:    "[node]",
+			summary: "print the endpoints of the clusters of the clients by health and locality, and the clusters in panic",
+			flags:   flagGroups(connectionFlags, sourceFlags, selectFlags),
+			run:     runEndpoints,
+		},
+		{
+			name:    "clusters",
+			args:    "[node]",
+			summary: "print the discovery type, load balancing policy, circuit breakers, outlier detection, timeout, TLS and health checks of the clusters of the clients",
+			flags:   flagGroups(connectionFlags, sourceFlags, selectFlags),
+			run:     runClusters,
+		},
+		{
+			name:    "listeners",
+			args:    "[node]",
+			summary: "print the filter chains of the listeners of the clients with their match criteria, network filters, HTTP filters and routes",
+			flags:   flagGroups(connectionFlags, sourceFlags, selectFlags),
+			run:     runListeners,
+		},
+		{
+			name:    "match-listener",
+			args:    "<node>",
+			summary: "find the listener and the filter chain that the client selects for a connection, and why",
+			flags:   flagGroups(connectionFlags, sourceFlags, []string{"dst_address", "src_address", "sni", "alpn"}),
+			run:     runMatchListener,
+		},
+		{
+			name:    "certs",
+			args:    "[node]",
+			summary: "list the TLS contexts of the clusters and listeners of the clients with their SNI, SAN matchers, SDS secrets and certificates, flagging the expiring ones",
+			flags:   flagGroups(connectionFlags, sourceFlags, selectFlags, []string{"expiry_window"}),
+			run:     runCerts,
+		},
+		{
+			name:    "validate-request",
+			args:    "<file>...",
+			summary: "check request yaml files for unknown fields, misspelled keys and values of the wrong type",
+			flags:   []string{"api_version"},
+			run:     runValidateRequest,
+		},
+		{
+			name:    "verify",
+			summary: "compare the config of the control plane with the config applied by the Envoy at -admin_uri",
+			flags:   flagGroups(connectionFlags, []string{"admin_uri"}),
+			run:     noArgs(runVerify),
+		},
+		{
+			name:    "history",
+			summary: "list the changes recorded in -history_dir, or restore the clients at the time of -at",
+			flags:   []string{"history_dir", "node", "at", "since", "until"},
+			run:     noArgs(runHistory),
+		},
+		{
+			name:    "tui",
+			summary: "browse the clients in an interactive terminal UI",
+			flags:   flagGroups(connectionFlags, sourceFlags, selectFlags, redactFlags, []string{"monitor_interval"}),
+			run:     noArgs(runTui),
+		},
+		{
+			name:    "serve",
+			summary: "serve CSDS from the responses and config dumps at -serve_path",
+			flags:   []string{"serve_path", "listen_address"},
+			run:     noArgs(runServe),
+		},
+		{
+			name:    "proxy",
+			summary: "serve CSDS aggregated from the control planes",
+			flags:   flagGroups(connectionFlags, []string{"listen_address"}),
+			run:     noArgs(runProxy),
+		},
+		{
+			name:    "gateway",
+			summary: "serve an HTTP/JSON gateway for CSDS queries",
+			flags:   flagGroups(connectionFlags, redactFlags, []string{"listen_address"}),
+			run:     noArgs(runGateway),
+		},
+		{
+			name:    "use-context",
+			args:    "[context]",
+			summary: "list the contexts, or switch the current context",
+			flags:   []string{"contexts_file"},
+			run:     runUseContext,
+		},
+		{
+			name:    "completion",
+			args:    "<bash|zsh|fish>",
+			summary: "print the shell completion script",
+			run:     runCompletion,
+		},
+		{
+			name:    "version",
+			summary: "print the version",
+			run:     noArgs(runVersion),
+		},
+		{
+			name:    "help",
+			args:    "[command]",
+			summary: "print the usage of a command",
+			run:     runHelp,
+		},
+	}
+}
+
+// flagGroups concatenates the groups of flags
+func flagGroups(groups ...[]string) []string {
+	var flags []string
+	for _, group := range groups {
+		flags = append(flags, group...)
+	}
+	return flags
+}
+
+// findCommand returns the command with the name, or nil if there is none
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// flagSet returns the flags of the command, bound to the variables of the flags of the command line
+func (cmd *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	for _, name := range cmd.flags {
+		f := flag.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: csds-client %v", cmd.name)
+		if len(cmd.flags) > 0 {
+			fmt.Fprint(out, " [flags]")
+		}
+		if cmd.args != "" {
+			fmt.Fprintf(out, " %v", cmd.args)
+		}
+		fmt.Fprintf(out, "\n\n%v\n", strings.ToUpper(cmd.summary[:1])+cmd.summary[1:])
+		if len(cmd.flags) > 0 {
+			fmt.Fprint(out, "\nFlags:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// usage prints the usage of csds-client with the list of commands
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprint(out, "Usage: csds-client [command] [flags] [args]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-18s %v\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprint(out, "\nWithout a command, the flags are the flags of get, e.g. csds-client -service_uri <uri> is\n")
+	fmt.Fprint(out, "csds-client get -service_uri <uri>. Run csds-client help <command> for the flags of a command.\n")
+}
+
+// noArgs wraps the run function of a command that takes no positional argument
+func noArgs(run func() error) func(args []string) error {
+	return func(args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("unexpected arguments: %v", strings.Join(args, " "))
+		}
+		return run()
+	}
+}
+
+// fetchSnapshot creates the client and fetches the clients once
+func fetchSnapshot() (clientutil.Snapshot, error) {
+	c, err := newClient()
+	if err != nil {
+		return clientutil.Snapshot{}, err
+	}
+	f, ok := c.(interface {
+		Fetch() (clientutil.Snapshot, error)
+	})
+	if !ok {
+		return clientutil.Snapshot{}, fmt.Errorf("fetching once is not supported with -api_version %v", apiVersion)
+	}
+	return f.Fetch()
+}
+
+func runGet() error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	return c.Run()
+}
+
+func runWatch() error {
+	if monitorInterval == 0 {
+		monitorInterval = watchIntervalDefault
+	}
+	return runGet()
+}
+
+func runGraph() error {
+	snapshot, err := fetchSnapshot()
+	if err != nil {
+		return err
+	}
+	return clientutil.PrintGraph(snapshot.Response, configFile)
+}
+
+func runDescribe(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("describe takes the id of a node")
+	}
+	snapshot, err := fetchSnapshot()
+	if err != nil {
+		return err
+	}
+	c, err := clientutil.FindClient(snapshot.Clients, args[0])
+	if err != nil {
+		return err
+	}
+	clientutil.PrintDescribe(c)
+	return nil
+}
+
+func runDiff(args []string) error {
+	if len(historyAt) > 0 {
+		if len(args) > 0 {
+			return fmt.Errorf("diff takes either the ids of two nodes or -at, not both")
+		}
+		return runHistoryDiff()
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("diff takes the ids of two nodes")
+	}
+	snapshot, err := fetchSnapshot()
+	if err != nil {
+		return err
+	}
+	first, err := clientutil.FindClient(snapshot.Clients, args[0])
+	if err != nil {
+		return err
+	}
+	second, err := clientutil.FindClient(snapshot.Clients, args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("First: %v\nSecond: %v\n\n", first.Id, second.Id)
+	divergences, compared := clientutil.DiffResources(first, second)
+	clientutil.PrintDivergences(divergences, compared, "First", "Second")
+	return nil
+}
+
+// runHistoryDiff compares the resources of the client of -node at the two times of -at in the history
+func runHistoryDiff() error {
+	if len(historyAt) != 2 || historyNode == "" {
+		return fmt.Errorf("diff in the history takes two -at times and -node")
+	}
+	h, err := openHistory()
+	if err != nil {
+		return err
+	}
+	id, err := h.FindId(historyNode)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var times [2]time.Time
+	var configs [2]clientutil.ClientConfig
+	for i, at := range historyAt {
+		if times[i], err = clientutil.ParseHistoryTime(at, now); err != nil {
+			return err
+		}
+		clients, err := h.At(times[i])
+		if err != nil {
+			return err
+		}
+		// a client that is not recorded at the time has no resources
+		configs[i] = clientutil.ClientConfig{Id: id}
+		for _, c := range clients {
+			if c.Id == id {
+				configs[i] = c
+			}
+		}
+	}
+	fmt.Printf("Client ID: %v\nFirst: %v\nSecond: %v\n\n", id, times[0].Local().Format("2006-01-02 15:04:05"), times[1].Local().Format("2006-01-02 15:04:05"))
+	divergences, compared := clientutil.DiffHistory(configs[0], configs[1])
+	clientutil.PrintDivergences(divergences, compared, "First", "Second")
+	return nil
+}
+
+func runLint() error {
+	snapshot, err := fetchSnapshot()
+	if err != nil {
+		return err
+	}
+	problems := clientutil.Lint(snapshot.Clients)
+	clientutil.PrintLintProblems(problems)
+	var failed int
+	for _, p := range problems {
+		if p.Severity == clientutil.LintError {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("found %d errors", failed)
+	}
+	return nil
+}
+
+// selectClients returns the client of the node if the node is set, or all the clients otherwise
+func selectClients(clients []clientutil.ClientConfig, args []string, command string) ([]clientutil.ClientConfig, error) {
+	switch len(args) {
+	case 0:
+		return clients, nil
+	case 1:
+		c, err := clientutil.FindClient(clients, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return []clientutil.ClientConfig{c}, nil
+	default:
+		return nil, fmt.Errorf("%v takes at most the id of a node", command)
+	}
+}
+
+func runEndpoints(args []string) error {
+	snapshot, err := fetchSnapshot()
+	if err != nil {
+		return err
+	}
+	clients, err := selectClients(snapshot.Clients, args, "endpoints")
+	if err != nil {
+		return err
+	}
+	var clusters []clientutil.ClusterEndpoints
+	for _, c := range clients {
+		clusters = append(clusters, clientutil.Endpoints(c)...)
+	}
+	clientutil.PrintEndpoints(clusters)
+	return nil
+}
+
+func runClusters(args []string) error {
+	snapshot, err := fetchSnapshot()
+	if err != nil {
+		return err
+	}
+	clients, err := selectClients(snapshot.Clients, args, "clusters")
+	if err != nil {
+		return err
+	}
+	var clusters []clientutil.ClusterSummary
+	for _, c := range clients {
+		clusters = append(clusters, clientutil.Clusters(c)...)
+	}
+	clientutil.PrintClusters(clusters)
+	return nil
+}
+
+func runListeners(args []string) error {
+	snapshot, err := fetchSnapshot()
+	if err != nil {
+		return err
+	}
+	clients, err := selectClients(snapshot.Clients, args, "listeners")
+	if err != nil {
+		return err
+	}
+	var listeners []clientutil.ListenerSummary
+	for _, c := range clients {
+		listeners = append(listeners, clientutil.Listeners(c)...)
+	}
+	clientutil.PrintListeners(listeners)
+	return nil
+}
+
+func runMatchListener(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("match-listener takes the id of a node")
+	}
+	if dstAddress == "" {
+		return fmt.Errorf("match-listener requires -dst_address")
+	}
+	conn, err := clientutil.ParseConnection(dstAddress, sni, alpn, srcAddress)
+	if err != nil {
+		return err
+	}
+	snapshot, err := fetchSnapshot()
+	if err != nil {
+		return err
+	}
+	c, err := clientutil.FindClient(snapshot.Clients, args[0])
+	if err != nil {
+		return err
+	}
+	m, err := clientutil.MatchListener(c, conn)
+	if err != nil {
+		return err
+	}
+	clientutil.PrintListenerMatch(m)
+	return nil
+}
+
+func runCerts(args []string) error {
+	snapshot, err := fetchSnapshot()
+	if err != nil {
+		return err
+	}
+	clients, err := selectClients(snapshot.Clients, args, "certs")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var contexts []clientutil.TlsContextSummary
+	for _, c := range clients {
+		contexts = append(contexts, clientutil.TlsContexts(c, now, expiryWindow)...)
+	}
+	clientutil.PrintTlsContexts(contexts, now, expiryWindow)
+	return nil
+}
+
+// runValidateRequest checks the files without sending any request, so it does not need a client
+func runValidateRequest(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("validate-request takes the request yaml files")
+	}
+	validate := client_v3.ValidateRequestFile
+	if apiVersion == "v2" {
+		validate = client_v2.ValidateRequestFile
+	}
+	var failed int
+	for _, path := range args {
+		if err := validate(path); err != nil {
+			fmt.Println(err)
+			failed++
+			continue
+		}
+		fmt.Printf("%v: OK\n", path)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d request files are invalid", failed, len(args))
+	}
+	return nil
+}
+
+func runVerify() error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	v, ok := c.(interface{ Verify() error })
+	if !ok {
+		return fmt.Errorf("verify is only supported with -api_version v3")
+	}
+	return v.Verify()
+}
+
+// openHistory opens the history store at -history_dir, which must exist
+func openHistory() (*clientutil.HistoryStore, error) {
+	if historyDir == "" {
+		return nil, fmt.Errorf("missing -history_dir")
+	}
+	if _, err := os.Stat(historyDir); err != nil {
+		return nil, fmt.Errorf("no history at %v: %v", historyDir, err)
+	}
+	return clientutil.OpenHistory(historyDir, nil)
+}
+
+func runHistory() error {
+	h, err := openHistory()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if len(historyAt) > 1 {
+		return fmt.Errorf("history takes at most one -at")
+	}
+	if len(historyAt) == 1 {
+		at, err := clientutil.ParseHistoryTime(historyAt[0], now)
+		if err != nil {
+			return err
+		}
+		clients, err := h.At(at)
+		if err != nil {
+			return err
+		}
+		if historyNode != "" {
+			c, err := clientutil.FindClient(clients, historyNode)
+			if err != nil {
+				return err
+			}
+			clients = []clientutil.ClientConfig{c}
+		}
+		if len(clients) == 0 {
+			fmt.Println("No clients recorded")
+		}
+		for i, c := range clients {
+			if i > 0 {
+				fmt.Println()
+			}
+			clientutil.PrintDescribe(c)
+		}
+		return nil
+	}
+
+	var since, until time.Time
+	if historySince != "" {
+		if since, err = clientutil.ParseHistoryTime(historySince, now); err != nil {
+			return err
+		}
+	}
+	if historyUntil != "" {
+		if until, err = clientutil.ParseHistoryTime(historyUntil, now); err != nil {
+			return err
+		}
+	}
+	var id string
+	if historyNode != "" {
+		if id, err = h.FindId(historyNode); err != nil {
+			return err
+		}
+	}
+	clientutil.PrintHistoryChanges(h.Changes(since, until, id))
+	return nil
+}
+
+func runTui() error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	t, ok := c.(interface{ RunTui() error })
+	if !ok {
+		return fmt.Errorf("tui is not supported with -api_version %v", apiVersion)
+	}
+	return t.RunTui()
+}
+
+// runServe serves the files, it does not send any request, so it does not need a client
+func runServe() error {
+	return client_v3.Serve(clientOptions())
+}
+
+func runProxy() error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	p, ok := c.(interface{ ServeProxy() error })
+	if !ok {
+		return fmt.Errorf("proxy is only supported with -api_version v3")
+	}
+	return p.ServeProxy()
+}
+
+func runGateway() error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	g, ok := c.(interface{ ServeGateway() error })
+	if !ok {
+		return fmt.Errorf("gateway is only supported with -api_version v3")
+	}
+	return g.ServeGateway()
+}
+
+// runUseContext only updates the contexts file
+func runUseContext(args []string) error {
+	contexts, err := clientutil.LoadContexts(contextsFile)
+	if err != nil {
+		return err
+	}
+	switch len(args) {
+	case 0:
+		clientutil.PrintContexts(contexts)
+		return nil
+	case 1:
+		if err := clientutil.UseContext(contextsFile, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Switched to context %v\n", args[0])
+		return nil
+	default:
+		return fmt.Errorf("use-context takes at most one context")
+	}
+}
+
+func runCompletion(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("completion takes the shell, one of bash, zsh and fish")
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion())
+	case "zsh":
+		fmt.Print(zshCompletion())
+	case "fish":
+		fmt.Print(fishCompletion())
+	default:
+		return fmt.Errorf("unsupported shell %v, list of supported shells: bash, zsh, fish", args[0])
+	}
+	return nil
+}
+
+func runVersion() error {
+	v := version
+	// the module version is known if it is installed with go install
+	if info, ok := debug.ReadBuildInfo(); ok && v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+	fmt.Printf("csds-client %v %v %v/%v\n", v, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
+
+func runHelp(args []string) error {
+	if len(args) == 0 {
+		flag.CommandLine.SetOutput(os.Stdout)
+		usage()
+		return nil
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		return fmt.Errorf("unknown command %v", args[0])
+	}
+	fs := cmd.flagSet()
+	fs.SetOutput(os.Stdout)
+	fs.Usage()
+	return nil
+}
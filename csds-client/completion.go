@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// flagValues are the values to complete for the flags that take one of a few values
+var flagValues = map[string][]string{
+	"api_version": {"v2", "v3"},
+	"authn_mode":  {"auto", "jwt", "none"},
+	"filter_mode": {"prefix", "suffix", "regex"},
+	"platform":    {"gcp"},
+	"source":      {"csds", "envoy_admin"},
+}
+
+// commandArgs returns the values to complete for the positional arguments of the command
+func commandArgs(name string) []string {
+	switch name {
+	case "completion":
+		return []string{"bash", "zsh", "fish"}
+	case "help":
+		return commandNames()
+	}
+	return nil
+}
+
+// isFileFlag checks if the flag takes a path, for which the files are completed
+func isFileFlag(name string) bool {
+	return strings.HasSuffix(name, "_file") || name == "serve_path"
+}
+
+// isBoolFlag checks if the flag takes no value
+func isBoolFlag(name string) bool {
+	b, ok := flag.Lookup(name).Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// commandNames returns the names of the commands
+func commandNames() []string {
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+// dashed returns the flag names with the leading dash
+func dashed(names []string) []string {
+	var flags []string
+	for _, name := range names {
+		flags = append(flags, "-"+name)
+	}
+	return flags
+}
+
+// sortedFlagValues returns the flags with values to complete in order
+func sortedFlagValues() []string {
+	var names []string
+	for name := range flagValues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bashCompletion returns the bash completion script. The word after csds-client is the command if
+// it is not a flag, and the flags of get are completed otherwise.
+func bashCompletion() string {
+	var b strings.Builder
+	b.WriteString(`# bash completion for csds-client, e.g. source <(csds-client completion bash)
+_csds_client() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" cmd=get words
+	if [[ $COMP_CWORD -gt 1 && ${COMP_WORDS[1]} != -* ]]; then
+		cmd="${COMP_WORDS[1]}"
+	fi
+	case "$prev" in
+`)
+	for _, name := range sortedFlagValues() {
+		fmt.Fprintf(&b, "\t-%v)\n\t\tCOMPREPLY=($(compgen -W \"%v\" -- \"$cur\"))\n\t\treturn\n\t\t;;\n", name, strings.Join(flagValues[name], " "))
+	}
+	// the other flags with a value complete nothing, or the files with the default completion
+	var valueFlags []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, ok := flagValues[f.Name]; !ok && !isBoolFlag(f.Name) {
+			valueFlags = append(valueFlags, "-"+f.Name)
+		}
+	})
+	fmt.Fprintf(&b, "\t%v)\n\t\tCOMPREPLY=()\n\t\treturn\n\t\t;;\n\tesac\n", strings.Join(valueFlags, "|"))
+
+	b.WriteString("\tcase \"$cmd\" in\n")
+	for _, cmd := range commands {
+		words := append(dashed(cmd.flags), commandArgs(cmd.name)...)
+		fmt.Fprintf(&b, "\t%v)\n\t\twords=\"%v\"\n\t\t;;\n", cmd.name, strings.Join(words, " "))
+	}
+	fmt.Fprintf(&b, `	esac
+	if [[ $COMP_CWORD -eq 1 ]]; then
+		words="%v $words"
+	fi
+	COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+complete -o default -F _csds_client csds-client
+`, strings.Join(commandNames(), " "))
+	return b.String()
+}
+
+// zshCompletion returns the zsh completion script, which runs the bash completion in zsh
+func zshCompletion() string {
+	return "# zsh completion for csds-client, e.g. source <(csds-client completion zsh)\n" +
+		"autoload -U +X compinit && compinit\n" +
+		"autoload -U +X bashcompinit && bashcompinit\n" +
+		bashCompletion()
+}
+
+// fishCompletion returns the fish completion script. The flags are old style options in fish, as
+// they start with a single dash.
+func fishCompletion() string {
+	var b strings.Builder
+	b.WriteString("# fish completion for csds-client, e.g. csds-client completion fish | source\n")
+	b.WriteString("complete -c csds-client -f\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "complete -c csds-client -n __fish_use_subcommand -a %v -d %v\n", cmd.name, fishQuote(cmd.summary))
+	}
+	writeFlags := func(condition string, names []string) {
+		for _, name := range names {
+			fmt.Fprintf(&b, "complete -c csds-client -n %v -o %v", fishQuote(condition), name)
+			switch {
+			case flagValues[name] != nil:
+				fmt.Fprintf(&b, " -x -a %v", fishQuote(strings.Join(flagValues[name], " ")))
+			case isFileFlag(name):
+				b.WriteString(" -r -F")
+			case !isBoolFlag(name):
+				b.WriteString(" -x")
+			}
+			fmt.Fprintf(&b, " -d %v\n", fishQuote(flag.Lookup(name).Usage))
+		}
+	}
+	// without a command, the flags are the flags of get
+	writeFlags("__fish_use_subcommand", findCommand("get").flags)
+	for _, cmd := range commands {
+		condition := "__fish_seen_subcommand_from " + cmd.name
+		writeFlags(condition, cmd.flags)
+		if args := commandArgs(cmd.name); args != nil {
+			fmt.Fprintf(&b, "complete -c csds-client -n %v -a %v\n", fishQuote(condition), fishQuote(strings.Join(args, " ")))
+		}
+		if strings.Contains(cmd.args, "<file>") {
+			fmt.Fprintf(&b, "complete -c csds-client -n %v -F\n", fishQuote(condition))
+		}
+	}
+	return b.String()
+}
+
+// fishQuote quotes the string in single quotes for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
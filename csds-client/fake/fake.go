@@ -0,0 +1,217 @@
+// Package fake provides an in-process fake CSDS server for end to end tests of the client. The
+// server serves both the v2 and v3 CSDS api on a local listener, replies to each request with the
+// next step of a script, and records the requests that it receives.
+package fake
+
+import (
+	"context"
+	clientutil "envoy-tools/csds-client/client/util"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	csdspb_v2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// Step is the scripted reply of the server to one request
+type Step struct {
+	// Response is the ClientStatusResponse to reply with, of either api version. It is converted to
+	// the api version of the request, since the v2 and v3 CSDS api are wire compatible.
+	Response proto.Message
+	// Err is returned instead of a response if it is set, which ends the stream
+	Err error
+	// Delay is how long the server waits before it replies
+	Delay time.Duration
+	// ApplyNodeMatchers keeps only the clients of the response that match the NodeMatchers of the
+	// request, as a control plane does
+	ApplyNodeMatchers bool
+}
+
+// Request is a request received by the server, together with the metadata of its rpc
+type Request struct {
+	// Request is a *csdspb_v2.ClientStatusRequest or a *csdspb_v3.ClientStatusRequest
+	Request  proto.Message
+	Metadata metadata.MD
+}
+
+// Server is a fake CSDS server
+type Server struct {
+	mu       sync.Mutex
+	steps    []Step
+	next     int
+	requests []Request
+
+	listener   net.Listener
+	grpcServer *grpc.Server
+}
+
+// NewServer starts a fake CSDS server on a local port, which replies to the requests with the steps
+// in order. Once the steps run out, the last step is repeated, and an empty response is sent if
+// there is no step.
+func NewServer(steps ...Step) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		steps:      steps,
+		listener:   listener,
+		grpcServer: grpc.NewServer(),
+	}
+	csdspb_v2.RegisterClientStatusDiscoveryServiceServer(s.grpcServer, &serverV2{s})
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(s.grpcServer, &serverV3{s})
+	go s.grpcServer.Serve(listener)
+	return s, nil
+}
+
+// Addr returns the address that the server listens on, to be used as -service_uri
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Requests returns the requests that the server has received in order
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Stop stops the server and closes all the open streams
+func (s *Server) Stop() {
+	s.grpcServer.Stop()
+}
+
+// reply records the request and returns the next step, after its delay
+func (s *Server) reply(ctx context.Context, request proto.Message) Step {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Request: request, Metadata: md})
+	var step Step
+	if len(s.steps) > 0 {
+		step = s.steps[s.next]
+		if s.next < len(s.steps)-1 {
+			s.next++
+		}
+	}
+	s.mu.Unlock()
+
+	if step.Delay > 0 {
+		select {
+		case <-time.After(step.Delay):
+		case <-ctx.Done():
+		}
+	}
+	return step
+}
+
+// respond returns the scripted response of the step to the request, as a v3 response
+func respond(step Step, request *csdspb_v3.ClientStatusRequest) (*csdspb_v3.ClientStatusResponse, error) {
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := convert(step.Response, response); err != nil {
+		return nil, err
+	}
+	if !step.ApplyNodeMatchers {
+		return response, nil
+	}
+	matched := &csdspb_v3.ClientStatusResponse{}
+	for _, config := range response.GetConfig() {
+		if clientutil.MatchNode(request.GetNodeMatchers(), config.GetNode()) {
+			matched.Config = append(matched.Config, config)
+		}
+	}
+	return matched, nil
+}
+
+// convert converts the scripted response to the response type of the api version
+func convert(src proto.Message, dst proto.Message) error {
+	if src == nil {
+		return nil
+	}
+	b, err := proto.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(b, dst)
+}
+
+// serverV2 serves the v2 CSDS api
+type serverV2 struct {
+	s *Server
+}
+
+func (v *serverV2) StreamClientStatus(stream csdspb_v2.ClientStatusDiscoveryService_StreamClientStatusServer) error {
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		response, err := v.FetchClientStatus(stream.Context(), request)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
+
+func (v *serverV2) FetchClientStatus(ctx context.Context, request *csdspb_v2.ClientStatusRequest) (*csdspb_v2.ClientStatusResponse, error) {
+	step := v.s.reply(ctx, request)
+	if step.Err != nil {
+		return nil, step.Err
+	}
+	// the v2 request is matched as a v3 request, since they are wire compatible
+	requestV3 := &csdspb_v3.ClientStatusRequest{}
+	if err := convert(request, requestV3); err != nil {
+		return nil, err
+	}
+	responseV3, err := respond(step, requestV3)
+	if err != nil {
+		return nil, err
+	}
+	response := &csdspb_v2.ClientStatusResponse{}
+	if err := convert(responseV3, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// serverV3 serves the v3 CSDS api
+type serverV3 struct {
+	s *Server
+}
+
+func (v *serverV3) StreamClientStatus(stream csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusServer) error {
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		response, err := v.FetchClientStatus(stream.Context(), request)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
+
+func (v *serverV3) FetchClientStatus(ctx context.Context, request *csdspb_v3.ClientStatusRequest) (*csdspb_v3.ClientStatusResponse, error) {
+	step := v.s.reply(ctx, request)
+	if step.Err != nil {
+		return nil, step.Err
+	}
+	return respond(step, request)
+}
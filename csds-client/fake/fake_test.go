@@ -0,0 +1,153 @@
+package fake
+
+import (
+	"context"
+	clientutil "envoy-tools/csds-client/client/util"
+	"testing"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	csdspb_v2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// response returns a response with a client of each id
+func response(ids ...string) *csdspb_v3.ClientStatusResponse {
+	r := &csdspb_v3.ClientStatusResponse{}
+	for _, id := range ids {
+		r.Config = append(r.Config, &csdspb_v3.ClientConfig{Node: &envoy_config_core_v3.Node{Id: id}})
+	}
+	return r
+}
+
+// TestServerSteps tests that the server replies with the responses, errors and delays of the steps
+// in order, repeats the last step, and records the requests with their metadata
+func TestServerSteps(t *testing.T) {
+	delay := 100 * time.Millisecond
+	server, err := NewServer(
+		Step{Response: response("node_1")},
+		Step{Err: status.Error(codes.Unavailable, "fake unavailable")},
+		Step{Response: response("node_2"), Delay: delay},
+	)
+	if err != nil {
+		t.Fatalf("Start fake server error: %v", err)
+	}
+	defer server.Stop()
+	conn, err := clientutil.ConnInsecure(server.Addr())
+	if err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	defer conn.Close()
+	client := csdspb_v3.NewClientStatusDiscoveryServiceClient(conn)
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "Bearer fake_token"))
+
+	for i, want := range []struct {
+		id    string
+		code  codes.Code
+		delay time.Duration
+	}{
+		{id: "node_1"},
+		{code: codes.Unavailable},
+		{id: "node_2", delay: delay},
+		// the last step is repeated
+		{id: "node_2", delay: delay},
+	} {
+		start := time.Now()
+		got, err := client.FetchClientStatus(ctx, &csdspb_v3.ClientStatusRequest{Node: &envoy_config_core_v3.Node{Id: "fake_node_id"}})
+		if elapsed := time.Since(start); elapsed < want.delay {
+			t.Errorf("request %d: replied after %v, want a delay of %v", i, elapsed, want.delay)
+		}
+		if status.Code(err) != want.code {
+			t.Errorf("request %d: error = %v, want %v", i, err, want.code)
+			continue
+		}
+		if err == nil && (len(got.GetConfig()) != 1 || got.GetConfig()[0].GetNode().GetId() != want.id) {
+			t.Errorf("request %d: response = %v, want %v", i, got, want.id)
+		}
+	}
+
+	requests := server.Requests()
+	if len(requests) != 4 {
+		t.Fatalf("want 4 requests, got %v", len(requests))
+	}
+	for _, r := range requests {
+		if id := r.Request.(*csdspb_v3.ClientStatusRequest).GetNode().GetId(); id != "fake_node_id" {
+			t.Errorf("want the request of fake_node_id, got %v", id)
+		}
+		if got := r.Metadata.Get("authorization"); len(got) != 1 || got[0] != "Bearer fake_token" {
+			t.Errorf("want the authorization metadata, got %v", r.Metadata)
+		}
+	}
+
+	// a delay ends when the request is canceled
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.FetchClientStatus(ctx, &csdspb_v3.ClientStatusRequest{}); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("error = %v, want %v", err, codes.DeadlineExceeded)
+	}
+}
+
+// TestServerV2 tests that the v2 api is served with the scripted v3 responses, to which the
+// NodeMatchers of the requests are applied on demand, and that a server without steps replies
+// with empty responses
+func TestServerV2(t *testing.T) {
+	server, err := NewServer(Step{Response: response("node_1", "node_2"), ApplyNodeMatchers: true})
+	if err != nil {
+		t.Fatalf("Start fake server error: %v", err)
+	}
+	defer server.Stop()
+	conn, err := clientutil.ConnInsecure(server.Addr())
+	if err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	defer conn.Close()
+
+	stream, err := csdspb_v2.NewClientStatusDiscoveryServiceClient(conn).StreamClientStatus(context.Background())
+	if err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+	for _, test := range []struct {
+		matchers []*envoy_type_matcher_v3.NodeMatcher
+		want     int
+	}{
+		{want: 2},
+		{matchers: []*envoy_type_matcher_v3.NodeMatcher{{NodeId: &envoy_type_matcher_v3.StringMatcher{MatchPattern: &envoy_type_matcher_v3.StringMatcher_Exact{Exact: "node_2"}}}}, want: 1},
+	} {
+		request := &csdspb_v2.ClientStatusRequest{}
+		if err := convert(&csdspb_v3.ClientStatusRequest{NodeMatchers: test.matchers}, request); err != nil {
+			t.Fatalf("Convert request error: %v", err)
+		}
+		if err := stream.Send(request); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+		got, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv error: %v", err)
+		}
+		if len(got.GetConfig()) != test.want || got.GetConfig()[test.want-1].GetNode().GetId() != "node_2" {
+			t.Errorf("response = %v, want %v clients up to node_2", got, test.want)
+		}
+	}
+	if _, ok := server.Requests()[0].Request.(*csdspb_v2.ClientStatusRequest); !ok {
+		t.Errorf("want the v2 request to be recorded, got %T", server.Requests()[0].Request)
+	}
+
+	empty, err := NewServer()
+	if err != nil {
+		t.Fatalf("Start fake server error: %v", err)
+	}
+	defer empty.Stop()
+	emptyConn, err := clientutil.ConnInsecure(empty.Addr())
+	if err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	defer emptyConn.Close()
+	got, err := csdspb_v2.NewClientStatusDiscoveryServiceClient(emptyConn).FetchClientStatus(context.Background(), &csdspb_v2.ClientStatusRequest{})
+	if err != nil || len(got.GetConfig()) != 0 {
+		t.Errorf("FetchClientStatus() = %v, %v, want an empty response", got, err)
+	}
+}
@@ -2,92 +2,281 @@ package main
 
 import (
 	"envoy-tools/csds-client/client"
+	clientutil "envoy-tools/csds-client/client/util"
 	client_v2 "envoy-tools/csds-client/client/v2"
 	client_v3 "envoy-tools/csds-client/client/v3"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 )
 
 // flag vars
 var uri string
+var uriFile string
+var parallelism int
 var platform string
 var authnMode string
 var apiVersion string
 var requestFile string
 var requestYaml string
+var requestName string
 var jwt string
 var configFile string
 var monitorInterval time.Duration
 var visualization bool
 var filterMode string
 var filterPattern string
+var filter string
+var query string
+var noRedact bool
+var redactFields string
+var summary bool
+var groupByMetadata string
+var source string
+var adminUri string
+var servePath string
+var listenAddress string
+var contextName string
+var contextsFile string
+var nodeId string
+var nodeIdPrefix string
+var metadata listFlag
+var gcpProject string
+var gcpNetwork string
+var gcpMesh string
+var historyDir string
+var historyNode string
+var historyAt listFlag
+var historySince string
+var historyUntil string
+var hooksFile string
+var dstAddress string
+var srcAddress string
+var sni string
+var alpn string
+var expiryWindow time.Duration
+
+// setFlags are the names of the flags set on the command line, before or after the command
+var setFlags map[string]bool
 
 // const default values for flag vars
 const (
 	uriDefault             string        = "trafficdirector.googleapis.com:443"
+	uriFileDefault         string        = ""
+	parallelismDefault     int           = 8
 	platformDefault        string        = "gcp"
 	authnModeDefault       string        = "auto"
 	apiVersionDefault      string        = "v2"
 	requestFileDefault     string        = ""
 	requestYamlDefault     string        = ""
+	requestNameDefault     string        = ""
 	jwtDefault             string        = ""
 	configFileDefault      string        = ""
 	monitorIntervalDefault time.Duration = 0
 	visualizationDefault   bool          = false
 	filterModeDefault      string        = ""
 	filterPatternDefault   string        = ""
+	filterDefault          string        = ""
+	queryDefault           string        = ""
+	noRedactDefault        bool          = false
+	redactFieldsDefault    string        = ""
+	summaryDefault         bool          = false
+	groupByMetadataDefault string        = ""
+	sourceDefault          string        = "csds"
+	adminUriDefault        string        = "localhost:9901"
+	servePathDefault       string        = ""
+	listenAddressDefault   string        = "localhost:18000"
+	contextNameDefault     string        = ""
+	nodeIdDefault          string        = ""
+	nodeIdPrefixDefault    string        = ""
+	gcpProjectDefault      string        = ""
+	gcpNetworkDefault      string        = ""
+	gcpMeshDefault         string        = ""
+	historyDirDefault      string        = ""
+	historyNodeDefault     string        = ""
+	historySinceDefault    string        = ""
+	historyUntilDefault    string        = ""
+	hooksFileDefault       string        = ""
+	dstAddressDefault      string        = ""
+	srcAddressDefault      string        = ""
+	sniDefault             string        = ""
+	alpnDefault            string        = ""
+	expiryWindowDefault    time.Duration = 30 * 24 * time.Hour
 )
 
+// listFlag is a flag that can be repeated, the values are kept in order
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 // init binds flags with variables
 func init() {
-	flag.StringVar(&uri, "service_uri", uriDefault, "the uri of the service to connect to")
+	flag.StringVar(&uri, "service_uri", uriDefault, "the uri of the service to connect to, or comma separated uris of several control planes to query")
+	flag.StringVar(&uriFile, "service_uri_file", uriFileDefault, "file that lists the uris of the control planes to query, one per line")
+	flag.IntVar(&parallelism, "parallelism", parallelismDefault, "the maximum number of control planes to query concurrently")
 	flag.StringVar(&platform, "platform", platformDefault, "the platform (e.g. gcp, aws,  ...)")
-	flag.StringVar(&authnMode, "authn_mode", authnModeDefault, "the method to use for authentication (e.g. auto, jwt, ...)")
+	flag.StringVar(&authnMode, "authn_mode", authnModeDefault, "the method to use for authentication (e.g. auto, jwt, none, ...)")
 	flag.StringVar(&apiVersion, "api_version", apiVersionDefault, "which xds api major version to use (e.g. v2, v3, ...)")
 	flag.StringVar(&requestFile, "request_file", requestFileDefault, "yaml file that defines the csds request")
 	flag.StringVar(&requestYaml, "request_yaml", requestYamlDefault, "yaml string that defines the csds request")
+	flag.StringVar(&requestName, "request_name", requestNameDefault, "the name of the query in the queries of the request to send, or all the queries if it is not set")
 	flag.StringVar(&jwt, "jwt_file", jwtDefault, "path of the -jwt_file")
 	flag.StringVar(&configFile, "output_file", configFileDefault, "file name to save configs returned by csds response")
 	flag.DurationVar(&monitorInterval, "monitor_interval", monitorIntervalDefault, "the interval of sending request in monitor mode (e.g. 500ms, 2s, 1m ...)")
 	flag.BoolVar(&visualization, "visualization", visualizationDefault, "option to visualize the relationship between xDS")
 	flag.StringVar(&filterMode, "filter_mode", filterModeDefault, "the filter mode for the filter on xDS nodes to be returned (e.g. prefix, suffix, regex, ...)")
 	flag.StringVar(&filterPattern, "filter_pattern", filterPatternDefault, "the filter pattern for the filter on xDS nodes to be returned")
+	flag.StringVar(&filter, "filter", filterDefault, "the filter expression on xDS clients to be returned (e.g. \"zone=us-east1-b and CDS=STALE\", \"cluster=foo\", ...)")
+	flag.StringVar(&query, "query", queryDefault, "the JSONPath query to run on each xDS client config (e.g. \"$..routes[?(@.route.retryPolicy.numRetries > 3)].name\", ...)")
+	flag.BoolVar(&noRedact, "no_redact", noRedactDefault, "option to output the config without redacting secrets and sensitive fields")
+	flag.StringVar(&redactFields, "redact_fields", redactFieldsDefault, "comma separated proto fields to redact in addition to the secrets (e.g. HeaderValue.value,FileAccessLog.path, ...)")
+	flag.BoolVar(&summary, "summary", summaryDefault, "option to print a fleet-wide summary instead of the per client config")
+	flag.StringVar(&groupByMetadata, "group_by_metadata", groupByMetadataDefault, "comma separated node metadata keys to group clients by in the summary (e.g. TRAFFICDIRECTOR_NETWORK_NAME)")
+	flag.StringVar(&source, "source", sourceDefault, "where to get the xDS config from (e.g. csds, envoy_admin, ...)")
+	flag.StringVar(&adminUri, "admin_uri", adminUriDefault, "the address of the Envoy admin to get the config dump from with -source envoy_admin")
+	flag.StringVar(&servePath, "serve_path", servePathDefault, "the file or directory of CSDS responses and Envoy config dumps (json or yaml) to serve with the serve command")
+	flag.StringVar(&listenAddress, "listen_address", listenAddressDefault, "the address to serve on with the serve, proxy and gateway commands")
+	flag.StringVar(&contextName, "context", contextNameDefault, "the context in the contexts file to use instead of the current context")
+	flag.StringVar(&contextsFile, "contexts_file", clientutil.DefaultContextsPath(), "the contexts file that defines the named contexts of flag values")
+	flag.StringVar(&nodeId, "node_id", nodeIdDefault, "the node id of the clients to request, added to the NodeMatchers of the request")
+	flag.StringVar(&nodeIdPrefix, "node_id_prefix", nodeIdPrefixDefault, "the node id prefix of the clients to request, added to the NodeMatchers of the request")
+	flag.Var(&metadata, "metadata", "the `KEY=VALUE` node metadata of the clients to request, added to the NodeMatchers of the request (repeatable)")
+	flag.StringVar(&gcpProject, "gcp_project", gcpProjectDefault, "the gcp project number of the clients to request, i.e. -metadata TRAFFICDIRECTOR_GCP_PROJECT_NUMBER=<value>")
+	flag.StringVar(&gcpNetwork, "gcp_network", gcpNetworkDefault, "the network name of the clients to request, i.e. -metadata TRAFFICDIRECTOR_NETWORK_NAME=<value>")
+	flag.StringVar(&gcpMesh, "gcp_mesh", gcpMeshDefault, "the mesh scope name of the clients to request, i.e. -metadata TRAFFICDIRECTOR_MESH_SCOPE_NAME=<value>")
+	flag.StringVar(&historyDir, "history_dir", historyDirDefault, "the directory of the history store, in which the changes of the clients are recorded on every response")
+	flag.StringVar(&historyNode, "node", historyNodeDefault, "the id of a client in the history, or a part of it that matches only one client")
+	flag.Var(&historyAt, "at", "the `time` at which to restore the clients from the history, e.g. 2006-01-02T15:04:05Z, 2006-01-02 15:04, 15:04 or -1h")
+	flag.StringVar(&historySince, "since", historySinceDefault, "list the changes in the history since the `time`")
+	flag.StringVar(&historyUntil, "until", historyUntilDefault, "list the changes in the history until the `time`")
+	flag.StringVar(&hooksFile, "hooks_file", hooksFileDefault, "the yaml file of the hooks, the webhooks and commands invoked when a condition fires on the responses")
+	flag.StringVar(&dstAddress, "dst_address", dstAddressDefault, "the destination ip:port of the connection to match against the listeners")
+	flag.StringVar(&srcAddress, "src_address", srcAddressDefault, "the source ip or ip:port of the connection to match against the listeners (default 127.0.0.1)")
+	flag.StringVar(&sni, "sni", sniDefault, "the SNI of the TLS connection to match against the listeners")
+	flag.StringVar(&alpn, "alpn", alpnDefault, "comma separated ALPN protocols of the TLS connection to match against the listeners (e.g. h2,http/1.1)")
+	flag.DurationVar(&expiryWindow, "expiry_window", expiryWindowDefault, "flag the certificates that expire within the window with the certs command (e.g. 168h, 720h, ...)")
 }
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
+	// the command is the first positional argument. The flags before it are accepted for every
+	// command, and without a command the flags are the flags of get for backwards compatibility.
+	name, args := "get", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command: %v\n", name)
+		if suggestion := clientutil.Suggest(name, commandNames()); suggestion != "" {
+			fmt.Fprintf(os.Stderr, "Did you mean %v?\n", suggestion)
+		}
+		fmt.Fprintln(os.Stderr)
+		usage()
+		os.Exit(2)
+	}
+	fs := cmd.flagSet()
+	fs.Parse(args)
 
-	clientOpts := client.ClientOptions{
+	setFlags = make(map[string]bool)
+	for _, set := range []*flag.FlagSet{flag.CommandLine, fs} {
+		set.Visit(func(f *flag.Flag) {
+			setFlags[f.Name] = true
+		})
+	}
+	if err := cmd.run(fs.Args()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// clientOptions returns the client options from the flags
+func clientOptions() client.ClientOptions {
+	return client.ClientOptions{
 		Uri:             uri,
+		UriFile:         uriFile,
+		Parallelism:     parallelism,
 		Platform:        platform,
 		AuthnMode:       authnMode,
 		RequestFile:     requestFile,
 		RequestYaml:     requestYaml,
+		RequestName:     requestName,
 		Jwt:             jwt,
 		ConfigFile:      configFile,
 		MonitorInterval: monitorInterval,
 		Visualization:   visualization,
 		FilterMode:      filterMode,
 		FilterPattern:   filterPattern,
+		Filter:          filter,
+		Query:           query,
+		NoRedact:        noRedact,
+		RedactFields:    clientutil.SplitList(redactFields),
+		Summary:         summary,
+		GroupByMetadata: clientutil.SplitList(groupByMetadata),
+		Source:          source,
+		AdminUri:        adminUri,
+		ServePath:       servePath,
+		ListenAddress:   listenAddress,
+		NodeId:          nodeId,
+		NodeIdPrefix:    nodeIdPrefix,
+		Metadata:        metadata,
+		GcpProject:      gcpProject,
+		GcpNetwork:      gcpNetwork,
+		GcpMesh:         gcpMesh,
+		HistoryDir:      historyDir,
+		HooksFile:       hooksFile,
 	}
+}
 
-	var c client.Client
-	var err error
+// newClient applies the context and creates the client of -api_version
+func newClient() (client.Client, error) {
+	contexts, err := clientutil.LoadContexts(contextsFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyContext(contexts, contextName); err != nil {
+		return nil, err
+	}
 	switch apiVersion {
 	case "v2":
-		c, err = client_v2.New(clientOpts)
+		return client_v2.New(clientOptions())
 	case "v3":
-		c, err = client_v3.New(clientOpts)
+		return client_v3.New(clientOptions())
 	default:
-		log.Fatalf("Unsupported xDS API version: %v", apiVersion)
+		return nil, fmt.Errorf("Unsupported xDS API version: %v", apiVersion)
 	}
+}
 
-	if err != nil {
-		log.Fatal(err)
+// applyContext sets the flags to the values in the context, or in the current context if the name is
+// empty. The flags set on the command line override the values in the context. As -request_yaml
+// is merged with -request_file, -request_yaml on the command line is merged with the request_file
+// of the context.
+func applyContext(contexts *clientutil.ContextsFile, name string) error {
+	ctx, err := contexts.Context(name)
+	if err != nil || ctx == nil {
+		return err
 	}
-
-	if err := c.Run(); err != nil {
-		log.Fatal(err)
+	// -service_uri and -service_uri_file are alternatives, so either on the command line overrides both
+	if setFlags["service_uri"] || setFlags["service_uri_file"] {
+		setFlags["service_uri"], setFlags["service_uri_file"] = true, true
+	}
+	for name, value := range contexts.FlagValues(ctx) {
+		if setFlags[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %v in context %v: %v", name, ctx.Name, err)
+		}
 	}
+	return nil
 }
@@ -0,0 +1,414 @@
+// Package tui is an interactive terminal UI to browse the xDS clients in CSDS responses and drill
+// down into their listeners, routes, clusters and endpoints. It works on the api version
+// independent view of the clients, so it is shared by the v2 and v3 clients.
+package tui
+
+import (
+	clientutil "envoy-tools/csds-client/client/util"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Action is what the UI needs to do after a key is handled
+type Action int
+
+const (
+	// None means only the screen needs to be redrawn
+	None Action = iota
+	// Refresh means the clients need to be fetched again
+	Refresh
+	// Quit means the UI needs to exit
+	Quit
+)
+
+// the keys that are not a single character
+const (
+	KeyUp        = "up"
+	KeyDown      = "down"
+	KeyLeft      = "left"
+	KeyRight     = "right"
+	KeyEnter     = "enter"
+	KeyEsc       = "esc"
+	KeyBackspace = "backspace"
+	KeyTab       = "tab"
+	KeyPageUp    = "pgup"
+	KeyPageDown  = "pgdown"
+	KeyCtrlC     = "ctrl-c"
+)
+
+type view int
+
+const (
+	clientsView view = iota
+	resourcesView
+	configView
+)
+
+// the tabs of the resources of a client
+var tabs = []struct {
+	xds   string
+	title string
+}{
+	{"LDS", "Listeners"},
+	{"RDS", "Routes"},
+	{"CDS", "Clusters"},
+	{"EDS", "Endpoints"},
+}
+
+// statusRank orders the config statuses from the worst, so that sorting by status shows the clients
+// with problems first
+var statusRank = map[string]int{"ERROR": 0, "STALE": 1, "NOT_SENT": 2, "UNKNOWN": 3, "SYNCED": 4}
+
+// Model is the state of the UI. It handles the keys and renders the screen, and is independent of
+// the terminal.
+type Model struct {
+	configs        []clientutil.ClientConfig
+	err            error
+	updated        time.Time
+	redactedFields []string
+
+	view view
+	// the client list
+	cursor       int
+	filter       string
+	editing      bool
+	sortByStatus bool
+	// the resources of the selected client
+	client         string
+	tab            int
+	resourceCursor int
+	// the config of the selected resource
+	title  string
+	lines  []string
+	scroll int
+	// height is the number of rows of the list in the last render, used to page
+	height int
+}
+
+// NewModel creates the UI state, the configs of the resources are redacted with the fields
+func NewModel(redactedFields []string) *Model {
+	return &Model{redactedFields: redactedFields, height: 10}
+}
+
+// SetConfigs updates the clients after they are fetched, or the error if the fetch failed. The
+// clients shown before are kept on error.
+func (m *Model) SetConfigs(configs []clientutil.ClientConfig, err error, now time.Time) {
+	m.err = err
+	if err != nil {
+		return
+	}
+	m.configs = configs
+	m.updated = now
+}
+
+// clients returns the clients in the list, filtered and sorted
+func (m *Model) clients() []clientutil.ClientConfig {
+	var match func(c clientutil.ClientConfig) bool
+	if m.filter != "" {
+		// the filter is a -filter expression, or a substring of the client id otherwise
+		if filter, err := clientutil.ParseFilter(m.filter); err == nil {
+			match = filter.Match
+		} else {
+			match = func(c clientutil.ClientConfig) bool { return strings.Contains(c.Id, m.filter) }
+		}
+	}
+	var clients []clientutil.ClientConfig
+	for _, c := range m.configs {
+		if match == nil || match(c) {
+			clients = append(clients, c)
+		}
+	}
+	sort.SliceStable(clients, func(i, j int) bool {
+		if m.sortByStatus {
+			if ri, rj := worstStatus(clients[i]), worstStatus(clients[j]); ri != rj {
+				return ri < rj
+			}
+		}
+		return clients[i].Id < clients[j].Id
+	})
+	return clients
+}
+
+// worstStatus returns the rank of the worst config status of the client
+func worstStatus(c clientutil.ClientConfig) int {
+	worst := len(statusRank)
+	for _, status := range c.Statuses {
+		if rank, ok := statusRank[status.Status]; ok && rank < worst {
+			worst = rank
+		}
+	}
+	return worst
+}
+
+// selectedClient returns the client whose resources are shown
+func (m *Model) selectedClient() (clientutil.ClientConfig, bool) {
+	for _, c := range m.configs {
+		if c.Id == m.client {
+			return c, true
+		}
+	}
+	return clientutil.ClientConfig{}, false
+}
+
+// resources returns the resources of the selected client in the current tab
+func (m *Model) resources() []clientutil.Resource {
+	c, _ := m.selectedClient()
+	var resources []clientutil.Resource
+	for _, resource := range c.Resources {
+		if resource.Xds == tabs[m.tab].xds {
+			resources = append(resources, resource)
+		}
+	}
+	sort.SliceStable(resources, func(i, j int) bool { return resources[i].Name < resources[j].Name })
+	return resources
+}
+
+// Update handles a key and returns what the UI needs to do
+func (m *Model) Update(key string) Action {
+	if key == KeyCtrlC {
+		return Quit
+	}
+	if m.editing {
+		switch key {
+		case KeyEnter, KeyEsc:
+			m.editing = false
+		case KeyBackspace:
+			if len(m.filter) > 0 {
+				m.filter = m.filter[:len(m.filter)-1]
+			}
+		default:
+			if len(key) == 1 {
+				m.filter += key
+			}
+		}
+		m.cursor = 0
+		return None
+	}
+
+	switch key {
+	case "q":
+		return Quit
+	case "r":
+		return Refresh
+	}
+	switch m.view {
+	case clientsView:
+		clients := m.clients()
+		switch key {
+		case KeyUp, "k":
+			m.cursor--
+		case KeyDown, "j":
+			m.cursor++
+		case KeyPageUp:
+			m.cursor -= m.height
+		case KeyPageDown:
+			m.cursor += m.height
+		case "/":
+			m.editing = true
+		case "s":
+			m.sortByStatus = !m.sortByStatus
+		case KeyEsc:
+			m.filter = ""
+		case KeyEnter, KeyRight:
+			if m.cursor < len(clients) {
+				m.client = clients[m.cursor].Id
+				m.tab, m.resourceCursor = 0, 0
+				m.view = resourcesView
+			}
+		}
+		m.cursor = clamp(m.cursor, len(m.clients()))
+	case resourcesView:
+		resources := m.resources()
+		switch key {
+		case KeyUp, "k":
+			m.resourceCursor--
+		case KeyDown, "j":
+			m.resourceCursor++
+		case KeyPageUp:
+			m.resourceCursor -= m.height
+		case KeyPageDown:
+			m.resourceCursor += m.height
+		case KeyTab, KeyRight, "l":
+			m.tab = (m.tab + 1) % len(tabs)
+			m.resourceCursor = 0
+		case KeyLeft, "h":
+			m.tab = (m.tab + len(tabs) - 1) % len(tabs)
+			m.resourceCursor = 0
+		case KeyEsc, KeyBackspace:
+			m.view = clientsView
+		case KeyEnter:
+			if m.resourceCursor < len(resources) {
+				m.showConfig(resources[m.resourceCursor])
+			}
+		}
+		m.resourceCursor = clamp(m.resourceCursor, len(m.resources()))
+	case configView:
+		switch key {
+		case KeyUp, "k":
+			m.scroll--
+		case KeyDown, "j":
+			m.scroll++
+		case KeyPageUp:
+			m.scroll -= m.height
+		case KeyPageDown, " ":
+			m.scroll += m.height
+		case KeyEsc, KeyBackspace, KeyLeft:
+			m.view = resourcesView
+		}
+		m.scroll = clamp(m.scroll, len(m.lines))
+	}
+	return None
+}
+
+// showConfig shows the json of the redacted config of the resource
+func (m *Model) showConfig(resource clientutil.Resource) {
+	m.view = configView
+	m.title = fmt.Sprintf("%v %v (version %v, %v)", resource.Xds, resource.Name, resource.Version, resource.Status)
+	m.scroll = 0
+	m.lines = []string{"No config is reported for this resource."}
+	if resource.Config == nil {
+		return
+	}
+	config, err := clientutil.DecodeResource(resource.Config)
+	if err == nil && m.redactedFields != nil {
+		config, err = clientutil.Redact(config, m.redactedFields)
+	}
+	var js []byte
+	if err == nil {
+		js, err = protojson.MarshalOptions{Multiline: true, Indent: "  ", Resolver: &clientutil.TypeResolver{}}.Marshal(config)
+	}
+	if err != nil {
+		m.lines = []string{fmt.Sprintf("Unable to show the config: %v", err)}
+		return
+	}
+	m.lines = strings.Split(string(js), "\n")
+}
+
+// clamp keeps the cursor in the list of n items
+func clamp(cursor int, n int) int {
+	if cursor >= n {
+		cursor = n - 1
+	}
+	if cursor < 0 {
+		cursor = 0
+	}
+	return cursor
+}
+
+// Render renders the screen as lines that fit the width and height of the terminal
+func (m *Model) Render(width int, height int) []string {
+	var header, rows []string
+	var cursor int
+	var help string
+	switch m.view {
+	case clientsView:
+		sortBy := "id"
+		if m.sortByStatus {
+			sortBy = "status"
+		}
+		filter := m.filter
+		if m.editing {
+			filter += "_"
+		}
+		clients := m.clients()
+		header = []string{
+			fmt.Sprintf("xDS clients: %d of %d    filter: %v    sort by: %v", len(clients), len(m.configs), filter, sortBy),
+			"",
+			fmt.Sprintf("%-50s %-20s %v", "Client ID", "xDS stream type", "Config Status"),
+		}
+		for _, c := range clients {
+			var statuses []string
+			for _, status := range c.Statuses {
+				statuses = append(statuses, status.Xds+" "+status.Status)
+			}
+			rows = append(rows, fmt.Sprintf("%-50s %-20s %v", c.Id, c.StreamType, strings.Join(statuses, ", ")))
+		}
+		cursor = m.cursor
+		help = "↑/↓ move  enter open  / filter  s sort  r refresh  q quit"
+	case resourcesView:
+		var titles []string
+		for i, tab := range tabs {
+			if i == m.tab {
+				titles = append(titles, "["+tab.title+"]")
+			} else {
+				titles = append(titles, " "+tab.title+" ")
+			}
+		}
+		header = []string{
+			"Client: " + m.client,
+			strings.Join(titles, " "),
+			fmt.Sprintf("%-50s %-30s %-12s %v", "Name", "Version", "Status", "Client Status"),
+		}
+		if _, ok := m.selectedClient(); !ok {
+			header[0] += " (no longer reported)"
+		}
+		for _, resource := range m.resources() {
+			rows = append(rows, fmt.Sprintf("%-50s %-30s %-12s %v", resource.Name, resource.Version, resource.Status, resource.ClientStatus))
+		}
+		cursor = m.resourceCursor
+		help = "↑/↓ move  ←/→ tab  enter config  esc back  r refresh  q quit"
+	case configView:
+		header = []string{"Client: " + m.client, m.title, ""}
+		rows = m.lines
+		cursor = -1
+		help = "↑/↓ scroll  esc back  r refresh  q quit"
+	}
+
+	footer := help
+	if m.err != nil {
+		footer = "Error: " + m.err.Error() + "    " + help
+	} else if !m.updated.IsZero() {
+		footer = "Updated " + m.updated.Format("15:04:05") + "    " + help
+	}
+
+	// the rows are scrolled so that the cursor is visible
+	m.height = height - len(header) - 2
+	if m.height < 1 {
+		m.height = 1
+	}
+	start := 0
+	if m.view == configView {
+		start = m.scroll
+	} else if cursor >= m.height {
+		start = cursor - m.height + 1
+	}
+	lines := append([]string{}, header...)
+	for i := start; i < len(rows) && i < start+m.height; i++ {
+		line := truncate(rows[i], width-2)
+		if i == cursor {
+			lines = append(lines, "\x1b[7m> "+pad(line, width-2)+"\x1b[0m")
+		} else {
+			lines = append(lines, "  "+line)
+		}
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	for i := range lines[:len(header)] {
+		lines[i] = truncate(lines[i], width)
+	}
+	return append(lines, truncate(footer, width))
+}
+
+// truncate cuts the line to the width
+func truncate(line string, width int) string {
+	if width < 0 {
+		width = 0
+	}
+	if r := []rune(line); len(r) > width {
+		return string(r[:width])
+	}
+	return line
+}
+
+// pad pads the line with spaces to the width
+func pad(line string, width int) string {
+	if n := width - len([]rune(line)); n > 0 {
+		return line + strings.Repeat(" ", n)
+	}
+	return line
+}
@@ -0,0 +1,55 @@
+package tui
+
+import (
+	clientutil "envoy-tools/csds-client/client/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTuiModel tests browsing the clients in the terminal UI
+func TestTuiModel(t *testing.T) {
+	configs := clientutil.ReadClientConfigs(t, "./response_for_summary.json")
+	configs = append(configs, clientutil.ReadClientConfigs(t, "./response_for_redaction.json")[0])
+	redactedId := configs[len(configs)-1].Id
+
+	m := NewModel(clientutil.DefaultRedactedFields)
+	m.SetConfigs(configs, nil, time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC))
+	render := func() string { return strings.Join(m.Render(120, 20), "\n") }
+
+	// sorting by status shows the client with a STALE cluster first
+	m.Update("s")
+	if out := render(); !strings.Contains(out, "> test_node_2") || !strings.Contains(out, "Updated 12:00:00") {
+		t.Errorf("want test_node_2 selected first when sorted by status, got\n%v", out)
+	}
+	// filtering with a -filter expression
+	for _, key := range []string{"/", "z", "o", "n", "e", "=", "x", KeyBackspace, KeyEnter} {
+		m.Update(key)
+	}
+	if out := render(); !strings.Contains(out, "xDS clients: 0 of 4") {
+		t.Errorf("want no client in zone x, got\n%v", out)
+	}
+	m.Update(KeyEsc)
+	m.Update("s")
+
+	// drill down into the clusters of the client with the redacted secrets
+	for i := 0; i < len(configs) && !strings.Contains(render(), "> "+redactedId); i++ {
+		m.Update(KeyDown)
+	}
+	m.Update(KeyEnter)
+	m.Update(KeyTab)
+	m.Update(KeyTab)
+	if out := render(); !strings.Contains(out, "[Clusters]") || !strings.Contains(out, "Client: "+redactedId) {
+		t.Errorf("want the clusters of %v, got\n%v", redactedId, out)
+	}
+	m.Update(KeyEnter)
+	out := strings.Join(m.Render(120, 200), "\n")
+	if !strings.Contains(out, "[redacted]") || strings.Contains(out, "fake_password") || strings.Contains(out, "ZmFrZV9wcml2YXRlX2tleQ==") {
+		t.Errorf("want the cluster config with the private key redacted, got\n%v", out)
+	}
+	m.Update(KeyEsc)
+	m.Update(KeyEsc)
+	if action := m.Update("q"); action != Quit {
+		t.Errorf("want quit on q, got %v", action)
+	}
+}
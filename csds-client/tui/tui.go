@@ -0,0 +1,147 @@
+package tui
+
+import (
+	"bufio"
+	clientutil "envoy-tools/csds-client/client/util"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// FetchFunc fetches the clients to show
+type FetchFunc func() ([]clientutil.ClientConfig, error)
+
+type fetchResult struct {
+	configs []clientutil.ClientConfig
+	err     error
+}
+
+// Run runs the UI in the terminal until it is quit. The clients are fetched at the start, on every
+// interval if it is not zero, and when refreshed with the r key.
+func Run(fetch FetchFunc, interval time.Duration, redactedFields []string) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("tui requires a terminal")
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+	// switch to the alternate screen and hide the cursor, and switch back on exit
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go readKeys(bufio.NewReader(os.Stdin), keys)
+	results := make(chan fetchResult, 1)
+	fetching := false
+	refresh := func() {
+		if fetching {
+			return
+		}
+		fetching = true
+		go func() {
+			configs, err := fetch()
+			results <- fetchResult{configs, err}
+		}()
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	m := NewModel(redactedFields)
+	refresh()
+	for {
+		draw(m)
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			switch m.Update(key) {
+			case Quit:
+				return nil
+			case Refresh:
+				refresh()
+			}
+		case result := <-results:
+			fetching = false
+			m.SetConfigs(result.configs, result.err, time.Now())
+		case <-tick:
+			refresh()
+		}
+	}
+}
+
+// draw renders the model to the whole terminal
+func draw(m *Model) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	// the terminal is in raw mode, so each line needs a carriage return
+	fmt.Print("\x1b[H\x1b[2J" + strings.Join(m.Render(width, height), "\r\n"))
+}
+
+// readKeys reads the keys from the terminal in raw mode, and closes the channel when the input ends
+func readKeys(r *bufio.Reader, keys chan<- string) {
+	defer close(keys)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case 3:
+			keys <- KeyCtrlC
+		case '\r', '\n':
+			keys <- KeyEnter
+		case '\t':
+			keys <- KeyTab
+		case 8, 127:
+			keys <- KeyBackspace
+		case 27:
+			// an escape sequence follows immediately, a single escape is the esc key
+			if r.Buffered() == 0 {
+				keys <- KeyEsc
+				continue
+			}
+			seq, _ := r.ReadByte()
+			if seq != '[' && seq != 'O' {
+				keys <- KeyEsc
+				continue
+			}
+			code, _ := r.ReadByte()
+			switch code {
+			case 'A':
+				keys <- KeyUp
+			case 'B':
+				keys <- KeyDown
+			case 'C':
+				keys <- KeyRight
+			case 'D':
+				keys <- KeyLeft
+			case '5', '6':
+				// page up and page down end with ~
+				r.ReadByte()
+				if code == '5' {
+					keys <- KeyPageUp
+				} else {
+					keys <- KeyPageDown
+				}
+			}
+		default:
+			if b >= 32 && b < 127 {
+				keys <- string(b)
+			}
+		}
+	}
+}